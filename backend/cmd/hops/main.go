@@ -5,14 +5,17 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"net/netip"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/weaversgrainthorpe/HOPS/internal/api"
 	"github.com/weaversgrainthorpe/HOPS/internal/auth"
 	"github.com/weaversgrainthorpe/HOPS/internal/config"
 	"github.com/weaversgrainthorpe/HOPS/internal/database"
+	"github.com/weaversgrainthorpe/HOPS/internal/notify"
 	"github.com/weaversgrainthorpe/HOPS/internal/status"
 	"github.com/weaversgrainthorpe/HOPS/internal/version"
 )
@@ -22,6 +25,46 @@ func main() {
 	port := flag.String("port", "8080", "Port to run the server on")
 	dataDir := flag.String("data", "../data", "Data directory for SQLite database")
 	frontendDir := flag.String("frontend", "../frontend/build", "Frontend build directory")
+	sessionIdleTimeout := flag.Duration("session-idle-timeout", 24*time.Hour, "Sign sessions out after this long without requests (0 to disable)")
+	sessionMaxAge := flag.Duration("session-max-age", 7*24*time.Hour, "Sign sessions out this long after login, even if in use")
+	secureCookies := flag.Bool("secure-cookies", false, "Always mark session cookies HTTPS-only (automatic for HTTPS requests)")
+	passwordMinLength := flag.Int("password-min-length", 8, "Minimum length of new passwords")
+	passwordCheckBreached := flag.Bool("password-check-breached", true, "Reject new passwords on the bundled list of common, breached passwords")
+	passwordHistory := flag.Int("password-history", 5, "Reject new passwords matching the current one or this many before it (0 to allow reuse)")
+	loginRateLimit := flag.Int("rate-limit-login", 20, "Login attempts a minute per client IP")
+	readRateLimit := flag.Int("rate-limit-read", 600, "API reads a minute per client IP (0 for no limit)")
+	writeRateLimit := flag.Int("rate-limit-write", 120, "API writes a minute per client IP (0 for no limit)")
+	uploadRateLimit := flag.Int("rate-limit-upload", 20, "Uploads a minute per client IP (0 for no limit)")
+	lockoutThreshold := flag.Int("lockout-threshold", 5, "Failed logins for a username before it is locked out (0 to disable)")
+	lockoutDelay := flag.Duration("lockout-delay", 30*time.Second, "First lockout after -lockout-threshold failed logins; each further failure doubles it")
+	lockoutMaxDelay := flag.Duration("lockout-max-delay", time.Hour, "Longest lockout after failed logins")
+	auditRetention := flag.Duration("audit-retention", 90*24*time.Hour, "Delete audit log entries older than this (0 to keep them)")
+	auditMaxEntries := flag.Int("audit-max-entries", 100000, "Keep at most this many audit log entries (0 for no limit)")
+	configHistory := flag.Duration("config-history-retention", 0, "Delete config revisions older than this, except the current one (0 to keep them)")
+	configHistoryMax := flag.Int("config-history-max-entries", 1000, "Keep at most this many config revisions (0 for no limit)")
+	statusHistoryRaw := flag.Duration("status-history-raw-retention", 48*time.Hour, "Delete individual status check results older than this (0 to keep them)")
+	statusHistory := flag.Duration("status-history-retention", 90*24*time.Hour, "Delete hourly status check totals older than this (0 to keep them)")
+	notifyThreshold := flag.Int("status-notify-threshold", 2, "Consecutive status check results that must agree before a change is notified")
+
+	// OpenID Connect single sign-on flags
+	oidcIssuer := flag.String("oidc-issuer", "", "OpenID Connect issuer URL (enables single sign-on)")
+	oidcClientID := flag.String("oidc-client-id", "", "OpenID Connect client ID")
+	oidcClientSecret := flag.String("oidc-client-secret", os.Getenv("HOPS_OIDC_CLIENT_SECRET"), "OpenID Connect client secret (default $HOPS_OIDC_CLIENT_SECRET)")
+	oidcRedirectURL := flag.String("oidc-redirect-url", "", "OpenID Connect redirect URL, e.g. https://hops.example.com/api/auth/oidc/callback")
+	oidcScopes := flag.String("oidc-scopes", "openid profile email groups", "OpenID Connect scopes to request")
+	oidcUsernameClaim := flag.String("oidc-username-claim", "preferred_username", "Claim to use as the HOPS username")
+	oidcRoleClaim := flag.String("oidc-role-claim", "groups", "Claim holding the user's groups or roles")
+	oidcRoleMap := flag.String("oidc-role-map", "", "Claim values to HOPS roles, e.g. hops-admins=admin,hops-editors=editor")
+	oidcDefaultRole := flag.String("oidc-default-role", auth.RoleViewer, "Role for users no -oidc-role-map entry matches (empty to deny them)")
+	oidcAutoProvision := flag.Bool("oidc-auto-provision", true, "Create HOPS users on their first single sign-on")
+
+	// Reverse proxy authentication flags
+	trustedProxies := flag.String("trusted-proxies", "", "Comma-separated IPs or CIDRs of reverse proxies whose headers are trusted")
+	proxyAuthUserHeader := flag.String("proxy-auth-user-header", "", "Header with the username set by a trusted proxy, e.g. Remote-User (enables proxy authentication)")
+	proxyAuthGroupsHeader := flag.String("proxy-auth-groups-header", "Remote-Groups", "Header with the user's comma-separated groups set by a trusted proxy")
+	proxyAuthRoleMap := flag.String("proxy-auth-role-map", "", "Groups to HOPS roles, e.g. admins=admin,editors=editor")
+	proxyAuthDefaultRole := flag.String("proxy-auth-default-role", auth.RoleViewer, "Role for users no -proxy-auth-role-map entry matches (empty to deny them)")
+	proxyAuthAutoProvision := flag.Bool("proxy-auth-auto-provision", true, "Create HOPS users on their first request through the proxy")
 	flag.Parse()
 
 	// Initialize configuration
@@ -29,7 +72,105 @@ func main() {
 		Port:                 *port,
 		DataDir:              *dataDir,
 		FrontendDir:          *frontendDir,
-		LoginRateLimitPerMin: 20, // 20 login attempts per minute
+		LoginRateLimitPerMin: *loginRateLimit,
+		SessionIdleTimeout:   *sessionIdleTimeout,
+		SessionMaxAge:        *sessionMaxAge,
+		SecureCookies:        *secureCookies,
+		AuditRetention:       *auditRetention,
+		AuditMaxEntries:      *auditMaxEntries,
+		ConfigHistory:        *configHistory,
+		ConfigHistoryMax:     *configHistoryMax,
+		StatusHistoryRaw:     *statusHistoryRaw,
+		StatusHistory:        *statusHistory,
+		NotifyThreshold:      *notifyThreshold,
+		RateLimits: config.RateLimits{
+			Read:   *readRateLimit,
+			Write:  *writeRateLimit,
+			Upload: *uploadRateLimit,
+		},
+		PasswordPolicy: config.PasswordPolicy{
+			MinLength:     *passwordMinLength,
+			CheckBreached: *passwordCheckBreached,
+			History:       *passwordHistory,
+		},
+		LoginLockout: config.LoginLockout{
+			Threshold: *lockoutThreshold,
+			Delay:     *lockoutDelay,
+			MaxDelay:  *lockoutMaxDelay,
+		},
+		OIDC: config.OIDCConfig{
+			Issuer:        *oidcIssuer,
+			ClientID:      *oidcClientID,
+			ClientSecret:  *oidcClientSecret,
+			RedirectURL:   *oidcRedirectURL,
+			Scopes:        strings.Fields(*oidcScopes),
+			UsernameClaim: *oidcUsernameClaim,
+			RoleClaim:     *oidcRoleClaim,
+			DefaultRole:   *oidcDefaultRole,
+			AutoProvision: *oidcAutoProvision,
+		},
+		ProxyAuth: config.ProxyAuthConfig{
+			UserHeader:    *proxyAuthUserHeader,
+			GroupsHeader:  *proxyAuthGroupsHeader,
+			DefaultRole:   *proxyAuthDefaultRole,
+			AutoProvision: *proxyAuthAutoProvision,
+		},
+	}
+	proxies, err := parsePrefixes(*trustedProxies)
+	if err != nil {
+		log.Fatalf("Invalid -trusted-proxies: %v", err)
+	}
+	cfg.TrustedProxies = proxies
+	if cfg.OIDC.Enabled() {
+		roleMap, err := parseRoleMap(*oidcRoleMap)
+		if err != nil {
+			log.Fatalf("Invalid -oidc-role-map: %v", err)
+		}
+		cfg.OIDC.RoleMap = roleMap
+		if cfg.OIDC.ClientID == "" || cfg.OIDC.RedirectURL == "" {
+			log.Fatalf("-oidc-client-id and -oidc-redirect-url are required with -oidc-issuer")
+		}
+		if cfg.OIDC.DefaultRole != "" && !auth.ValidRole(cfg.OIDC.DefaultRole) {
+			log.Fatalf("Invalid -oidc-default-role %q", cfg.OIDC.DefaultRole)
+		}
+	}
+	if cfg.ProxyAuth.Enabled() {
+		roleMap, err := parseRoleMap(*proxyAuthRoleMap)
+		if err != nil {
+			log.Fatalf("Invalid -proxy-auth-role-map: %v", err)
+		}
+		cfg.ProxyAuth.RoleMap = roleMap
+		if len(cfg.TrustedProxies) == 0 {
+			log.Fatalf("-trusted-proxies is required with -proxy-auth-user-header")
+		}
+		if cfg.ProxyAuth.DefaultRole != "" && !auth.ValidRole(cfg.ProxyAuth.DefaultRole) {
+			log.Fatalf("Invalid -proxy-auth-default-role %q", cfg.ProxyAuth.DefaultRole)
+		}
+	}
+
+	if cfg.SessionMaxAge <= 0 || cfg.SessionIdleTimeout < 0 {
+		log.Fatalf("-session-max-age must be positive and -session-idle-timeout must not be negative")
+	}
+	if cfg.PasswordPolicy.MinLength < 1 || cfg.PasswordPolicy.History < 0 {
+		log.Fatalf("-password-min-length must be at least 1 and -password-history must not be negative")
+	}
+	if cfg.RateLimits.Read < 0 || cfg.RateLimits.Write < 0 || cfg.RateLimits.Upload < 0 {
+		log.Fatalf("-rate-limit-read, -rate-limit-write and -rate-limit-upload must not be negative")
+	}
+	if cfg.LoginLockout.Threshold < 0 || cfg.LoginLockout.Delay <= 0 || cfg.LoginLockout.MaxDelay < cfg.LoginLockout.Delay {
+		log.Fatalf("-lockout-threshold must not be negative, -lockout-delay must be positive and -lockout-max-delay must be at least -lockout-delay")
+	}
+	if cfg.AuditRetention < 0 || cfg.AuditMaxEntries < 0 {
+		log.Fatalf("-audit-retention and -audit-max-entries must not be negative")
+	}
+	if cfg.ConfigHistory < 0 || cfg.ConfigHistoryMax < 0 {
+		log.Fatalf("-config-history-retention and -config-history-max-entries must not be negative")
+	}
+	if cfg.StatusHistoryRaw < 0 || cfg.StatusHistory < 0 {
+		log.Fatalf("-status-history-raw-retention and -status-history-retention must not be negative")
+	}
+	if cfg.NotifyThreshold < 1 {
+		log.Fatalf("-status-notify-threshold must be at least 1")
 	}
 
 	// Ensure data directory exists
@@ -52,28 +193,98 @@ func main() {
 	}
 
 	// Initialize auth service
-	authService := auth.NewService(db)
+	authService := auth.NewService(db, cfg)
 
 	// Start session cleanup routine (every hour)
 	sessionCleanupStop := make(chan struct{})
 	authService.StartCleanupRoutine(1*time.Hour, sessionCleanupStop)
 	defer close(sessionCleanupStop)
 
-	// Initialize status checker (checks every 5 minutes)
+	// Start audit log cleanup routine (every hour)
+	auditCleanupStop := make(chan struct{})
+	database.NewAuditStore(db).StartCleanupRoutine(1*time.Hour, cfg.AuditRetention, cfg.AuditMaxEntries, auditCleanupStop)
+	defer close(auditCleanupStop)
+
+	// Start config history cleanup routine (every hour)
+	configHistoryCleanupStop := make(chan struct{})
+	database.NewConfigStore(db).StartHistoryCleanupRoutine(1*time.Hour, cfg.ConfigHistory, cfg.ConfigHistoryMax, configHistoryCleanupStop)
+	defer close(configHistoryCleanupStop)
+
+	// Start status history cleanup routine (every hour)
+	statusHistoryCleanupStop := make(chan struct{})
+	database.NewStatusHistoryStore(db).StartCleanupRoutine(1*time.Hour, cfg.StatusHistoryRaw, cfg.StatusHistory, statusHistoryCleanupStop)
+	defer close(statusHistoryCleanupStop)
+
+	// Initialize status checker (entries without an interval are checked every 5 minutes)
 	statusChecker := status.NewChecker(db, 5*time.Minute)
+
+	// Send notifications when entries go down or come back up. The notifier
+	// subscribes before the checker starts so that it sees the first results.
+	notifier := notify.NewNotifier(db, cfg.NotifyThreshold)
+	notifier.Start(statusChecker)
+	defer notifier.Stop()
+
 	statusChecker.Start()
 	defer statusChecker.Stop()
 
 	// Initialize API router
-	router := api.NewRouter(db, authService, cfg)
+	router := api.NewRouter(db, authService, cfg, statusChecker)
 
 	// Start server
 	addr := fmt.Sprintf(":%s", cfg.Port)
 	log.Printf("%s starting on %s", version.Full(), addr)
 	log.Printf("Data directory: %s", cfg.DataDir)
 	log.Printf("Frontend directory: %s", cfg.FrontendDir)
+	if cfg.OIDC.Enabled() {
+		log.Printf("Single sign-on: %s", cfg.OIDC.Issuer)
+	}
+	if cfg.ProxyAuth.Enabled() {
+		log.Printf("Reverse proxy authentication: %s header from %v", cfg.ProxyAuth.UserHeader, cfg.TrustedProxies)
+	}
 
 	if err := http.ListenAndServe(addr, router); err != nil {
 		log.Fatalf("Server failed to start: %v", err)
 	}
 }
+
+// parseRoleMap parses a comma-separated list of claim=role pairs
+func parseRoleMap(value string) (map[string]string, error) {
+	roleMap := make(map[string]string)
+	for _, pair := range strings.Split(value, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		claim, role, ok := strings.Cut(pair, "=")
+		claim, role = strings.TrimSpace(claim), strings.TrimSpace(role)
+		if !ok || claim == "" || !auth.ValidRole(role) {
+			return nil, fmt.Errorf("%q is not a claim=role pair with role admin, editor or viewer", pair)
+		}
+		roleMap[claim] = role
+	}
+	return roleMap, nil
+}
+
+// parsePrefixes parses a comma-separated list of IP addresses and CIDR prefixes
+func parsePrefixes(value string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		if !strings.Contains(item, "/") {
+			addr, err := netip.ParseAddr(item)
+			if err != nil {
+				return nil, err
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(item)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
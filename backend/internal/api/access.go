@@ -0,0 +1,57 @@
+package api
+
+import (
+	"hash/fnv"
+	"net/http"
+	"strconv"
+
+	"github.com/weaversgrainthorpe/HOPS/internal/auth"
+	"github.com/weaversgrainthorpe/HOPS/internal/configdoc"
+	"github.com/weaversgrainthorpe/HOPS/internal/models"
+)
+
+// optionalUser returns the user signed in with the request's session or a
+// read-scoped API token, or nil for guests. Public routes use it to tailor
+// their response to the caller. Users who must change their password are
+// treated as guests until they do.
+func (r *Router) optionalUser(req *http.Request) *models.User {
+	user, scopes, err := r.authenticate(req)
+	if err != nil || (scopes != nil && !auth.TokenAllows(scopes, auth.ScopeRead)) || user.MustChangePassword {
+		return nil
+	}
+	return user
+}
+
+// canViewDashboard reports whether user (nil for guests) may see dashboard.
+// Editors and admins can see every dashboard so that they can edit them.
+func canViewDashboard(user *models.User, dashboard map[string]interface{}) bool {
+	if user == nil {
+		return configdoc.Visible(dashboard, "", "")
+	}
+	if auth.HasRole(user.Role, auth.RoleEditor) {
+		return true
+	}
+	return configdoc.Visible(dashboard, user.Username, user.Role)
+}
+
+// visibleConfig returns doc without the dashboards user may not see, and
+// the number of dashboards removed
+func visibleConfig(doc map[string]interface{}, user *models.User) (map[string]interface{}, int) {
+	return configdoc.FilterDashboards(doc, func(dashboard map[string]interface{}) bool {
+		return canViewDashboard(user, dashboard)
+	})
+}
+
+// visibleETag returns the ETag for a filtered view of a config revision. It
+// names the dashboards the view contains, so a cached copy is not reused
+// once the caller can see more or fewer of them.
+func visibleETag(revision int64, doc map[string]interface{}) string {
+	hash := fnv.New32a()
+	for _, item := range configdoc.Items(doc, configdoc.LevelDashboard.Collection()) {
+		dashboard, _ := item.(map[string]interface{})
+		id, _ := dashboard["id"].(string)
+		hash.Write([]byte(id))
+		hash.Write([]byte{0})
+	}
+	return `"` + strconv.FormatInt(revision, 10) + "-" + strconv.FormatUint(uint64(hash.Sum32()), 16) + `"`
+}
@@ -0,0 +1,238 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/weaversgrainthorpe/HOPS/internal/database"
+	"github.com/weaversgrainthorpe/HOPS/internal/models"
+)
+
+// auditRecord collects what a request did, for its audit log entry.
+// authMiddleware fills in a default from the route (see auditAction), and
+// handlers can add details with setAudit.
+type auditRecord struct {
+	action  string
+	target  string
+	summary string
+}
+
+// setAudit describes the request's action for the audit log. Empty values
+// keep what auditAction derived from the route. Setting an action on a GET
+// request, such as a config export, records it too.
+func setAudit(req *http.Request, action, target, summary string) {
+	record, _ := req.Context().Value(auditKey).(*auditRecord)
+	if record == nil {
+		return
+	}
+	if action != "" {
+		record.action = action
+	}
+	if target != "" {
+		record.target = target
+	}
+	if summary != "" {
+		record.summary = summary
+	}
+}
+
+// withAudit records next's request in the audit log once it has been handled
+func (r *Router) withAudit(user *models.User, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		record := &auditRecord{}
+		record.action, record.target = auditAction(req)
+		recorder := &statusRecorder{ResponseWriter: w}
+
+		next(recorder, req.WithContext(context.WithValue(req.Context(), auditKey, record)))
+
+		if record.action != "" {
+			r.recordAudit(req, user, record.action, record.target, record.summary, recorder.statusCode())
+		}
+	}
+}
+
+// recordAudit adds an entry to the audit log. user may be nil for anonymous
+// requests such as failed logins.
+func (r *Router) recordAudit(req *http.Request, user *models.User, action, target, summary string, status int) {
+	entry := models.AuditEntry{
+		IP:      r.clientIP(req),
+		Action:  action,
+		Target:  target,
+		Summary: summary,
+		Status:  status,
+	}
+	if user != nil {
+		id := user.ID
+		entry.UserID = &id
+		entry.Username = user.Username
+	}
+	if err := r.auditStore.Record(entry); err != nil {
+		log.Printf("[Audit] %v", err)
+	}
+}
+
+// auditResources names the resources of routes whose audit action is the
+// resource and a verb for the request method, e.g. icon.delete
+var auditResources = map[string]string{
+	"dashboards":             "dashboard",
+	"icons":                  "icon",
+	"icon-categories":        "icon_category",
+	"backgrounds":            "background",
+	"backgrounds/categories": "background_category",
+	"users":                  "user",
+	"tokens":                 "token",
+	"shares":                 "share",
+	"notifications":          "notification",
+}
+
+// auditAction returns the audit log action and target for a request, or ""
+// for requests that change nothing and are not recorded
+func auditAction(req *http.Request) (action, target string) {
+	if safeMethod(req.Method) {
+		return "", ""
+	}
+	path := strings.Trim(strings.TrimPrefix(req.URL.Path, "/api/"), "/")
+
+	switch {
+	case path == "config/validate":
+		return "", ""
+	case path == "config", path == "config/update":
+		return "config.update", ""
+	case path == "config/import":
+		return "config.import", ""
+	case strings.HasPrefix(path, "config/history/"):
+		revision, _, _ := strings.Cut(strings.TrimPrefix(path, "config/history/"), "/")
+		return "config.rollback", revision
+	case path == "auth/logout":
+		return "auth.logout", ""
+	case path == "auth/change-password":
+		return "auth.change_password", ""
+	case path == "auth/sessions":
+		return "session.revoke_others", ""
+	case strings.HasPrefix(path, "auth/sessions/"):
+		return "session.revoke", strings.TrimPrefix(path, "auth/sessions/")
+	case path == "auth/totp":
+		return "totp.disable", ""
+	case strings.HasPrefix(path, "auth/totp/"):
+		return "totp." + strings.ReplaceAll(strings.TrimPrefix(path, "auth/totp/"), "-", "_"), ""
+	case path == "icons/upload":
+		return "icon.upload", ""
+	case path == "backups":
+		return "backup.create", ""
+	case strings.HasPrefix(path, "backups/"):
+		if req.Method == http.MethodPost {
+			return "backup.restore", strings.TrimPrefix(path, "backups/")
+		}
+		return "backup.delete", strings.TrimPrefix(path, "backups/")
+	case strings.HasPrefix(path, "notifications/") && strings.HasSuffix(path, "/test"):
+		return "notification.test", strings.TrimSuffix(strings.TrimPrefix(path, "notifications/"), "/test")
+	case strings.HasPrefix(path, "users/") && strings.HasSuffix(path, "/totp"):
+		return "user.totp_reset", strings.TrimSuffix(strings.TrimPrefix(path, "users/"), "/totp")
+	case strings.HasPrefix(path, "users/") && strings.HasSuffix(path, "/lockout"):
+		return "user.unlock", strings.TrimSuffix(strings.TrimPrefix(path, "users/"), "/lockout")
+	}
+
+	verb := map[string]string{
+		http.MethodPost:   "create",
+		http.MethodPut:    "update",
+		http.MethodPatch:  "update",
+		http.MethodDelete: "delete",
+	}[req.Method]
+	if verb == "" {
+		verb = strings.ToLower(req.Method)
+	}
+
+	resource, rest, _ := strings.Cut(path, "/")
+	if strings.HasPrefix(path, "backgrounds/categories") {
+		resource, rest = "backgrounds/categories", strings.Trim(strings.TrimPrefix(path, "backgrounds/categories"), "/")
+	}
+	if name, ok := auditResources[resource]; ok {
+		return name + "." + verb, rest
+	}
+	return strings.ReplaceAll(path, "/", ".") + "." + verb, ""
+}
+
+// handleAudit lists audit log entries, newest first (admin). Supports the
+// user, action, target, since and until (RFC 3339) filters and limit
+// (default 50, max 500) and offset query parameters.
+func (r *Router) handleAudit(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := req.URL.Query()
+	filter := database.AuditFilter{
+		Username: query.Get("user"),
+		Action:   query.Get("action"),
+		Target:   query.Get("target"),
+	}
+	for name, t := range map[string]*time.Time{"since": &filter.Since, "until": &filter.Until} {
+		if value := query.Get(name); value != "" {
+			parsed, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Invalid %s: use an RFC 3339 time such as 2026-01-02T15:04:05Z", name), http.StatusBadRequest)
+				return
+			}
+			*t = parsed
+		}
+	}
+
+	limit := queryInt(req, "limit", 50)
+	if limit <= 0 || limit > 500 {
+		limit = 50
+	}
+	offset := queryInt(req, "offset", 0)
+	if offset < 0 {
+		offset = 0
+	}
+
+	entries, total, err := r.auditStore.List(filter, limit, offset)
+	if err != nil {
+		log.Printf("Failed to list audit log: %v", err)
+		http.Error(w, "Failed to load audit log", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, map[string]interface{}{
+		"entries": entries,
+		"total":   total,
+	})
+}
+
+// statusRecorder remembers the status code written to a response
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	if s.status == 0 {
+		s.status = status
+	}
+	s.ResponseWriter.WriteHeader(status)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	return s.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+// statusCode returns the response's status code
+func (s *statusRecorder) statusCode() int {
+	if s.status == 0 {
+		return http.StatusOK
+	}
+	return s.status
+}
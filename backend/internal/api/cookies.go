@@ -0,0 +1,112 @@
+package api
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+
+	"github.com/weaversgrainthorpe/HOPS/internal/auth"
+)
+
+// Browsers keep their session in an HttpOnly cookie, out of reach of scripts.
+// Because browsers send cookies with every request, state-changing requests
+// signed in with the cookie must echo the CSRF cookie in the X-CSRF-Token
+// header (double-submit), which other sites cannot read or set. The same
+// applies to requests signed in by a reverse proxy, whose identity headers
+// are just as ambient.
+const (
+	sessionCookieName = "hops_session"
+	csrfCookieName    = "hops_csrf"
+	csrfHeaderName    = "X-CSRF-Token"
+)
+
+// setSessionCookies signs the browser in with sessionID and gives it a new
+// CSRF token
+func (r *Router) setSessionCookies(w http.ResponseWriter, req *http.Request, sessionID string) error {
+	maxAge := int(r.config.SessionMaxAge.Seconds())
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    sessionID,
+		Path:     "/",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   r.secureCookies(req),
+		SameSite: http.SameSiteLaxMode,
+	})
+	return r.setCSRFCookie(w, req, maxAge)
+}
+
+// setCSRFCookie gives the browser a new CSRF token. maxAge 0 keeps it until
+// the browser is closed.
+func (r *Router) setCSRFCookie(w http.ResponseWriter, req *http.Request, maxAge int) error {
+	bytes := make([]byte, 32)
+	if _, err := rand.Read(bytes); err != nil {
+		return fmt.Errorf("failed to generate CSRF token: %w", err)
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     csrfCookieName,
+		Value:    base64.RawURLEncoding.EncodeToString(bytes),
+		Path:     "/",
+		MaxAge:   maxAge,
+		Secure:   r.secureCookies(req),
+		SameSite: http.SameSiteLaxMode,
+	})
+	return nil
+}
+
+// clearSessionCookies removes the session and CSRF cookies
+func (r *Router) clearSessionCookies(w http.ResponseWriter, req *http.Request) {
+	for _, name := range []string{sessionCookieName, csrfCookieName} {
+		http.SetCookie(w, &http.Cookie{
+			Name:     name,
+			Path:     "/",
+			MaxAge:   -1,
+			HttpOnly: name == sessionCookieName,
+			Secure:   r.secureCookies(req),
+			SameSite: http.SameSiteLaxMode,
+		})
+	}
+}
+
+// secureCookies reports whether cookies should only be sent over HTTPS: when
+// configured to, or when the request came over HTTPS directly or through a
+// trusted reverse proxy
+func (r *Router) secureCookies(req *http.Request) bool {
+	if r.config.SecureCookies || req.TLS != nil {
+		return true
+	}
+	return req.Header.Get("X-Forwarded-Proto") == "https" && auth.IsTrustedProxy(req.RemoteAddr, r.config.TrustedProxies)
+}
+
+// usesSessionCookie reports whether the request is signed in with the session
+// cookie rather than an Authorization header
+func usesSessionCookie(req *http.Request) bool {
+	if req.Header.Get("Authorization") != "" {
+		return false
+	}
+	cookie, err := req.Cookie(sessionCookieName)
+	return err == nil && cookie.Value != ""
+}
+
+// hasCSRFCookie reports whether the browser already has a CSRF token
+func hasCSRFCookie(req *http.Request) bool {
+	cookie, err := req.Cookie(csrfCookieName)
+	return err == nil && cookie.Value != ""
+}
+
+// validCSRF reports whether the request's X-CSRF-Token header matches its
+// CSRF cookie
+func validCSRF(req *http.Request) bool {
+	cookie, err := req.Cookie(csrfCookieName)
+	if err != nil || cookie.Value == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(req.Header.Get(csrfHeaderName))) == 1
+}
+
+// safeMethod reports whether a request method does not change anything
+func safeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
@@ -0,0 +1,448 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/weaversgrainthorpe/HOPS/internal/auth"
+	"github.com/weaversgrainthorpe/HOPS/internal/configdoc"
+)
+
+const dashboardsPrefix = "/api/dashboards"
+
+// resourcePath identifies a node or collection in the dashboard hierarchy, parsed
+// from /api/dashboards/{id}/tabs/{tabId}/groups/{groupId}/entries/{entryId}
+type resourcePath struct {
+	ids        []string        // IDs of the nodes along the path
+	level      configdoc.Level // level of the addressed node or collection
+	collection bool            // path ends at a collection rather than a node
+}
+
+// parseResourcePath parses a dashboard resource URL path
+func parseResourcePath(urlPath string) (*resourcePath, bool) {
+	rest := strings.Trim(strings.TrimPrefix(urlPath, dashboardsPrefix), "/")
+	var segments []string
+	if rest != "" {
+		segments = strings.Split(rest, "/")
+	}
+
+	path := &resourcePath{collection: len(segments)%2 == 0}
+	for i, segment := range segments {
+		if segment == "" {
+			return nil, false
+		}
+		if i%2 == 0 {
+			path.ids = append(path.ids, segment)
+			continue
+		}
+		// Odd segments name the child collection of the preceding node
+		level := configdoc.Level(i/2 + 1)
+		if level > configdoc.LevelEntry || segment != level.Collection() {
+			return nil, false
+		}
+	}
+
+	if path.collection {
+		path.level = configdoc.Level(len(path.ids))
+	} else {
+		path.level = configdoc.Level(len(path.ids) - 1)
+	}
+	return path, true
+}
+
+// parentIDs returns the IDs of the nodes above the addressed node or collection
+func (p *resourcePath) parentIDs() []string {
+	if p.collection {
+		return p.ids
+	}
+	return p.ids[:len(p.ids)-1]
+}
+
+// nodeID returns the ID of the addressed node
+func (p *resourcePath) nodeID() string {
+	return p.ids[len(p.ids)-1]
+}
+
+// isReorder reports whether the path is a collection followed by /reorder
+func (p *resourcePath) isReorder() bool {
+	return !p.collection && p.nodeID() == "reorder"
+}
+
+// handleDashboards routes the dashboard/tab/group/entry resource API
+func (r *Router) handleDashboards(w http.ResponseWriter, req *http.Request) {
+	path, ok := parseResourcePath(req.URL.Path)
+	if !ok {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+
+	// POST .../reorder reorders the collection it is appended to
+	if req.Method == http.MethodPost && path.isReorder() {
+		reorderPath := &resourcePath{ids: path.parentIDs(), level: path.level, collection: true}
+		r.requireRole(auth.RoleEditor, func(w http.ResponseWriter, req *http.Request) {
+			r.handleReorderNodes(w, req, reorderPath)
+		})(w, req)
+		return
+	}
+
+	if path.collection {
+		switch req.Method {
+		case http.MethodGet:
+			r.handleListNodes(w, req, path)
+		case http.MethodPost:
+			r.requireRole(auth.RoleEditor, func(w http.ResponseWriter, req *http.Request) {
+				r.handleCreateNode(w, req, path)
+			})(w, req)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+		return
+	}
+
+	switch req.Method {
+	case http.MethodGet:
+		r.handleGetNode(w, req, path)
+	case http.MethodPut, http.MethodPatch:
+		r.requireRole(auth.RoleEditor, func(w http.ResponseWriter, req *http.Request) {
+			r.handleUpdateNode(w, req, path)
+		})(w, req)
+	case http.MethodDelete:
+		r.requireRole(auth.RoleEditor, func(w http.ResponseWriter, req *http.Request) {
+			r.handleDeleteNode(w, req, path)
+		})(w, req)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleListNodes returns all items of a collection. Like the other reads it
+// treats dashboards the caller may not see as missing.
+func (r *Router) handleListNodes(w http.ResponseWriter, req *http.Request, path *resourcePath) {
+	doc, revision, err := r.configStore.Load()
+	if err != nil {
+		http.Error(w, "Failed to load config", http.StatusInternalServerError)
+		return
+	}
+	doc, _ = visibleConfig(doc, r.optionalUser(req))
+
+	parent, err := resolveNode(doc, path.ids)
+	if err != nil {
+		writeUpdateError(w, err)
+		return
+	}
+
+	items := configdoc.Items(parent, path.level.Collection())
+	if items == nil {
+		items = []interface{}{}
+	}
+	w.Header().Set("ETag", revisionETag(revision))
+	w.Header().Add("Vary", "Authorization, Cookie")
+	writeJSON(w, items)
+}
+
+// handleGetNode returns a single dashboard, tab, group or entry
+func (r *Router) handleGetNode(w http.ResponseWriter, req *http.Request, path *resourcePath) {
+	doc, revision, err := r.configStore.Load()
+	if err != nil {
+		http.Error(w, "Failed to load config", http.StatusInternalServerError)
+		return
+	}
+	doc, _ = visibleConfig(doc, r.optionalUser(req))
+
+	node, err := resolveNode(doc, path.ids)
+	if err != nil {
+		writeUpdateError(w, err)
+		return
+	}
+
+	w.Header().Set("ETag", revisionETag(revision))
+	w.Header().Add("Vary", "Authorization, Cookie")
+	writeJSON(w, node)
+}
+
+// handleCreateNode adds a new item to a collection. If the body contains an
+// "order" field the item is inserted at that position, otherwise it is appended.
+func (r *Router) handleCreateNode(w http.ResponseWriter, req *http.Request, path *resourcePath) {
+	node, err := decodeNode(req)
+	if err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	level := path.level
+	id, _ := node["id"].(string)
+	if id == "" {
+		if id, err = configdoc.GenerateID(level.Kind()); err != nil {
+			http.Error(w, "Failed to generate ID", http.StatusInternalServerError)
+			return
+		}
+		node["id"] = id
+	}
+
+	revision, err := r.configStore.Update(expectedRevision(req), configChange(req, "create "+level.Kind()+" "+id), func(doc map[string]interface{}) error {
+		parent, err := resolveNode(doc, path.ids)
+		if err != nil {
+			return err
+		}
+		items := configdoc.Items(parent, level.Collection())
+
+		if existing, _ := configdoc.FindByID(items, id); existing != nil {
+			return &apiError{http.StatusConflict, fmt.Sprintf("A %s with ID %q already exists", level.Kind(), id)}
+		}
+		// Entry IDs key status checks, so they must be unique across dashboards
+		if level == configdoc.LevelEntry && configdoc.EntryIDs(doc)[id] {
+			return &apiError{http.StatusConflict, fmt.Sprintf("An entry with ID %q already exists", id)}
+		}
+
+		if child := level.ChildCollection(); child != "" {
+			if _, ok := node[child]; !ok {
+				node[child] = []interface{}{}
+			}
+		}
+		if level == configdoc.LevelDashboard {
+			if _, ok := node["path"]; !ok {
+				node["path"] = "/" + id
+			}
+		}
+
+		if err := checkNode(level, node, items, -1); err != nil {
+			return err
+		}
+
+		parent[level.Collection()] = insertNode(items, node, orderOf(node, len(items)))
+		// The node's entries may reuse entry IDs from elsewhere in the document
+		return configdoc.Validate(doc)
+	})
+	if err != nil {
+		writeUpdateError(w, err)
+		return
+	}
+
+	w.Header().Set("ETag", revisionETag(revision))
+	writeJSON(w, node)
+}
+
+// handleUpdateNode replaces (PUT) or merges into (PATCH) a single item.
+// A PUT body that omits the child collection keeps the existing children;
+// in a PATCH body a null value removes the field.
+func (r *Router) handleUpdateNode(w http.ResponseWriter, req *http.Request, path *resourcePath) {
+	body, err := decodeNode(req)
+	if err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	level := path.level
+	id := path.nodeID()
+	if bodyID, ok := body["id"]; ok && bodyID != id {
+		http.Error(w, "ID in body does not match URL", http.StatusBadRequest)
+		return
+	}
+
+	var updated map[string]interface{}
+	revision, err := r.configStore.Update(expectedRevision(req), configChange(req, "update "+level.Kind()+" "+id), func(doc map[string]interface{}) error {
+		parent, err := resolveNode(doc, path.parentIDs())
+		if err != nil {
+			return err
+		}
+		items := configdoc.Items(parent, level.Collection())
+		existing, index := configdoc.FindByID(items, id)
+		if existing == nil {
+			return notFoundError(level)
+		}
+
+		if req.Method == http.MethodPatch {
+			updated = existing
+			for key, value := range body {
+				if value == nil {
+					delete(updated, key)
+				} else {
+					updated[key] = value
+				}
+			}
+		} else {
+			updated = body
+			if child := level.ChildCollection(); child != "" {
+				if _, ok := updated[child]; !ok {
+					updated[child] = existing[child]
+				}
+			}
+		}
+		updated["id"] = id
+
+		if err := checkNode(level, updated, items, index); err != nil {
+			return err
+		}
+
+		// Move the item if its order changed
+		items = append(items[:index], items[index+1:]...)
+		parent[level.Collection()] = insertNode(items, updated, orderOf(updated, index))
+		// The node's entries may reuse entry IDs from elsewhere in the document
+		return configdoc.Validate(doc)
+	})
+	if err != nil {
+		writeUpdateError(w, err)
+		return
+	}
+
+	w.Header().Set("ETag", revisionETag(revision))
+	writeJSON(w, updated)
+}
+
+// handleDeleteNode removes a single item and renumbers its siblings
+func (r *Router) handleDeleteNode(w http.ResponseWriter, req *http.Request, path *resourcePath) {
+	level := path.level
+	revision, err := r.configStore.Update(expectedRevision(req), configChange(req, "delete "+level.Kind()+" "+path.nodeID()), func(doc map[string]interface{}) error {
+		parent, err := resolveNode(doc, path.parentIDs())
+		if err != nil {
+			return err
+		}
+		items := configdoc.Items(parent, level.Collection())
+		_, index := configdoc.FindByID(items, path.nodeID())
+		if index < 0 {
+			return notFoundError(level)
+		}
+
+		items = append(items[:index], items[index+1:]...)
+		configdoc.Renumber(items)
+		parent[level.Collection()] = items
+		return nil
+	})
+	if err != nil {
+		writeUpdateError(w, err)
+		return
+	}
+
+	w.Header().Set("ETag", revisionETag(revision))
+	writeJSON(w, map[string]bool{"success": true})
+}
+
+// handleReorderNodes reorders a collection to match the given list of IDs,
+// which must contain every item in the collection exactly once
+func (r *Router) handleReorderNodes(w http.ResponseWriter, req *http.Request, path *resourcePath) {
+	var data struct {
+		IDs []string `json:"ids"`
+	}
+	if err := json.NewDecoder(req.Body).Decode(&data); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	level := path.level
+	var reordered []interface{}
+	revision, err := r.configStore.Update(expectedRevision(req), configChange(req, "reorder "+level.Collection()), func(doc map[string]interface{}) error {
+		parent, err := resolveNode(doc, path.ids)
+		if err != nil {
+			return err
+		}
+		items := configdoc.Items(parent, level.Collection())
+		if len(data.IDs) != len(items) {
+			return &apiError{http.StatusBadRequest, fmt.Sprintf("Expected %d IDs, got %d", len(items), len(data.IDs))}
+		}
+
+		reordered = make([]interface{}, 0, len(items))
+		seen := make(map[string]bool)
+		for _, id := range data.IDs {
+			node, _ := configdoc.FindByID(items, id)
+			if node == nil || seen[id] {
+				return &apiError{http.StatusBadRequest, fmt.Sprintf("Unknown or duplicate %s ID %q", level.Kind(), id)}
+			}
+			seen[id] = true
+			reordered = append(reordered, node)
+		}
+
+		configdoc.Renumber(reordered)
+		parent[level.Collection()] = reordered
+		return nil
+	})
+	if err != nil {
+		writeUpdateError(w, err)
+		return
+	}
+
+	w.Header().Set("ETag", revisionETag(revision))
+	writeJSON(w, reordered)
+}
+
+// resolveNode walks the config document along ids and returns the node they
+// address, or the document root if ids is empty
+func resolveNode(doc map[string]interface{}, ids []string) (map[string]interface{}, error) {
+	node := doc
+	for depth, id := range ids {
+		level := configdoc.Level(depth)
+		child, _ := configdoc.FindByID(configdoc.Items(node, level.Collection()), id)
+		if child == nil {
+			return nil, notFoundError(level)
+		}
+		node = child
+	}
+	return node, nil
+}
+
+// notFoundError returns a 404 error for the given level
+func notFoundError(level configdoc.Level) error {
+	kind := level.Kind()
+	return &apiError{http.StatusNotFound, strings.ToUpper(kind[:1]) + kind[1:] + " not found"}
+}
+
+// checkNode validates node and its descendants (error paths are relative to
+// the node) and checks that a dashboard's path is not used by a sibling.
+// Uniqueness across the document is checked once the node is in place.
+// index is the node's position among siblings, or -1 for a new node.
+func checkNode(level configdoc.Level, node map[string]interface{}, siblings []interface{}, index int) error {
+	if err := configdoc.ValidateNode(level, node, ""); err != nil {
+		return err
+	}
+
+	if level == configdoc.LevelDashboard {
+		path, _ := node["path"].(string)
+		for i, sibling := range siblings {
+			other, ok := sibling.(map[string]interface{})
+			if ok && i != index && other["path"] == path {
+				return &apiError{http.StatusConflict, fmt.Sprintf("Dashboard path %q is already in use", path)}
+			}
+		}
+	}
+
+	return nil
+}
+
+// decodeNode decodes a JSON object from the request body
+func decodeNode(req *http.Request) (map[string]interface{}, error) {
+	var node map[string]interface{}
+	if err := json.NewDecoder(req.Body).Decode(&node); err != nil {
+		return nil, err
+	}
+	if node == nil {
+		return nil, fmt.Errorf("expected a JSON object")
+	}
+	return node, nil
+}
+
+// orderOf returns the node's "order" field, or fallback if it is not a number
+func orderOf(node map[string]interface{}, fallback int) int {
+	if order, ok := node["order"].(float64); ok {
+		return int(order)
+	}
+	return fallback
+}
+
+// insertNode inserts node into items at position (clamped to the list bounds)
+// and renumbers the list
+func insertNode(items []interface{}, node map[string]interface{}, position int) []interface{} {
+	if position < 0 {
+		position = 0
+	}
+	if position > len(items) {
+		position = len(items)
+	}
+
+	result := make([]interface{}, 0, len(items)+1)
+	result = append(result, items[:position]...)
+	result = append(result, node)
+	result = append(result, items[position:]...)
+	configdoc.Renumber(result)
+	return result
+}
@@ -6,12 +6,15 @@ import (
 	"database/sql"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"image"
 	"image/png"
 	"io"
 	"log"
+	"net"
 	"net/http"
+	"net/netip"
 	"os"
 	"path/filepath"
 	"strings"
@@ -20,6 +23,8 @@ import (
 	_ "image/gif"
 	_ "image/jpeg"
 
+	"github.com/weaversgrainthorpe/HOPS/internal/auth"
+	"github.com/weaversgrainthorpe/HOPS/internal/configdoc"
 	"github.com/weaversgrainthorpe/HOPS/internal/converters"
 	"github.com/weaversgrainthorpe/HOPS/internal/version"
 	"golang.org/x/image/draw"
@@ -29,9 +34,14 @@ import (
 const bearerPrefix = "Bearer "
 
 // extractSessionID extracts the session ID from the Authorization header,
-// removing the "Bearer " prefix if present
+// removing the "Bearer " prefix if present, or else from the session cookie
 func extractSessionID(req *http.Request) string {
 	sessionID := req.Header.Get("Authorization")
+	if sessionID == "" {
+		if cookie, err := req.Cookie(sessionCookieName); err == nil {
+			return cookie.Value
+		}
+	}
 	if strings.HasPrefix(sessionID, bearerPrefix) {
 		return sessionID[len(bearerPrefix):]
 	}
@@ -54,31 +64,62 @@ func (r *Router) handleGetVersion(w http.ResponseWriter, req *http.Request) {
 	})
 }
 
-// handleGetConfig returns the dashboard configuration
+// handleGetConfig returns the dashboard configuration with its revision as the
+// ETag. Dashboards the caller's session may not see are left out.
 func (r *Router) handleGetConfig(w http.ResponseWriter, req *http.Request) {
 	if req.Method != http.MethodGet {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	var configData string
-	err := r.db.QueryRow("SELECT data FROM config WHERE id = 1").Scan(&configData)
+	configData, revision, err := r.configStore.LoadRaw()
 	if err != nil {
-		if err == sql.ErrNoRows {
-			// Return empty config
-			configData = `{"dashboards":[],"theme":{"mode":"dark"},"settings":{"searchHotkey":"/","defaultView":"/"}}`
-		} else {
+		http.Error(w, "Failed to load config", http.StatusInternalServerError)
+		return
+	}
+	if configData == "" {
+		// Return empty config
+		configData = `{"dashboards":[],"theme":{"mode":"dark"},"settings":{"searchHotkey":"/","defaultView":"/"}}`
+	}
+
+	etag := revisionETag(revision)
+	if user := r.optionalUser(req); user == nil || !auth.HasRole(user.Role, auth.RoleEditor) {
+		var doc map[string]interface{}
+		if err := json.Unmarshal([]byte(configData), &doc); err != nil {
 			http.Error(w, "Failed to load config", http.StatusInternalServerError)
 			return
 		}
+		if visible, hidden := visibleConfig(doc, user); hidden > 0 {
+			data, err := json.Marshal(visible)
+			if err != nil {
+				http.Error(w, "Failed to load config", http.StatusInternalServerError)
+				return
+			}
+			configData = string(data)
+			etag = visibleETag(revision, visible)
+		}
+	}
+
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Add("Vary", "Authorization, Cookie")
+	if req.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	w.Write([]byte(configData))
 }
 
-// handleUpdateConfig updates the dashboard configuration (admin only)
+// handleUpdateConfig updates the dashboard configuration (admin only).
+// If an If-Match header is sent the update is rejected with 412 when the
+// config has been modified since that revision was loaded.
 func (r *Router) handleUpdateConfig(w http.ResponseWriter, req *http.Request) {
+	if req.Method == http.MethodPatch {
+		r.handlePatchConfig(w, req)
+		return
+	}
 	if req.Method != http.MethodPut && req.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
@@ -86,7 +127,14 @@ func (r *Router) handleUpdateConfig(w http.ResponseWriter, req *http.Request) {
 
 	var configData map[string]interface{}
 	if err := json.NewDecoder(req.Body).Decode(&configData); err != nil {
-		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		if !requestTooLarge(w, err) {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		}
+		return
+	}
+
+	if err := configdoc.Validate(configData); err != nil {
+		writeUpdateError(w, err)
 		return
 	}
 
@@ -98,23 +146,120 @@ func (r *Router) handleUpdateConfig(w http.ResponseWriter, req *http.Request) {
 		}
 	}
 
-	configJSON, err := json.Marshal(configData)
+	revision, err := r.configStore.Replace(expectedRevision(req), configChange(req, "config update"), configData)
 	if err != nil {
-		http.Error(w, "Failed to encode config", http.StatusInternalServerError)
+		writeUpdateError(w, err)
 		return
 	}
 
-	_, err = r.db.Exec(
-		"INSERT OR REPLACE INTO config (id, data, updated_at) VALUES (1, ?, CURRENT_TIMESTAMP)",
-		string(configJSON),
-	)
+	setAudit(req, "", "", fmt.Sprintf("revision %d", revision))
+	w.Header().Set("ETag", revisionETag(revision))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "revision": revision})
+}
+
+// handlePatchConfig applies a JSON Patch (application/json-patch+json) or JSON
+// Merge Patch (application/merge-patch+json) to the stored config (admin only).
+// The patch is applied inside the config transaction; a failed "test"
+// operation yields 409 and a patch that cannot be applied or produces an
+// invalid config yields 422.
+func (r *Router) handlePatchConfig(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPatch {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var apply func(doc interface{}) (interface{}, error)
+	contentType, _, _ := strings.Cut(req.Header.Get("Content-Type"), ";")
+	switch strings.TrimSpace(contentType) {
+	case "application/json-patch+json":
+		var patch []configdoc.PatchOperation
+		if err := json.NewDecoder(req.Body).Decode(&patch); err != nil {
+			http.Error(w, "Invalid JSON Patch", http.StatusBadRequest)
+			return
+		}
+		apply = func(doc interface{}) (interface{}, error) {
+			return configdoc.ApplyJSONPatch(doc, patch)
+		}
+	case "application/merge-patch+json":
+		var patch interface{}
+		if err := json.NewDecoder(req.Body).Decode(&patch); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+		apply = func(doc interface{}) (interface{}, error) {
+			return configdoc.ApplyMergePatch(doc, patch), nil
+		}
+	default:
+		http.Error(w, "Content-Type must be application/json-patch+json or application/merge-patch+json", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	revision, err := r.configStore.Update(expectedRevision(req), configChange(req, "config patch"), func(doc map[string]interface{}) error {
+		result, err := apply(doc)
+		var testFailed *configdoc.TestFailedError
+		if errors.As(err, &testFailed) {
+			return &apiError{http.StatusConflict, err.Error()}
+		}
+		if err != nil {
+			return &apiError{http.StatusUnprocessableEntity, "Failed to apply patch: " + err.Error()}
+		}
+
+		patched, ok := result.(map[string]interface{})
+		if !ok {
+			return &apiError{http.StatusUnprocessableEntity, "Patched config must be a JSON object"}
+		}
+		if err := configdoc.Validate(patched); err != nil {
+			return err
+		}
+
+		// Patches usually modify doc in place, so copy before clearing it
+		fields := make(map[string]interface{}, len(patched))
+		for key, value := range patched {
+			fields[key] = value
+		}
+		for key := range doc {
+			delete(doc, key)
+		}
+		for key, value := range fields {
+			doc[key] = value
+		}
+		return nil
+	})
 	if err != nil {
-		http.Error(w, "Failed to save config", http.StatusInternalServerError)
+		writeUpdateError(w, err)
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]bool{"success": true})
+	setAudit(req, "", "", fmt.Sprintf("revision %d, patch", revision))
+	w.Header().Set("ETag", revisionETag(revision))
+	writeJSON(w, map[string]interface{}{"success": true, "revision": revision})
+}
+
+// handleValidateConfig checks a config document without saving it (admin only).
+// Always responds 200 with whether the document is valid and any errors found.
+func (r *Router) handleValidateConfig(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var configData map[string]interface{}
+	if err := json.NewDecoder(req.Body).Decode(&configData); err != nil || configData == nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	errs := configdoc.ValidationErrors{}
+	if err := configdoc.Validate(configData); err != nil && !errors.As(err, &errs) {
+		log.Printf("Failed to validate config: %v", err)
+		http.Error(w, "Failed to validate config", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, map[string]interface{}{
+		"valid":  len(errs) == 0,
+		"errors": errs,
+	})
 }
 
 // handleLogin authenticates a user with rate limiting
@@ -125,15 +270,17 @@ func (r *Router) handleLogin(w http.ResponseWriter, req *http.Request) {
 	}
 
 	// Get client IP for rate limiting
-	clientIP := getClientIP(req)
-	if !r.rateLimiter.Allow(clientIP) {
-		http.Error(w, "Too many login attempts. Please try again later.", http.StatusTooManyRequests)
+	clientIP := r.clientIP(req)
+	if ok, retryAfter := r.rateLimiter.Allow(clientIP); !ok {
+		writeTooManyRequests(w, retryAfter, "Too many login attempts. Please try again later.")
 		return
 	}
 
 	var credentials struct {
 		Username string `json:"username"`
 		Password string `json:"password"`
+		Code     string `json:"code"`      // two-factor code or recovery code
+		Cookie   bool   `json:"useCookie"` // keep the session in an HttpOnly cookie
 	}
 
 	if err := json.NewDecoder(req.Body).Decode(&credentials); err != nil {
@@ -141,39 +288,96 @@ func (r *Router) handleLogin(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	sessionID, err := r.authService.Login(credentials.Username, credentials.Password)
+	sessionID, err := r.authService.Login(credentials.Username, credentials.Password, credentials.Code, r.clientFromRequest(req))
+	var locked *auth.LockoutError
+	switch {
+	case errors.As(err, &locked):
+		r.recordAudit(req, nil, "auth.login", credentials.Username, "locked out", http.StatusTooManyRequests)
+		writeTooManyRequests(w, locked.RetryAfter(), "Too many failed logins for this account. Please try again in "+locked.RetryAfter().String()+".")
+		return
+	case errors.Is(err, auth.ErrTOTPRequired):
+		// Not an attempt yet: the client asks for the code and tries again
+	case errors.Is(err, auth.ErrInvalidTOTP):
+		r.recordAudit(req, nil, "auth.login", credentials.Username, "invalid two-factor code", http.StatusUnauthorized)
+	case err != nil:
+		r.recordAudit(req, nil, "auth.login", credentials.Username, "invalid credentials", http.StatusUnauthorized)
+	default:
+		user, _ := r.authService.SessionUser(sessionID)
+		r.recordAudit(req, user, "auth.login", credentials.Username, "password", http.StatusOK)
+	}
+	if errors.Is(err, auth.ErrTOTPRequired) || errors.Is(err, auth.ErrInvalidTOTP) {
+		// Tells the client to ask for a code and send the login again
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":        err.Error(),
+			"totpRequired": true,
+		})
+		return
+	}
 	if err != nil {
 		http.Error(w, "Invalid credentials", http.StatusUnauthorized)
 		return
 	}
 
+	if credentials.Cookie {
+		if err := r.setSessionCookies(w, req, sessionID); err != nil {
+			log.Printf("Failed to set session cookies: %v", err)
+			http.Error(w, "Failed to log in", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, map[string]bool{"success": true})
+		return
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{
 		"sessionId": sessionID,
 	})
 }
 
-// getClientIP extracts the client IP address from the request
-func getClientIP(req *http.Request) string {
+// clientIP returns the IP address of the client making a request. Forwarded
+// headers are only believed from --trusted-proxies, and X-Forwarded-For is
+// read from the right, skipping trusted proxies, so that clients cannot
+// choose their own address by sending the header themselves.
+func (r *Router) clientIP(req *http.Request) string {
+	remoteIP, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		remoteIP = req.RemoteAddr
+	}
+	if !auth.IsTrustedProxy(req.RemoteAddr, r.config.TrustedProxies) {
+		return remoteIP
+	}
+
 	// Check X-Forwarded-For header first (for reverse proxies)
-	if xff := req.Header.Get("X-Forwarded-For"); xff != "" {
-		// Take the first IP in the list
-		if idx := strings.Index(xff, ","); idx != -1 {
-			return strings.TrimSpace(xff[:idx])
+	if xff := strings.Join(req.Header.Values("X-Forwarded-For"), ","); xff != "" {
+		hops := strings.Split(xff, ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop := strings.TrimSpace(hops[i])
+			if _, err := netip.ParseAddr(hop); err != nil {
+				break
+			}
+			remoteIP = hop
+			if !auth.IsTrustedProxy(hop, r.config.TrustedProxies) {
+				break
+			}
 		}
-		return strings.TrimSpace(xff)
+		return remoteIP
 	}
 
 	// Check X-Real-IP header
-	if xri := req.Header.Get("X-Real-IP"); xri != "" {
-		return xri
+	if xri := strings.TrimSpace(req.Header.Get("X-Real-IP")); xri != "" {
+		if _, err := netip.ParseAddr(xri); err == nil {
+			return xri
+		}
 	}
+	return remoteIP
+}
 
-	// Fall back to RemoteAddr
-	if idx := strings.LastIndex(req.RemoteAddr, ":"); idx != -1 {
-		return req.RemoteAddr[:idx]
-	}
-	return req.RemoteAddr
+// clientFromRequest describes the client making a request, for recording
+// with a new session
+func (r *Router) clientFromRequest(req *http.Request) auth.Client {
+	return auth.Client{IP: r.clientIP(req), UserAgent: req.UserAgent()}
 }
 
 // handleLogout logs out a user
@@ -185,6 +389,7 @@ func (r *Router) handleLogout(w http.ResponseWriter, req *http.Request) {
 
 	sessionID := extractSessionID(req)
 	r.authService.Logout(sessionID)
+	r.clearSessionCookies(w, req)
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]bool{"success": true})
@@ -207,24 +412,36 @@ func (r *Router) handleChangePassword(w http.ResponseWriter, req *http.Request)
 		return
 	}
 
-	// Get userID from session (simplified - would normally come from context)
-	sessionID := extractSessionID(req)
-	userID, err := r.authService.ValidateSession(sessionID)
-	if err != nil {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
-	}
-
+	userID := userIDFromRequest(req)
 	if err := r.authService.ChangePassword(userID, data.OldPassword, data.NewPassword); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
+	// Changing the password signed the user out everywhere, so sign this
+	// browser back in with a new session
+	response := map[string]interface{}{"success": true}
+	if extractSessionID(req) != "" {
+		sessionID, err := r.authService.CreateSession(userID, r.clientFromRequest(req))
+		switch {
+		case err != nil:
+			log.Printf("Failed to create session after password change: %v", err)
+		case usesSessionCookie(req):
+			if err := r.setSessionCookies(w, req, sessionID); err != nil {
+				log.Printf("Failed to set session cookies: %v", err)
+			}
+		default:
+			response["sessionId"] = sessionID
+		}
+	}
+
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]bool{"success": true})
+	json.NewEncoder(w).Encode(response)
 }
 
-// handleGetStatus returns status check results
+// handleGetStatus returns an entry's latest status check result, for
+// entries the caller may see or that the share query parameter's share link
+// covers (see scopedEntries)
 func (r *Router) handleGetStatus(w http.ResponseWriter, req *http.Request) {
 	if req.Method != http.MethodGet {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -237,6 +454,13 @@ func (r *Router) handleGetStatus(w http.ResponseWriter, req *http.Request) {
 		http.Error(w, "Entry ID required", http.StatusBadRequest)
 		return
 	}
+	if id, ok := strings.CutSuffix(entryID, "/history"); ok {
+		r.handleStatusHistory(w, req, id)
+		return
+	}
+	if !r.entryInScope(w, req, entryID) {
+		return
+	}
 
 	var status string
 	var responseTime sql.NullInt64
@@ -358,6 +582,7 @@ func (r *Router) handleExportConfig(w http.ResponseWriter, req *http.Request) {
 		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s.json", filename))
 	}
 
+	setAudit(req, "config.export", dashboardId, filename)
 	w.Write([]byte(configData))
 }
 
@@ -705,8 +930,10 @@ func (r *Router) handleImportConfig(w http.ResponseWriter, req *http.Request) {
 	}
 
 	// Parse multipart form (for file uploads)
-	if err := req.ParseMultipartForm(10 << 20); err != nil { // 10 MB max
-		http.Error(w, "Failed to parse form", http.StatusBadRequest)
+	if err := req.ParseMultipartForm(maxImportSize); err != nil {
+		if !requestTooLarge(w, err) {
+			http.Error(w, "Failed to parse form", http.StatusBadRequest)
+		}
 		return
 	}
 
@@ -785,17 +1012,12 @@ func (r *Router) handleImportConfig(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	// Load existing config to merge with
-	var existingJSON string
-	err = r.db.QueryRow("SELECT data FROM config WHERE id = 1").Scan(&existingJSON)
-
-	var existingConfig map[string]interface{}
-	if err == nil && existingJSON != "" {
-		if err := json.Unmarshal([]byte(existingJSON), &existingConfig); err != nil {
-			existingConfig = make(map[string]interface{})
-		}
-	} else {
-		existingConfig = make(map[string]interface{})
+	// Load existing config to merge with. The merged result is saved against
+	// this revision so a concurrent edit is not silently overwritten.
+	existingConfig, baseRevision, err := r.configStore.Load()
+	if err != nil {
+		http.Error(w, "Failed to load config", http.StatusInternalServerError)
+		return
 	}
 
 	// Get existing dashboards
@@ -811,7 +1033,19 @@ func (r *Router) handleImportConfig(w http.ResponseWriter, req *http.Request) {
 		}
 	}
 
-	// Append imported dashboards, renaming paths if they conflict
+	// Track IDs in use; converters number their entries from zero, so
+	// repeated imports would otherwise collide
+	existingIDs := make(map[string]bool)
+	for _, d := range existingDashboards {
+		if dashboard, ok := d.(map[string]interface{}); ok {
+			if id, ok := dashboard["id"].(string); ok {
+				existingIDs[id] = true
+			}
+		}
+	}
+	entryIDs := configdoc.EntryIDs(existingConfig)
+
+	// Append imported dashboards, renaming paths and IDs if they conflict
 	importedCount := 0
 	for _, d := range importedDashboards {
 		if dashboard, ok := d.(map[string]interface{}); ok {
@@ -834,8 +1068,21 @@ func (r *Router) handleImportConfig(w http.ResponseWriter, req *http.Request) {
 				}
 			}
 
+			id, _ := dashboard["id"].(string)
+			originalID := id
+			for suffix := 1; existingIDs[id]; suffix++ {
+				id = fmt.Sprintf("%s-%d", originalID, suffix)
+			}
+			dashboard["id"] = id
+
+			if err := configdoc.UniqueEntryIDs(dashboard, configdoc.LevelTab, entryIDs); err != nil {
+				http.Error(w, "Failed to generate ID", http.StatusInternalServerError)
+				return
+			}
+
 			existingDashboards = append(existingDashboards, dashboard)
 			existingPaths[path] = true
+			existingIDs[id] = true
 			importedCount++
 		}
 	}
@@ -884,23 +1131,19 @@ func (r *Router) handleImportConfig(w http.ResponseWriter, req *http.Request) {
 		}
 	}
 
-	// Serialize merged config
-	mergedJSON, err := json.Marshal(existingConfig)
-	if err != nil {
-		http.Error(w, "Failed to serialize merged config", http.StatusInternalServerError)
+	if err := configdoc.Validate(existingConfig); err != nil {
+		writeUpdateError(w, err)
 		return
 	}
 
-	// Save to database
-	_, err = r.db.Exec(
-		"INSERT OR REPLACE INTO config (id, data, updated_at) VALUES (1, ?, CURRENT_TIMESTAMP)",
-		string(mergedJSON),
-	)
+	// Save merged config
+	revision, err := r.configStore.Replace(baseRevision, configChange(req, "import ("+importFormat+")"), existingConfig)
 	if err != nil {
-		http.Error(w, "Failed to save config", http.StatusInternalServerError)
+		writeUpdateError(w, err)
 		return
 	}
 
+	w.Header().Set("ETag", revisionETag(revision))
 	w.Header().Set("Content-Type", "application/json")
 
 	// Build response message
@@ -908,12 +1151,14 @@ func (r *Router) handleImportConfig(w http.ResponseWriter, req *http.Request) {
 	if iconMatchCount > 0 {
 		message += fmt.Sprintf(", matched %d icon(s)", iconMatchCount)
 	}
+	setAudit(req, "", "", fmt.Sprintf("%s, revision %d", message, revision))
 
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"success":      true,
 		"message":      message,
 		"imported":     importedCount,
 		"iconsMatched": iconMatchCount,
+		"revision":     revision,
 	})
 }
 
@@ -926,9 +1171,9 @@ func (r *Router) handleImportConfig(w http.ResponseWriter, req *http.Request) {
 func (r *Router) handleIconActions(w http.ResponseWriter, req *http.Request) {
 	switch req.Method {
 	case http.MethodPut:
-		r.authMiddleware(r.handleUpdateIcon)(w, req)
+		r.requireRole(auth.RoleEditor, r.handleUpdateIcon)(w, req)
 	case http.MethodDelete:
-		r.authMiddleware(r.handleDeleteIcon)(w, req)
+		r.requireRole(auth.RoleEditor, r.handleDeleteIcon)(w, req)
 	default:
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 	}
@@ -938,9 +1183,9 @@ func (r *Router) handleIconActions(w http.ResponseWriter, req *http.Request) {
 func (r *Router) handleIconCategoryActions(w http.ResponseWriter, req *http.Request) {
 	switch req.Method {
 	case http.MethodPut:
-		r.authMiddleware(r.handleUpdateIconCategory)(w, req)
+		r.requireRole(auth.RoleEditor, r.handleUpdateIconCategory)(w, req)
 	case http.MethodDelete:
-		r.authMiddleware(r.handleDeleteIconCategory)(w, req)
+		r.requireRole(auth.RoleEditor, r.handleDeleteIconCategory)(w, req)
 	default:
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 	}
@@ -949,7 +1194,7 @@ func (r *Router) handleIconCategoryActions(w http.ResponseWriter, req *http.Requ
 // handleGetIconCategories returns all icon categories or creates a new one
 func (r *Router) handleGetIconCategories(w http.ResponseWriter, req *http.Request) {
 	if req.Method == http.MethodPost {
-		r.authMiddleware(r.handleCreateIconCategory)(w, req)
+		r.requireRole(auth.RoleEditor, r.handleCreateIconCategory)(w, req)
 		return
 	}
 
@@ -996,7 +1241,7 @@ func (r *Router) handleGetIconCategories(w http.ResponseWriter, req *http.Reques
 // handleGetIcons returns all icons, optionally filtered by category, or creates a new icon
 func (r *Router) handleGetIcons(w http.ResponseWriter, req *http.Request) {
 	if req.Method == http.MethodPost {
-		r.authMiddleware(r.handleCreateIcon)(w, req)
+		r.requireRole(auth.RoleEditor, r.handleCreateIcon)(w, req)
 		return
 	}
 
@@ -1176,11 +1421,14 @@ func (r *Router) handleDeleteIcon(w http.ResponseWriter, req *http.Request) {
 	}
 
 	// Only allow deletion of user-created icons (not presets)
-	_, err := r.db.Exec("DELETE FROM icons WHERE id = ? AND is_preset = 0", iconID)
+	result, err := r.db.Exec("DELETE FROM icons WHERE id = ? AND is_preset = 0", iconID)
 	if err != nil {
 		http.Error(w, "Failed to delete icon", http.StatusInternalServerError)
 		return
 	}
+	if deleted, _ := result.RowsAffected(); deleted == 0 {
+		setAudit(req, "", "", "nothing deleted: no such user-created icon")
+	}
 
 	writeJSON(w, map[string]bool{"success": true})
 }
@@ -1377,9 +1625,11 @@ func (r *Router) handleUploadBackground(w http.ResponseWriter, req *http.Request
 		return
 	}
 
-	// Parse multipart form (max 50 MB)
-	if err := req.ParseMultipartForm(50 << 20); err != nil {
-		http.Error(w, "Failed to parse form", http.StatusBadRequest)
+	// Parse multipart form (max 50 MB, see bodyLimit)
+	if err := req.ParseMultipartForm(maxBackgroundSize); err != nil {
+		if !requestTooLarge(w, err) {
+			http.Error(w, "Failed to parse form", http.StatusBadRequest)
+		}
 		return
 	}
 
@@ -1486,9 +1736,11 @@ func (r *Router) handleUploadIcon(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	// Parse multipart form (max 5 MB for icons)
-	if err := req.ParseMultipartForm(5 << 20); err != nil {
-		http.Error(w, "Failed to parse form", http.StatusBadRequest)
+	// Parse multipart form (max 5 MB for icons, see bodyLimit)
+	if err := req.ParseMultipartForm(maxIconSize); err != nil {
+		if !requestTooLarge(w, err) {
+			http.Error(w, "Failed to parse form", http.StatusBadRequest)
+		}
 		return
 	}
 
@@ -1961,6 +2213,7 @@ func (r *Router) handleBackups(w http.ResponseWriter, req *http.Request) {
 			http.Error(w, fmt.Sprintf("Failed to create backup: %v", err), http.StatusInternalServerError)
 			return
 		}
+		setAudit(req, "", filepath.Base(backupPath), reqData.Reason)
 
 		writeJSON(w, map[string]interface{}{
 			"success": true,
@@ -0,0 +1,182 @@
+package api
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/weaversgrainthorpe/HOPS/internal/configdoc"
+)
+
+const historyPrefix = "/api/config/history/"
+
+// handleConfigHistory lists recorded config revisions, newest first.
+// Supports optional limit (default 50, max 500) and offset query parameters.
+func (r *Router) handleConfigHistory(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	limit := queryInt(req, "limit", 50)
+	if limit <= 0 || limit > 500 {
+		limit = 50
+	}
+	offset := queryInt(req, "offset", 0)
+	if offset < 0 {
+		offset = 0
+	}
+
+	entries, total, err := r.configStore.ListHistory(limit, offset)
+	if err != nil {
+		http.Error(w, "Failed to load config history", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, map[string]interface{}{
+		"revisions": entries,
+		"total":     total,
+	})
+}
+
+// handleConfigHistoryActions routes requests for individual revisions:
+//
+//	GET  /api/config/history/diff?from={rev}&to={rev}  structural diff (to defaults to current)
+//	GET  /api/config/history/{rev}                     the revision's config document
+//	POST /api/config/history/{rev}/rollback            make the revision current again
+func (r *Router) handleConfigHistoryActions(w http.ResponseWriter, req *http.Request) {
+	rest := strings.Trim(req.URL.Path[len(historyPrefix):], "/")
+	if rest == "diff" {
+		r.handleConfigDiff(w, req)
+		return
+	}
+
+	revisionPart, action, _ := strings.Cut(rest, "/")
+	revision, err := strconv.ParseInt(revisionPart, 10, 64)
+	if err != nil || revision <= 0 {
+		http.Error(w, "Invalid revision", http.StatusBadRequest)
+		return
+	}
+
+	switch {
+	case action == "" && req.Method == http.MethodGet:
+		r.handleGetConfigRevision(w, req, revision)
+	case action == "rollback" && req.Method == http.MethodPost:
+		r.handleRollbackConfig(w, req, revision)
+	case action == "" || action == "rollback":
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	default:
+		http.Error(w, "Not found", http.StatusNotFound)
+	}
+}
+
+// handleGetConfigRevision returns a recorded revision with its config document
+func (r *Router) handleGetConfigRevision(w http.ResponseWriter, req *http.Request, revision int64) {
+	doc, entry, err := r.configStore.LoadRevision(revision)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Revision not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to load revision", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, map[string]interface{}{
+		"revision":  entry.Revision,
+		"userId":    entry.UserID,
+		"username":  entry.Username,
+		"reason":    entry.Reason,
+		"createdAt": entry.CreatedAt,
+		"config":    doc,
+	})
+}
+
+// handleConfigDiff returns the added, removed and changed nodes between two revisions
+func (r *Router) handleConfigDiff(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	from, err := strconv.ParseInt(req.URL.Query().Get("from"), 10, 64)
+	if err != nil {
+		http.Error(w, "A valid 'from' revision is required", http.StatusBadRequest)
+		return
+	}
+
+	fromDoc, _, err := r.configStore.LoadRevision(from)
+	if err == sql.ErrNoRows {
+		http.Error(w, fmt.Sprintf("Revision %d not found", from), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to load revision", http.StatusInternalServerError)
+		return
+	}
+
+	var to int64
+	var toDoc map[string]interface{}
+	if toParam := req.URL.Query().Get("to"); toParam != "" {
+		if to, err = strconv.ParseInt(toParam, 10, 64); err != nil {
+			http.Error(w, "Invalid 'to' revision", http.StatusBadRequest)
+			return
+		}
+		toDoc, _, err = r.configStore.LoadRevision(to)
+	} else {
+		toDoc, to, err = r.configStore.Load()
+	}
+	if err == sql.ErrNoRows {
+		http.Error(w, fmt.Sprintf("Revision %d not found", to), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to load revision", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, map[string]interface{}{
+		"from": from,
+		"to":   to,
+		"diff": configdoc.Compare(fromDoc, toDoc),
+	})
+}
+
+// handleRollbackConfig saves a recorded revision's document as a new revision.
+// Honours If-Match like other config writes.
+func (r *Router) handleRollbackConfig(w http.ResponseWriter, req *http.Request, revision int64) {
+	doc, _, err := r.configStore.LoadRevision(revision)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Revision not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to load revision", http.StatusInternalServerError)
+		return
+	}
+
+	reason := fmt.Sprintf("rollback to revision %d", revision)
+	newRevision, err := r.configStore.Replace(expectedRevision(req), configChange(req, reason), doc)
+	if err != nil {
+		writeUpdateError(w, err)
+		return
+	}
+
+	setAudit(req, "", "", fmt.Sprintf("%s, revision %d", reason, newRevision))
+	w.Header().Set("ETag", revisionETag(newRevision))
+	writeJSON(w, map[string]interface{}{
+		"success":  true,
+		"revision": newRevision,
+	})
+}
+
+// queryInt returns an integer query parameter, or fallback if absent or invalid
+func queryInt(req *http.Request, name string, fallback int) int {
+	value, err := strconv.Atoi(req.URL.Query().Get(name))
+	if err != nil {
+		return fallback
+	}
+	return value
+}
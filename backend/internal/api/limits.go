@@ -0,0 +1,212 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// maxRateLimiterClients bounds how many clients a rate limiter tracks.
+	// Idle clients are forgotten well before this is reached.
+	maxRateLimiterClients = 10000
+
+	// rateLimiterSweepInterval is how often idle clients are forgotten
+	rateLimiterSweepInterval = time.Minute
+)
+
+// Request body limits. Uploads keep the limits their handlers always had.
+const (
+	maxBodySize       = 1 << 20  // JSON requests
+	maxConfigBodySize = 5 << 20  // whole config documents and dashboard changes
+	maxImportSize     = 10 << 20 // config imports
+	maxIconSize       = 5 << 20  // icon uploads
+	maxBackgroundSize = 50 << 20 // background image uploads
+)
+
+// RateLimiter is a token bucket rate limiter keyed by client IP. Each client
+// can make burst requests at once, after which tokens are refilled at a
+// steady rate. Clients idle long enough to have a full bucket again are
+// forgotten, so memory stays bounded by the number of recently active clients.
+type RateLimiter struct {
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	rate      float64 // tokens per second
+	burst     float64
+	lastSweep time.Time
+}
+
+// tokenBucket is one client's tokens as of last
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimiter creates a rate limiter allowing perMinute requests a minute
+// per client, in bursts of up to burst requests
+func NewRateLimiter(perMinute, burst int) *RateLimiter {
+	return &RateLimiter{
+		buckets:   make(map[string]*tokenBucket),
+		rate:      float64(perMinute) / 60,
+		burst:     float64(max(burst, 1)),
+		lastSweep: time.Now(),
+	}
+}
+
+// Allow takes a token for the given client IP. If there is none it returns
+// false and how long until there will be.
+func (rl *RateLimiter) Allow(ip string) (bool, time.Duration) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(rl.lastSweep) >= rateLimiterSweepInterval {
+		rl.sweep(now)
+	}
+
+	bucket, ok := rl.buckets[ip]
+	if !ok {
+		if len(rl.buckets) >= maxRateLimiterClients {
+			rl.sweep(now)
+			// Still full: make room by forgetting any client
+			for key := range rl.buckets {
+				if len(rl.buckets) < maxRateLimiterClients {
+					break
+				}
+				delete(rl.buckets, key)
+			}
+		}
+		bucket = &tokenBucket{tokens: rl.burst, last: now}
+		rl.buckets[ip] = bucket
+	}
+
+	bucket.tokens = math.Min(rl.burst, bucket.tokens+now.Sub(bucket.last).Seconds()*rl.rate)
+	bucket.last = now
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - bucket.tokens) / rl.rate * float64(time.Second))
+}
+
+// sweep forgets clients whose buckets have refilled
+func (rl *RateLimiter) sweep(now time.Time) {
+	rl.lastSweep = now
+	refill := time.Duration(rl.burst / rl.rate * float64(time.Second))
+	for ip, bucket := range rl.buckets {
+		if now.Sub(bucket.last) >= refill {
+			delete(rl.buckets, ip)
+		}
+	}
+}
+
+// newClassLimiter creates the rate limiter for a route class allowing
+// perMinute requests a minute in bursts of fifteen seconds' worth, such as a
+// dashboard loading the status of every entry, or nil if perMinute is 0
+func newClassLimiter(perMinute int) *RateLimiter {
+	if perMinute <= 0 {
+		return nil
+	}
+	return NewRateLimiter(perMinute, perMinute/4)
+}
+
+// rateLimitMiddleware limits API requests per client IP with the limiter for
+// the request's route class (see routeLimiter)
+func (r *Router) rateLimitMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if limiter := r.routeLimiter(req); limiter != nil {
+			if ok, retryAfter := limiter.Allow(r.clientIP(req)); !ok {
+				writeTooManyRequests(w, retryAfter, "Too many requests. Please try again later.")
+				return
+			}
+		}
+		next.ServeHTTP(w, req)
+	})
+}
+
+// routeLimiter returns the rate limiter for a request's route class: uploads,
+// other writes, or reads. Static files, including the dashboard icon
+// collection, are not limited.
+func (r *Router) routeLimiter(req *http.Request) *RateLimiter {
+	path := req.URL.Path
+	if !strings.HasPrefix(path, "/api/") || strings.HasPrefix(path, "/api/icons/dashboard/") {
+		return nil
+	}
+	switch {
+	case safeMethod(req.Method):
+		return r.readLimiter
+	case isUpload(req):
+		return r.uploadLimiter
+	default:
+		return r.writeLimiter
+	}
+}
+
+// isUpload reports whether a request uploads a file
+func isUpload(req *http.Request) bool {
+	return req.Method == http.MethodPost &&
+		(req.URL.Path == "/api/icons/upload" || req.URL.Path == "/api/backgrounds" || req.URL.Path == "/api/config/import")
+}
+
+// bodyLimitMiddleware caps request bodies at the limit for their endpoint
+// (see bodyLimit), rejecting larger ones up front when they declare their size
+func (r *Router) bodyLimitMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Body != nil && req.Body != http.NoBody {
+			limit := bodyLimit(req)
+			if req.ContentLength > limit {
+				writeBodyTooLarge(w, limit)
+				return
+			}
+			req.Body = http.MaxBytesReader(w, req.Body, limit)
+		}
+		next.ServeHTTP(w, req)
+	})
+}
+
+// bodyLimit returns the largest request body accepted for a request
+func bodyLimit(req *http.Request) int64 {
+	path := req.URL.Path
+	switch {
+	case isUpload(req) && path == "/api/icons/upload":
+		return maxIconSize
+	case isUpload(req) && path == "/api/backgrounds":
+		return maxBackgroundSize
+	case isUpload(req):
+		return maxImportSize
+	case path == "/api/config", path == "/api/config/update", path == "/api/config/validate",
+		strings.HasPrefix(path, "/api/dashboards"):
+		return maxConfigBodySize
+	default:
+		return maxBodySize
+	}
+}
+
+// requestTooLarge writes 413 Request Entity Too Large and returns true if err
+// came from reading more of the request body than bodyLimitMiddleware allows
+func requestTooLarge(w http.ResponseWriter, err error) bool {
+	var maxBytesErr *http.MaxBytesError
+	if !errors.As(err, &maxBytesErr) {
+		return false
+	}
+	writeBodyTooLarge(w, maxBytesErr.Limit)
+	return true
+}
+
+// writeBodyTooLarge responds 413 Request Entity Too Large
+func writeBodyTooLarge(w http.ResponseWriter, limit int64) {
+	http.Error(w, fmt.Sprintf("Request body too large (limit %d MB)", max(limit>>20, 1)), http.StatusRequestEntityTooLarge)
+}
+
+// writeTooManyRequests responds 429 Too Many Requests, telling the client to
+// retry after retryAfter, rounded up to a whole second
+func writeTooManyRequests(w http.ResponseWriter, retryAfter time.Duration, message string) {
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	w.Header().Set("Retry-After", strconv.Itoa(max(seconds, 1)))
+	http.Error(w, message, http.StatusTooManyRequests)
+}
@@ -0,0 +1,202 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/weaversgrainthorpe/HOPS/internal/database"
+	"github.com/weaversgrainthorpe/HOPS/internal/models"
+	"github.com/weaversgrainthorpe/HOPS/internal/notify"
+)
+
+const (
+	notificationsPrefix = "/api/notifications/"
+
+	// maskedSetting replaces secret settings in responses. Sending it back
+	// keeps the saved value.
+	maskedSetting = "********"
+)
+
+// notificationChannelRequest is the body of channel create and update
+// requests. NotifyRecovery and Enabled default to true.
+type notificationChannelRequest struct {
+	Name           string            `json:"name"`
+	Type           string            `json:"type"`
+	Settings       map[string]string `json:"settings"`
+	EntryIDs       []string          `json:"entryIds"`
+	GroupIDs       []string          `json:"groupIds"`
+	NotifyRecovery *bool             `json:"notifyRecovery"`
+	Enabled        *bool             `json:"enabled"`
+}
+
+// channel returns the channel described by the request, keeping the secret
+// settings of existing (nil for new channels) that were sent back masked
+func (data notificationChannelRequest) channel(existing *models.NotificationChannel) (models.NotificationChannel, error) {
+	channel := models.NotificationChannel{
+		Name:           strings.TrimSpace(data.Name),
+		Type:           data.Type,
+		Settings:       make(map[string]string, len(data.Settings)),
+		EntryIDs:       cleanIDs(data.EntryIDs),
+		GroupIDs:       cleanIDs(data.GroupIDs),
+		NotifyRecovery: data.NotifyRecovery == nil || *data.NotifyRecovery,
+		Enabled:        data.Enabled == nil || *data.Enabled,
+	}
+	if channel.Name == "" {
+		return channel, errors.New("name is required")
+	}
+	for key, value := range data.Settings {
+		if value == maskedSetting {
+			if existing == nil || existing.Type != channel.Type {
+				continue
+			}
+			value = existing.Settings[key]
+		}
+		if value != "" {
+			channel.Settings[key] = value
+		}
+	}
+	return channel, notify.Validate(channel)
+}
+
+// cleanIDs trims IDs and drops empty ones and commas, which separate IDs in
+// the database
+func cleanIDs(ids []string) []string {
+	cleaned := []string{}
+	for _, id := range ids {
+		if id = strings.TrimSpace(id); id != "" && !strings.Contains(id, ",") {
+			cleaned = append(cleaned, id)
+		}
+	}
+	return cleaned
+}
+
+// maskChannel hides a channel's secret settings
+func maskChannel(channel models.NotificationChannel) models.NotificationChannel {
+	settings := make(map[string]string, len(channel.Settings))
+	for key, value := range channel.Settings {
+		if value != "" && notify.IsSecretSetting(channel.Type, key) {
+			value = maskedSetting
+		}
+		settings[key] = value
+	}
+	channel.Settings = settings
+	return channel
+}
+
+// handleNotificationChannels lists (GET) or creates (POST) notification
+// channels (admin only)
+func (r *Router) handleNotificationChannels(w http.ResponseWriter, req *http.Request) {
+	store := database.NewNotificationStore(r.db)
+	switch req.Method {
+	case http.MethodGet:
+		channels, err := store.List()
+		if err != nil {
+			log.Printf("Failed to list notification channels: %v", err)
+			http.Error(w, "Failed to load notification channels", http.StatusInternalServerError)
+			return
+		}
+		for i := range channels {
+			channels[i] = maskChannel(channels[i])
+		}
+		writeJSON(w, channels)
+
+	case http.MethodPost:
+		var data notificationChannelRequest
+		if err := json.NewDecoder(req.Body).Decode(&data); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+		channel, err := data.channel(nil)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		created, err := store.Create(channel)
+		if err != nil {
+			log.Printf("Failed to create notification channel: %v", err)
+			http.Error(w, "Failed to create notification channel", http.StatusInternalServerError)
+			return
+		}
+		setAudit(req, "", strconv.Itoa(created.ID), created.Type+" "+created.Name)
+		writeJSON(w, maskChannel(*created))
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleNotificationChannelActions updates (PUT) or deletes (DELETE) a
+// notification channel, or sends it a test notification (POST .../test)
+// (admin only)
+func (r *Router) handleNotificationChannelActions(w http.ResponseWriter, req *http.Request) {
+	idPart, action, _ := strings.Cut(strings.Trim(strings.TrimPrefix(req.URL.Path, notificationsPrefix), "/"), "/")
+	id, err := strconv.Atoi(idPart)
+	if err != nil || (action != "" && action != "test") {
+		http.Error(w, "Invalid notification channel ID", http.StatusBadRequest)
+		return
+	}
+
+	store := database.NewNotificationStore(r.db)
+	existing, err := store.Get(id)
+	if errors.Is(err, database.ErrNotificationChannelNotFound) {
+		http.Error(w, "Notification channel not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to load notification channel", http.StatusInternalServerError)
+		return
+	}
+
+	switch {
+	case action == "test" && req.Method == http.MethodPost:
+		setAudit(req, "", "", existing.Type+" "+existing.Name)
+		ctx, cancel := context.WithTimeout(req.Context(), 30*time.Second)
+		defer cancel()
+		if err := notify.SendTest(ctx, *existing); err != nil {
+			log.Printf("Failed to send test notification to channel %d: %v", id, err)
+			http.Error(w, "Test notification failed: "+err.Error(), http.StatusBadGateway)
+			return
+		}
+		writeJSON(w, map[string]bool{"success": true})
+
+	case action == "" && req.Method == http.MethodPut:
+		var data notificationChannelRequest
+		if err := json.NewDecoder(req.Body).Decode(&data); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+		channel, err := data.channel(existing)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		channel.ID = id
+
+		updated, err := store.Update(channel)
+		if err != nil {
+			log.Printf("Failed to update notification channel: %v", err)
+			http.Error(w, "Failed to update notification channel", http.StatusInternalServerError)
+			return
+		}
+		setAudit(req, "", "", updated.Type+" "+updated.Name)
+		writeJSON(w, maskChannel(*updated))
+
+	case action == "" && req.Method == http.MethodDelete:
+		if err := store.Delete(id); err != nil {
+			http.Error(w, "Failed to delete notification channel", http.StatusInternalServerError)
+			return
+		}
+		setAudit(req, "", "", existing.Type+" "+existing.Name)
+		writeJSON(w, map[string]bool{"success": true})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
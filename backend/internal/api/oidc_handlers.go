@@ -0,0 +1,163 @@
+package api
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/weaversgrainthorpe/HOPS/internal/auth"
+)
+
+// oidcStateCookie holds the state of the single sign-on login started in this
+// browser, so that only this browser can complete it
+const oidcStateCookie = "hops_oidc_state"
+
+// oidcStateCookiePath limits the state cookie to the sign-in endpoints
+const oidcStateCookiePath = "/api/auth/oidc/"
+
+// handleGetAuthProviders reports which sign-in methods are available
+func (r *Router) handleGetAuthProviders(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, map[string]bool{"password": true, "oidc": r.oidc != nil, "proxy": r.proxyAuth != nil})
+}
+
+// handleOIDCLogin sends the browser to the OpenID Connect provider. The
+// optional redirect query parameter is the HOPS path to return to.
+func (r *Router) handleOIDCLogin(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if r.oidc == nil {
+		http.Error(w, "Single sign-on is not configured", http.StatusNotFound)
+		return
+	}
+
+	authURL, state, err := r.oidc.AuthCodeURL(req.Context(), localRedirect(req.URL.Query().Get("redirect")))
+	if err != nil {
+		log.Printf("[OIDC] Failed to start sign-in: %v", err)
+		http.Error(w, "Single sign-on is unavailable", http.StatusBadGateway)
+		return
+	}
+	// Lax, as the provider sends the browser back with a top-level GET
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    state,
+		Path:     oidcStateCookiePath,
+		MaxAge:   int(auth.OIDCLoginTimeout.Seconds()),
+		HttpOnly: true,
+		Secure:   r.secureCookies(req),
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, req, authURL, http.StatusFound)
+}
+
+// handleOIDCCallback completes a sign-in when the provider redirects back.
+// The new session is set as a cookie and the browser is sent on to the
+// frontend with the outcome, or an error message, in the URL fragment.
+func (r *Router) handleOIDCCallback(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if r.oidc == nil {
+		http.Error(w, "Single sign-on is not configured", http.StatusNotFound)
+		return
+	}
+
+	var browserState string
+	if cookie, err := req.Cookie(oidcStateCookie); err == nil {
+		browserState = cookie.Value
+	}
+	// The state cookie is only good for one callback, whatever its outcome
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    "",
+		Path:     oidcStateCookiePath,
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   r.secureCookies(req),
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	query := req.URL.Query()
+	if providerError := query.Get("error"); providerError != "" {
+		message := query.Get("error_description")
+		if message == "" {
+			message = providerError
+		}
+		redirectWithFragment(w, req, "/", "sso-error", "Sign-in failed: "+message)
+		return
+	}
+
+	identity, redirect, err := r.oidc.Exchange(req.Context(), query.Get("state"), browserState, query.Get("code"))
+	if redirect == "" {
+		redirect = "/"
+	}
+	if err != nil {
+		log.Printf("[OIDC] Sign-in failed: %v", err)
+		redirectWithFragment(w, req, redirect, "sso-error", oidcErrorMessage(err))
+		return
+	}
+
+	sessionID, err := r.authService.OIDCLogin(r.oidc, identity, r.clientFromRequest(req))
+	if err != nil {
+		log.Printf("[OIDC] Sign-in for %q failed: %v", identity.Username, err)
+		r.recordAudit(req, nil, "auth.login", identity.Username, "single sign-on: "+err.Error(), http.StatusUnauthorized)
+		redirectWithFragment(w, req, redirect, "sso-error", oidcErrorMessage(err))
+		return
+	}
+	if err := r.setSessionCookies(w, req, sessionID); err != nil {
+		log.Printf("[OIDC] Failed to set session cookies: %v", err)
+		redirectWithFragment(w, req, redirect, "sso-error", oidcErrorMessage(err))
+		return
+	}
+	user, _ := r.authService.SessionUser(sessionID)
+	r.recordAudit(req, user, "auth.login", identity.Username, "single sign-on", http.StatusOK)
+	redirectWithFragment(w, req, redirect, "sso-login", "ok")
+}
+
+// oidcErrorMessage returns the message shown to a user whose sign-in failed
+func oidcErrorMessage(err error) string {
+	switch {
+	case errors.Is(err, auth.ErrOIDCState), errors.Is(err, auth.ErrOIDCNoRole),
+		errors.Is(err, auth.ErrOIDCNotProvisioned), errors.Is(err, auth.ErrOIDCUsernameTaken):
+		return err.Error()
+	default:
+		return "Sign-in failed, please try again"
+	}
+}
+
+// redirectWithFragment redirects to a HOPS path with key=value as its URL fragment
+func redirectWithFragment(w http.ResponseWriter, req *http.Request, path, key, value string) {
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	http.Redirect(w, req, path+"#"+key+"="+url.QueryEscape(value), http.StatusFound)
+}
+
+// localRedirect returns path if it is a path on this server, or "/" so that
+// sign-in cannot be used to redirect to another site. Browsers ignore tabs
+// and newlines in URLs and treat backslashes as slashes, so paths with
+// control characters or backslashes are refused too.
+func localRedirect(path string) string {
+	if i := strings.IndexByte(path, '#'); i >= 0 {
+		path = path[:i]
+	}
+	if !strings.HasPrefix(path, "/") || strings.HasPrefix(path, "//") || strings.ContainsRune(path, '\\') {
+		return "/"
+	}
+	for _, c := range path {
+		if c < 0x20 || c == 0x7f {
+			return "/"
+		}
+	}
+	if u, err := url.Parse(path); err != nil || u.Scheme != "" || u.Host != "" {
+		return "/"
+	}
+	return path
+}
@@ -0,0 +1,28 @@
+package api
+
+import "testing"
+
+func TestLocalRedirect(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/home", "/home"},
+		{"/home?tab=media", "/home?tab=media"},
+		{"/home#sso-login=ok", "/home"},
+		{"", "/"},
+		{"home", "/"},
+		{"https://evil.com", "/"},
+		{"//evil.com", "/"},
+		{"/\\evil.com", "/"},
+		{"/\t/evil.com", "/"},
+		{"/\n/evil.com", "/"},
+		{"/\x7f", "/"},
+		{"/home\\..\\evil", "/"},
+	}
+	for _, tt := range tests {
+		if got := localRedirect(tt.path); got != tt.want {
+			t.Errorf("localRedirect(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
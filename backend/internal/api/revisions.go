@@ -0,0 +1,85 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/weaversgrainthorpe/HOPS/internal/configdoc"
+	"github.com/weaversgrainthorpe/HOPS/internal/database"
+)
+
+// apiError aborts a config update with a specific HTTP status and message
+type apiError struct {
+	status  int
+	message string
+}
+
+func (e *apiError) Error() string {
+	return e.message
+}
+
+// writeUpdateError writes the error returned by a config store update. Stale
+// revisions yield 412 with the current revision so the client can merge.
+func writeUpdateError(w http.ResponseWriter, err error) {
+	var conflict *database.RevisionConflictError
+	if errors.As(err, &conflict) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("ETag", revisionETag(conflict.Current))
+		w.WriteHeader(http.StatusPreconditionFailed)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":           "Config has been modified since it was loaded",
+			"currentRevision": conflict.Current,
+		})
+		return
+	}
+
+	var invalid configdoc.ValidationErrors
+	if errors.As(err, &invalid) {
+		writeValidationErrors(w, invalid)
+		return
+	}
+
+	var apiErr *apiError
+	if errors.As(err, &apiErr) {
+		http.Error(w, apiErr.message, apiErr.status)
+		return
+	}
+
+	log.Printf("Config update failed: %v", err)
+	http.Error(w, "Failed to save config", http.StatusInternalServerError)
+}
+
+// writeValidationErrors responds with 422 and the list of validation errors
+func writeValidationErrors(w http.ResponseWriter, errs configdoc.ValidationErrors) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnprocessableEntity)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error":  "Invalid config",
+		"errors": errs,
+	})
+}
+
+// revisionETag formats a config revision as a strong ETag
+func revisionETag(revision int64) string {
+	return `"` + strconv.FormatInt(revision, 10) + `"`
+}
+
+// expectedRevision returns the config revision named by the If-Match header,
+// or 0 if the header is absent or "*". A header that does not name a config
+// revision returns -1, which never matches.
+func expectedRevision(req *http.Request) int64 {
+	ifMatch := strings.TrimSpace(req.Header.Get("If-Match"))
+	if ifMatch == "" || ifMatch == "*" {
+		return 0
+	}
+
+	revision, err := strconv.ParseInt(strings.Trim(ifMatch, `"`), 10, 64)
+	if err != nil || revision <= 0 {
+		return -1
+	}
+	return revision
+}
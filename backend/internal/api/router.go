@@ -1,16 +1,20 @@
 package api
 
 import (
+	"context"
 	"database/sql"
+	"errors"
+	"log"
 	"net/http"
 	"os"
 	"path/filepath"
-	"sync"
-	"time"
+	"strings"
 
 	"github.com/weaversgrainthorpe/HOPS/internal/auth"
 	"github.com/weaversgrainthorpe/HOPS/internal/config"
 	"github.com/weaversgrainthorpe/HOPS/internal/database"
+	"github.com/weaversgrainthorpe/HOPS/internal/models"
+	"github.com/weaversgrainthorpe/HOPS/internal/status"
 )
 
 // Router holds all dependencies for the API
@@ -19,54 +23,22 @@ type Router struct {
 	authService   *auth.Service
 	config        *config.Config
 	mux           *http.ServeMux
-	rateLimiter   *RateLimiter
+	rateLimiter   *RateLimiter // login attempts
+	readLimiter   *RateLimiter // nil when unlimited, like the other route classes
+	writeLimiter  *RateLimiter
+	uploadLimiter *RateLimiter
 	backupManager *database.BackupManager
-}
-
-// RateLimiter provides simple rate limiting for login attempts
-type RateLimiter struct {
-	mu       sync.Mutex
-	attempts map[string][]time.Time
-	limit    int
-	window   time.Duration
-}
-
-// NewRateLimiter creates a rate limiter
-func NewRateLimiter(limit int, window time.Duration) *RateLimiter {
-	return &RateLimiter{
-		attempts: make(map[string][]time.Time),
-		limit:    limit,
-		window:   window,
-	}
-}
-
-// Allow checks if a request from the given IP should be allowed
-func (rl *RateLimiter) Allow(ip string) bool {
-	rl.mu.Lock()
-	defer rl.mu.Unlock()
-
-	now := time.Now()
-	windowStart := now.Add(-rl.window)
-
-	// Filter out old attempts
-	var recent []time.Time
-	for _, t := range rl.attempts[ip] {
-		if t.After(windowStart) {
-			recent = append(recent, t)
-		}
-	}
-
-	if len(recent) >= rl.limit {
-		rl.attempts[ip] = recent
-		return false
-	}
-
-	rl.attempts[ip] = append(recent, now)
-	return true
+	configStore   *database.ConfigStore
+	shareStore    *database.ShareStore
+	auditStore    *database.AuditStore
+	statusHistory *database.StatusHistoryStore
+	statusChecker *status.Checker
+	oidc          *auth.OIDCProvider // nil unless single sign-on is configured
+	proxyAuth     *auth.ProxyAuth    // nil unless reverse proxy authentication is configured
 }
 
 // NewRouter creates a new API router with all routes configured
-func NewRouter(db *sql.DB, authService *auth.Service, cfg *config.Config) http.Handler {
+func NewRouter(db *sql.DB, authService *auth.Service, cfg *config.Config, statusChecker *status.Checker) http.Handler {
 	// Use configured rate limit or default to 20 per minute
 	rateLimit := cfg.LoginRateLimitPerMin
 	if rateLimit <= 0 {
@@ -82,32 +54,88 @@ func NewRouter(db *sql.DB, authService *auth.Service, cfg *config.Config) http.H
 		authService:   authService,
 		config:        cfg,
 		mux:           http.NewServeMux(),
-		rateLimiter:   NewRateLimiter(rateLimit, time.Minute),
+		rateLimiter:   NewRateLimiter(rateLimit, rateLimit),
+		readLimiter:   newClassLimiter(cfg.RateLimits.Read),
+		writeLimiter:  newClassLimiter(cfg.RateLimits.Write),
+		uploadLimiter: newClassLimiter(cfg.RateLimits.Upload),
 		backupManager: backupManager,
+		configStore:   database.NewConfigStore(db),
+		shareStore:    database.NewShareStore(db),
+		auditStore:    database.NewAuditStore(db),
+		statusHistory: database.NewStatusHistoryStore(db),
+		statusChecker: statusChecker,
+	}
+
+	if cfg.OIDC.Enabled() {
+		r.oidc = auth.NewOIDCProvider(cfg.OIDC)
+	}
+	if cfg.ProxyAuth.Enabled() {
+		r.proxyAuth = auth.NewProxyAuth(cfg.ProxyAuth, cfg.TrustedProxies)
 	}
 
 	r.setupRoutes()
-	return r.corsMiddleware(r.loggingMiddleware(r.mux))
+	return r.corsMiddleware(r.loggingMiddleware(r.rateLimitMiddleware(r.bodyLimitMiddleware(r.mux))))
 }
 
 // setupRoutes configures all API routes
 func (r *Router) setupRoutes() {
 	// Public API routes
 	r.mux.HandleFunc("/api/version", r.handleGetVersion)
-	r.mux.HandleFunc("/api/config", r.handleGetConfig)
+	r.mux.HandleFunc("/api/config", r.handleConfig)
+	r.mux.HandleFunc("/api/status", r.handleStatuses)
+	r.mux.HandleFunc("/api/status/stream", r.handleStatusStream)
 	r.mux.HandleFunc("/api/status/", r.handleGetStatus)
 	r.mux.HandleFunc("/api/auth/login", r.handleLogin)
+	r.mux.HandleFunc("/api/auth/providers", r.handleGetAuthProviders)
+	r.mux.HandleFunc("/api/auth/oidc/login", r.handleOIDCLogin)
+	r.mux.HandleFunc("/api/auth/oidc/callback", r.handleOIDCCallback)
+	r.mux.HandleFunc("/api/share/", r.handleGetSharedDashboard)
 
 	// Protected API routes (require authentication)
 	r.mux.HandleFunc("/api/auth/logout", r.authMiddleware(r.handleLogout))
 	r.mux.HandleFunc("/api/auth/change-password", r.authMiddleware(r.handleChangePassword))
-	r.mux.HandleFunc("/api/config/update", r.authMiddleware(r.handleUpdateConfig))
-	r.mux.HandleFunc("/api/config/export", r.authMiddleware(r.handleExportConfig))
-	r.mux.HandleFunc("/api/config/import", r.authMiddleware(r.handleImportConfig))
-
-	// Backup management routes
-	r.mux.HandleFunc("/api/backups", r.authMiddleware(r.handleBackups))
-	r.mux.HandleFunc("/api/backups/", r.authMiddleware(r.handleBackupActions))
+	r.mux.HandleFunc("/api/auth/me", r.authMiddleware(r.handleGetCurrentUser))
+	r.mux.HandleFunc("/api/auth/sessions", r.authMiddleware(r.handleSessions))
+	r.mux.HandleFunc("/api/auth/sessions/", r.authMiddleware(r.handleSessionActions))
+	r.mux.HandleFunc("/api/auth/totp", r.authMiddleware(r.handleTOTP))
+	r.mux.HandleFunc("/api/auth/totp/enroll", r.authMiddleware(r.handleTOTPEnroll))
+	r.mux.HandleFunc("/api/auth/totp/enable", r.authMiddleware(r.handleTOTPEnable))
+	r.mux.HandleFunc("/api/auth/totp/recovery-codes", r.authMiddleware(r.handleTOTPRecoveryCodes))
+
+	// Config routes (require the editor role)
+	r.mux.HandleFunc("/api/config/update", r.requireRole(auth.RoleEditor, r.handleUpdateConfig))
+	r.mux.HandleFunc("/api/config/validate", r.requireRole(auth.RoleEditor, r.handleValidateConfig))
+	r.mux.HandleFunc("/api/config/export", r.requireRole(auth.RoleEditor, r.handleExportConfig))
+	r.mux.HandleFunc("/api/config/import", r.requireRole(auth.RoleEditor, r.handleImportConfig))
+	r.mux.HandleFunc("/api/config/history", r.requireRole(auth.RoleEditor, r.handleConfigHistory))
+	r.mux.HandleFunc("/api/config/history/", r.requireRole(auth.RoleEditor, r.handleConfigHistoryActions))
+
+	// Dashboard/tab/group/entry resource routes (reads are public, writes require the editor role)
+	r.mux.HandleFunc("/api/dashboards", r.handleDashboards)
+	r.mux.HandleFunc("/api/dashboards/", r.handleDashboards)
+
+	// Backup management routes (require the admin role)
+	r.mux.HandleFunc("/api/backups", r.requireRole(auth.RoleAdmin, r.handleBackups))
+	r.mux.HandleFunc("/api/backups/", r.requireRole(auth.RoleAdmin, r.handleBackupActions))
+
+	// User management routes (require the admin role)
+	r.mux.HandleFunc("/api/users", r.requireRole(auth.RoleAdmin, r.handleUsers))
+	r.mux.HandleFunc("/api/users/", r.requireRole(auth.RoleAdmin, r.handleUserActions))
+
+	// API token routes (any signed-in user can manage their own tokens)
+	r.mux.HandleFunc("/api/tokens", r.authMiddleware(r.handleTokens))
+	r.mux.HandleFunc("/api/tokens/", r.authMiddleware(r.handleTokenActions))
+
+	// Dashboard share link routes (require the admin role)
+	r.mux.HandleFunc("/api/shares", r.requireRole(auth.RoleAdmin, r.handleShares))
+	r.mux.HandleFunc("/api/shares/", r.requireRole(auth.RoleAdmin, r.handleShareActions))
+
+	// Status notification channel routes (require the admin role)
+	r.mux.HandleFunc("/api/notifications", r.requireRole(auth.RoleAdmin, r.handleNotificationChannels))
+	r.mux.HandleFunc("/api/notifications/", r.requireRole(auth.RoleAdmin, r.handleNotificationChannelActions))
+
+	// Audit log route (requires the admin role)
+	r.mux.HandleFunc("/api/audit", r.requireRole(auth.RoleAdmin, r.handleAudit))
 
 	// Widget/integration routes (reserved for future use)
 	// r.mux.HandleFunc("/api/integrations/", r.handleIntegrations)
@@ -116,7 +144,7 @@ func (r *Router) setupRoutes() {
 	r.mux.HandleFunc("/api/icon-categories", r.handleGetIconCategories)
 	r.mux.HandleFunc("/api/icon-categories/", r.handleIconCategoryActions)
 	r.mux.HandleFunc("/api/icons", r.handleGetIcons)
-	r.mux.HandleFunc("/api/icons/upload", r.authMiddleware(r.handleUploadIcon))
+	r.mux.HandleFunc("/api/icons/upload", r.requireRole(auth.RoleEditor, r.handleUploadIcon))
 	r.mux.HandleFunc("/api/icons/", r.handleIconActions)
 
 	// Serve uploaded icons from data directory
@@ -127,9 +155,9 @@ func (r *Router) setupRoutes() {
 
 	// Background image routes
 	r.mux.HandleFunc("/api/backgrounds", r.handleBackgrounds)
-	r.mux.HandleFunc("/api/backgrounds/categories", r.authMiddleware(r.handleBackgroundCategories))
-	r.mux.HandleFunc("/api/backgrounds/categories/", r.authMiddleware(r.handleBackgroundCategoryActions))
-	r.mux.HandleFunc("/api/backgrounds/", r.authMiddleware(r.handleBackgroundActions))
+	r.mux.HandleFunc("/api/backgrounds/categories", r.requireRole(auth.RoleEditor, r.handleBackgroundCategories))
+	r.mux.HandleFunc("/api/backgrounds/categories/", r.requireRole(auth.RoleEditor, r.handleBackgroundCategoryActions))
+	r.mux.HandleFunc("/api/backgrounds/", r.requireRole(auth.RoleEditor, r.handleBackgroundActions))
 
 	// Serve uploaded backgrounds from data directory
 	r.mux.HandleFunc("/backgrounds/", r.serveBackgrounds)
@@ -141,13 +169,25 @@ func (r *Router) setupRoutes() {
 	r.mux.HandleFunc("/", r.serveSPA)
 }
 
+// handleConfig routes GET (public) and PATCH (editor) requests for the config
+func (r *Router) handleConfig(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		r.handleGetConfig(w, req)
+	case http.MethodPatch:
+		r.requireRole(auth.RoleEditor, r.handlePatchConfig)(w, req)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
 // handleBackgrounds routes GET and POST requests for backgrounds
 func (r *Router) handleBackgrounds(w http.ResponseWriter, req *http.Request) {
 	switch req.Method {
 	case http.MethodGet:
 		r.handleListBackgrounds(w, req)
 	case http.MethodPost:
-		r.authMiddleware(r.handleUploadBackground)(w, req)
+		r.requireRole(auth.RoleEditor, r.handleUploadBackground)(w, req)
 	default:
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 	}
@@ -253,6 +293,12 @@ func (r *Router) serveSPA(w http.ResponseWriter, req *http.Request) {
 		}
 	}
 
+	// Share link pages carry their token in the URL, so don't send it on
+	// to the services the dashboard links to
+	if strings.HasPrefix(req.URL.Path, "/s/") {
+		w.Header().Set("Referrer-Policy", "no-referrer")
+	}
+
 	// File doesn't exist or it's a SPA route, serve index.html
 	indexPath := filepath.Join(r.config.FrontendDir, "index.html")
 	http.ServeFile(w, req, indexPath)
@@ -284,7 +330,7 @@ func (r *Router) corsMiddleware(next http.Handler) http.Handler {
 
 			if allowed {
 				w.Header().Set("Access-Control-Allow-Origin", origin)
-				w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+				w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
 				w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-CSRF-Token")
 				w.Header().Set("Access-Control-Allow-Credentials", "true")
 				w.Header().Set("Vary", "Origin")
@@ -300,21 +346,125 @@ func (r *Router) corsMiddleware(next http.Handler) http.Handler {
 	})
 }
 
-// authMiddleware validates session token for protected routes
+// authMiddleware validates the session or API token for protected routes.
+// API tokens are limited to the routes their scopes allow (see tokenScope).
+// Requests that get this far are recorded in the audit log (see withAudit).
 func (r *Router) authMiddleware(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, req *http.Request) {
-		sessionID := extractSessionID(req)
-		if sessionID == "" {
+		user, scopes, err := r.authenticate(req)
+		if err != nil {
 			http.Error(w, "Unauthorized", http.StatusUnauthorized)
 			return
 		}
-
-		_, err := r.authService.ValidateSession(sessionID)
-		if err != nil {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		if scopes != nil && !auth.TokenAllows(scopes, tokenScope(req)) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		// Without a session ID or token, the reverse proxy signed the request in
+		proxied := extractSessionID(req) == ""
+		if proxied && !hasCSRFCookie(req) {
+			if err := r.setCSRFCookie(w, req, 0); err != nil {
+				log.Printf("Failed to set CSRF cookie: %v", err)
+				http.Error(w, "Failed to authenticate", http.StatusInternalServerError)
+				return
+			}
+		}
+		if !safeMethod(req.Method) && (usesSessionCookie(req) || proxied) && !validCSRF(req) {
+			http.Error(w, "Invalid CSRF token", http.StatusForbidden)
+			return
+		}
+		if user.MustChangePassword && !passwordChangeRoutes[req.URL.Path] {
+			http.Error(w, "Password change required", http.StatusForbidden)
 			return
 		}
 
+		ctx := context.WithValue(req.Context(), userKey, user)
+		r.withAudit(user, next)(w, req.WithContext(ctx))
+	}
+}
+
+// authenticate returns the user signed in with the request's session ID or
+// API token, or asserted by a trusted reverse proxy's headers if the request
+// has neither. For API tokens it also returns the token's scopes.
+func (r *Router) authenticate(req *http.Request) (*models.User, []string, error) {
+	credential := extractSessionID(req)
+	if credential == "" {
+		if r.proxyAuth != nil {
+			if username, role, ok := r.proxyAuth.Identity(req); ok {
+				user, err := r.authService.ProxyUser(r.proxyAuth, username, role)
+				return user, nil, err
+			}
+		}
+		return nil, nil, errors.New("no credentials")
+	}
+	if strings.HasPrefix(credential, auth.TokenPrefix) {
+		return r.authService.TokenUser(credential)
+	}
+	user, err := r.authService.SessionUser(credential)
+	return user, nil, err
+}
+
+// passwordChangeRoutes are the only routes open to users who must change
+// their password
+var passwordChangeRoutes = map[string]bool{
+	"/api/auth/change-password": true,
+	"/api/auth/logout":          true,
+	"/api/auth/me":              true,
+}
+
+// tokenScope returns the API token scope a request needs, or "" for routes
+// that need a session, such as user, token and share link management
+func tokenScope(req *http.Request) string {
+	path := req.URL.Path
+	switch {
+	case path == "/api/auth/me":
+		return auth.ScopeRead
+	case strings.HasPrefix(path, "/api/backups"):
+		return auth.ScopeBackup
+	case strings.HasPrefix(path, "/api/config"), strings.HasPrefix(path, "/api/dashboards"),
+		strings.HasPrefix(path, "/api/icon"), strings.HasPrefix(path, "/api/backgrounds"):
+		if req.Method == http.MethodGet || req.Method == http.MethodHead {
+			return auth.ScopeRead
+		}
+		return auth.ScopeConfigWrite
+	}
+	return ""
+}
+
+// requireRole is authMiddleware for routes that need at least the given role
+func (r *Router) requireRole(role string, next http.HandlerFunc) http.HandlerFunc {
+	return r.authMiddleware(func(w http.ResponseWriter, req *http.Request) {
+		if user := userFromRequest(req); user == nil || !auth.HasRole(user.Role, role) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
 		next(w, req)
+	})
+}
+
+// contextKey is the type for request context keys set by the API
+type contextKey int
+
+const (
+	userKey contextKey = iota
+	auditKey
+)
+
+// userFromRequest returns the user authenticated by authMiddleware, or nil
+func userFromRequest(req *http.Request) *models.User {
+	user, _ := req.Context().Value(userKey).(*models.User)
+	return user
+}
+
+// userIDFromRequest returns the ID of the user authenticated by authMiddleware, or 0
+func userIDFromRequest(req *http.Request) int {
+	if user := userFromRequest(req); user != nil {
+		return user.ID
 	}
+	return 0
+}
+
+// configChange describes a config write made by the request's user
+func configChange(req *http.Request, reason string) database.Change {
+	return database.Change{UserID: userIDFromRequest(req), Reason: reason}
 }
@@ -0,0 +1,63 @@
+package api
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/weaversgrainthorpe/HOPS/internal/auth"
+)
+
+const sessionsPrefix = "/api/auth/sessions/"
+
+// handleSessions lists (GET) the signed-in user's sessions, or signs them out
+// everywhere except the current session (DELETE)
+func (r *Router) handleSessions(w http.ResponseWriter, req *http.Request) {
+	userID := userIDFromRequest(req)
+	currentID := extractSessionID(req)
+
+	switch req.Method {
+	case http.MethodGet:
+		sessions, err := r.authService.ListSessions(userID, currentID)
+		if err != nil {
+			log.Printf("Failed to list sessions: %v", err)
+			http.Error(w, "Failed to load sessions", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, sessions)
+
+	case http.MethodDelete:
+		revoked, err := r.authService.RevokeOtherSessions(userID, currentID)
+		if err != nil {
+			log.Printf("Failed to revoke sessions: %v", err)
+			http.Error(w, "Failed to revoke sessions", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, map[string]interface{}{"success": true, "revoked": revoked})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleSessionActions signs out (DELETE) one of the signed-in user's sessions
+func (r *Router) handleSessionActions(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	handle := strings.Trim(req.URL.Path[len(sessionsPrefix):], "/")
+	err := r.authService.RevokeSession(userIDFromRequest(req), handle)
+	if errors.Is(err, auth.ErrSessionNotFound) {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to revoke session: %v", err)
+		http.Error(w, "Failed to revoke session", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, map[string]bool{"success": true})
+}
@@ -0,0 +1,125 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/weaversgrainthorpe/HOPS/internal/configdoc"
+	"github.com/weaversgrainthorpe/HOPS/internal/database"
+)
+
+const (
+	sharePrefix  = "/api/share/"
+	sharesPrefix = "/api/shares/"
+)
+
+// handleGetSharedDashboard returns the dashboard a share link points to as a
+// config document containing only that dashboard (public). The dashboard's
+// visibility does not apply: holding the link is enough.
+func (r *Router) handleGetSharedDashboard(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	link, err := r.shareStore.Lookup(strings.TrimPrefix(req.URL.Path, sharePrefix))
+	if errors.Is(err, database.ErrShareNotFound) {
+		http.Error(w, "Share link not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to load share link", http.StatusInternalServerError)
+		return
+	}
+
+	doc, _, err := r.configStore.Load()
+	if err != nil {
+		http.Error(w, "Failed to load config", http.StatusInternalServerError)
+		return
+	}
+	shared, _ := configdoc.FilterDashboards(doc, func(dashboard map[string]interface{}) bool {
+		id, _ := dashboard["id"].(string)
+		return id == link.DashboardID
+	})
+	if len(configdoc.Items(shared, configdoc.LevelDashboard.Collection())) == 0 {
+		http.Error(w, "Share link not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Robots-Tag", "noindex")
+	writeJSON(w, shared)
+}
+
+// handleShares lists (GET) or creates (POST) share links (admin only)
+func (r *Router) handleShares(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		links, err := r.shareStore.List()
+		if err != nil {
+			http.Error(w, "Failed to load share links", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, links)
+
+	case http.MethodPost:
+		var data struct {
+			DashboardID string     `json:"dashboardId"`
+			Label       string     `json:"label"`
+			ExpiresAt   *time.Time `json:"expiresAt"`
+		}
+		if err := json.NewDecoder(req.Body).Decode(&data); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+		if data.ExpiresAt != nil && !data.ExpiresAt.After(time.Now()) {
+			http.Error(w, "expiresAt must be in the future", http.StatusBadRequest)
+			return
+		}
+
+		doc, _, err := r.configStore.Load()
+		if err != nil {
+			http.Error(w, "Failed to load config", http.StatusInternalServerError)
+			return
+		}
+		if dashboard, _ := configdoc.FindByID(configdoc.Items(doc, configdoc.LevelDashboard.Collection()), data.DashboardID); dashboard == nil {
+			http.Error(w, "Dashboard not found", http.StatusBadRequest)
+			return
+		}
+
+		link, err := r.shareStore.Create(data.DashboardID, strings.TrimSpace(data.Label), data.ExpiresAt, userIDFromRequest(req))
+		if err != nil {
+			log.Printf("Failed to create share link: %v", err)
+			http.Error(w, "Failed to create share link", http.StatusInternalServerError)
+			return
+		}
+		setAudit(req, "", data.DashboardID, link.Label)
+		writeJSON(w, link)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleShareActions revokes (DELETE) a share link (admin only)
+func (r *Router) handleShareActions(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	err := r.shareStore.Revoke(strings.TrimPrefix(req.URL.Path, sharesPrefix))
+	if errors.Is(err, database.ErrShareNotFound) {
+		http.Error(w, "Share link not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to revoke share link", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, map[string]bool{"success": true})
+}
@@ -0,0 +1,341 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/weaversgrainthorpe/HOPS/internal/configdoc"
+	"github.com/weaversgrainthorpe/HOPS/internal/database"
+	"github.com/weaversgrainthorpe/HOPS/internal/models"
+	"github.com/weaversgrainthorpe/HOPS/internal/status"
+)
+
+// statusStreamHeartbeat is how often an idle status stream sends a comment
+const statusStreamHeartbeat = 30 * time.Second
+
+// statusRanges are the periods uptime is reported over, and that the history
+// series and latency can cover
+var statusRanges = []struct {
+	name     string
+	duration time.Duration
+}{
+	{"24h", 24 * time.Hour},
+	{"7d", 7 * 24 * time.Hour},
+	{"30d", 30 * 24 * time.Hour},
+}
+
+// handleStatusHistory returns an entry's uptime over each of statusRanges,
+// and latency percentiles and a series of points over the range query
+// parameter (default 24h). The points query parameter sets the length of the
+// series (default 48, max 500). Entries the caller may not see are not found,
+// unless the share query parameter has a share link to their dashboard.
+func (r *Router) handleStatusHistory(w http.ResponseWriter, req *http.Request, entryID string) {
+	if entryID == "" {
+		http.Error(w, "Entry ID required", http.StatusBadRequest)
+		return
+	}
+	if !r.entryInScope(w, req, entryID) {
+		return
+	}
+
+	rangeName := req.URL.Query().Get("range")
+	if rangeName == "" {
+		rangeName = "24h"
+	}
+	var period time.Duration
+	for _, statusRange := range statusRanges {
+		if statusRange.name == rangeName {
+			period = statusRange.duration
+		}
+	}
+	if period == 0 {
+		http.Error(w, "Invalid range: use 24h, 7d or 30d", http.StatusBadRequest)
+		return
+	}
+	points := queryInt(req, "points", 48)
+	if points <= 0 || points > 500 {
+		points = 48
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	uptime := make(map[string]*float64, len(statusRanges))
+	for _, statusRange := range statusRanges {
+		value, err := r.statusHistory.Uptime(entryID, now.Add(-statusRange.duration))
+		if err != nil {
+			log.Printf("Failed to load uptime for %s: %v", entryID, err)
+			http.Error(w, "Failed to load status history", http.StatusInternalServerError)
+			return
+		}
+		uptime[statusRange.name] = value
+	}
+
+	latency, err := r.statusHistory.Latency(entryID, now.Add(-period))
+	if err != nil {
+		log.Printf("Failed to load latency for %s: %v", entryID, err)
+		http.Error(w, "Failed to load status history", http.StatusInternalServerError)
+		return
+	}
+	series, err := r.statusHistory.Series(entryID, now.Add(-period), now, points)
+	if err != nil {
+		log.Printf("Failed to load status series for %s: %v", entryID, err)
+		http.Error(w, "Failed to load status history", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, map[string]interface{}{
+		"entryId": entryID,
+		"uptime":  uptime,
+		"range":   rangeName,
+		"latency": latency,
+		"series":  series,
+	})
+}
+
+// errStatusScopeNotFound is returned by statusEntries when the requested
+// dashboard or share link does not exist or may not be seen
+var errStatusScopeNotFound = errors.New("dashboard not found")
+
+// statusEntries returns the IDs of the entries with status checks enabled
+// that a status request covers, and the config revision they were read from
+// (see scopedEntries)
+func (r *Router) statusEntries(req *http.Request) (map[string]bool, int64, error) {
+	entries, revision, err := r.scopedEntries(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	ids := make(map[string]bool)
+	for _, entry := range entries {
+		check, _ := entry["statusCheck"].(map[string]interface{})
+		enabled, _ := check["enabled"].(bool)
+		if id, _ := entry["id"].(string); id != "" && enabled {
+			ids[id] = true
+		}
+	}
+	return ids, revision, nil
+}
+
+// scopedEntries returns the entries a status request covers, and the config
+// revision they were read from. These are the entries of the dashboard query
+// parameter, of the dashboard of the share query parameter's share link, or
+// of every dashboard the caller may see.
+func (r *Router) scopedEntries(req *http.Request) ([]map[string]interface{}, int64, error) {
+	query := req.URL.Query()
+	dashboardID := query.Get("dashboard")
+	var link *models.ShareLink
+	if token := query.Get("share"); token != "" {
+		var err error
+		link, err = r.shareStore.Lookup(token)
+		if errors.Is(err, database.ErrShareNotFound) {
+			return nil, 0, errStatusScopeNotFound
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		if dashboardID != "" && dashboardID != link.DashboardID {
+			return nil, 0, errStatusScopeNotFound
+		}
+		dashboardID = link.DashboardID
+	}
+
+	doc, revision, err := r.configStore.Load()
+	if err != nil {
+		return nil, 0, err
+	}
+	// Share links show their dashboard whatever its visibility
+	if link == nil {
+		doc, _ = visibleConfig(doc, r.optionalUser(req))
+	}
+
+	node := doc
+	level := configdoc.LevelDashboard
+	if dashboardID != "" {
+		dashboard, _ := configdoc.FindByID(configdoc.Items(doc, level.Collection()), dashboardID)
+		if dashboard == nil {
+			return nil, 0, errStatusScopeNotFound
+		}
+		node, level = dashboard, configdoc.LevelTab
+	}
+	return configdoc.Entries(node, level), revision, nil
+}
+
+// entryInScope reports whether a status request covers the entry with the
+// given ID (see scopedEntries), writing an error response if it does not.
+// Responses depend on who is signed in, so they vary with the credentials.
+func (r *Router) entryInScope(w http.ResponseWriter, req *http.Request, entryID string) bool {
+	w.Header().Add("Vary", "Authorization, Cookie")
+	entries, _, err := r.scopedEntries(req)
+	if err != nil && !errors.Is(err, errStatusScopeNotFound) {
+		log.Printf("Failed to load status entries: %v", err)
+		http.Error(w, "Failed to load config", http.StatusInternalServerError)
+		return false
+	}
+	for _, entry := range entries {
+		if id, _ := entry["id"].(string); id == entryID {
+			return true
+		}
+	}
+	http.Error(w, "Entry not found", http.StatusNotFound)
+	return false
+}
+
+// cachedStatuses returns the latest check results of the given entries, with
+// status unknown for entries not checked yet
+func (r *Router) cachedStatuses(ids map[string]bool) ([]status.StatusResult, error) {
+	rows, err := r.db.Query("SELECT entry_id, status, response_time, last_checked FROM status_cache")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	cached := make(map[string]status.StatusResult)
+	for rows.Next() {
+		var result status.StatusResult
+		var responseTime sql.NullInt64
+		var lastChecked time.Time
+		if err := rows.Scan(&result.EntryID, &result.Status, &responseTime, &lastChecked); err != nil {
+			return nil, err
+		}
+		if ids[result.EntryID] {
+			result.ResponseTime = responseTime.Int64
+			result.LastChecked = lastChecked.UTC().Format(time.RFC3339)
+			cached[result.EntryID] = result
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	results := make([]status.StatusResult, 0, len(ids))
+	for id := range ids {
+		result, ok := cached[id]
+		if !ok {
+			result = status.StatusResult{EntryID: id, Status: "unknown"}
+		}
+		results = append(results, result)
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].EntryID < results[j].EntryID })
+	return results, nil
+}
+
+// handleStatuses returns the latest status of every entry with status checks
+// enabled, limited by the dashboard or share query parameter (see
+// statusEntries)
+func (r *Router) handleStatuses(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ids, _, err := r.statusEntries(req)
+	if errors.Is(err, errStatusScopeNotFound) {
+		http.Error(w, "Dashboard not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to load status entries: %v", err)
+		http.Error(w, "Failed to load config", http.StatusInternalServerError)
+		return
+	}
+
+	results, err := r.cachedStatuses(ids)
+	if err != nil {
+		log.Printf("Failed to load statuses: %v", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Add("Vary", "Authorization, Cookie")
+	writeJSON(w, results)
+}
+
+// handleStatusStream streams status check results as Server-Sent Events,
+// for the same entries and with the same query parameters as handleStatuses.
+// Each result is a "status" event; the current statuses are sent first.
+func (r *Router) handleStatusStream(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ids, revision, err := r.statusEntries(req)
+	if errors.Is(err, errStatusScopeNotFound) {
+		http.Error(w, "Dashboard not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to load status entries: %v", err)
+		http.Error(w, "Failed to load config", http.StatusInternalServerError)
+		return
+	}
+
+	// Subscribe before reading the cache so that no result is missed
+	results, unsubscribe := r.statusChecker.Subscribe()
+	defer unsubscribe()
+	current, err := r.cachedStatuses(ids)
+	if err != nil {
+		log.Printf("Failed to load statuses: %v", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	controller := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Accel-Buffering", "no") // keep nginx from buffering events
+	w.WriteHeader(http.StatusOK)
+
+	send := func(result status.StatusResult) bool {
+		data, err := json.Marshal(result)
+		if err != nil {
+			return false
+		}
+		if _, err := fmt.Fprintf(w, "event: status\ndata: %s\n\n", data); err != nil {
+			return false
+		}
+		return controller.Flush() == nil
+	}
+	for _, result := range current {
+		if !send(result) {
+			return
+		}
+	}
+	if controller.Flush() != nil {
+		return
+	}
+
+	heartbeat := time.NewTicker(statusStreamHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case result := <-results:
+			if !ids[result.EntryID] {
+				// The entry may have been added since the stream started
+				latest, err := r.configStore.Revision()
+				if err != nil || latest == revision {
+					continue
+				}
+				if ids, revision, err = r.statusEntries(req); err != nil {
+					return
+				}
+				if !ids[result.EntryID] {
+					continue
+				}
+			}
+			if !send(result) {
+				return
+			}
+		case <-heartbeat.C:
+			// Comments keep idle connections open through proxies
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil || controller.Flush() != nil {
+				return
+			}
+		case <-req.Context().Done():
+			return
+		}
+	}
+}
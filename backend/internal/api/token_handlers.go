@@ -0,0 +1,103 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/weaversgrainthorpe/HOPS/internal/auth"
+	"github.com/weaversgrainthorpe/HOPS/internal/models"
+)
+
+const tokensPrefix = "/api/tokens/"
+
+// handleTokens lists (GET) or creates (POST) API tokens. Users manage their
+// own tokens; admins can also see everyone's.
+func (r *Router) handleTokens(w http.ResponseWriter, req *http.Request) {
+	user := userFromRequest(req)
+
+	switch req.Method {
+	case http.MethodGet:
+		owner := user.ID
+		if auth.HasRole(user.Role, auth.RoleAdmin) {
+			owner = 0
+		}
+		tokens, err := r.authService.ListTokens(owner)
+		if err != nil {
+			http.Error(w, "Failed to load API tokens", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, tokens)
+
+	case http.MethodPost:
+		var data struct {
+			Name      string     `json:"name"`
+			Scopes    []string   `json:"scopes"`
+			ExpiresAt *time.Time `json:"expiresAt"`
+		}
+		if err := json.NewDecoder(req.Body).Decode(&data); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+
+		token, apiToken, err := r.authService.CreateToken(user.ID, data.Name, data.Scopes, data.ExpiresAt)
+		switch {
+		case errors.Is(err, auth.ErrTokenName), errors.Is(err, auth.ErrTokenScopes), errors.Is(err, auth.ErrTokenExpiresAt):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		case err != nil:
+			log.Printf("Failed to create API token: %v", err)
+			http.Error(w, "Failed to create API token", http.StatusInternalServerError)
+			return
+		}
+
+		setAudit(req, "", strconv.Itoa(apiToken.ID), fmt.Sprintf("%s (%s)", apiToken.Name, strings.Join(apiToken.Scopes, ", ")))
+
+		// The token is only ever shown in this response
+		writeJSON(w, struct {
+			models.APIToken
+			Token string `json:"token"`
+		}{*apiToken, token})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleTokenActions revokes (DELETE) an API token. Users can revoke their
+// own tokens and admins anyone's.
+func (r *Router) handleTokenActions(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id, err := strconv.Atoi(strings.Trim(req.URL.Path[len(tokensPrefix):], "/"))
+	if err != nil {
+		http.Error(w, "Invalid token ID", http.StatusBadRequest)
+		return
+	}
+
+	user := userFromRequest(req)
+	token, err := r.authService.GetToken(id)
+	if err == nil && token.UserID != user.ID && !auth.HasRole(user.Role, auth.RoleAdmin) {
+		err = auth.ErrTokenNotFound
+	}
+	if err == nil {
+		err = r.authService.RevokeToken(id)
+	}
+	if errors.Is(err, auth.ErrTokenNotFound) {
+		http.Error(w, "API token not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to revoke API token", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, map[string]bool{"success": true})
+}
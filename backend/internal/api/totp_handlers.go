@@ -0,0 +1,129 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/weaversgrainthorpe/HOPS/internal/auth"
+)
+
+// handleTOTP reports (GET) or turns off (DELETE) the signed-in user's
+// two-factor authentication. Turning it off needs a current code.
+func (r *Router) handleTOTP(w http.ResponseWriter, req *http.Request) {
+	userID := userIDFromRequest(req)
+
+	switch req.Method {
+	case http.MethodGet:
+		enabled, remaining, err := r.authService.TOTPStatus(userID)
+		if err != nil {
+			writeTOTPError(w, err)
+			return
+		}
+		writeJSON(w, map[string]interface{}{
+			"enabled":                enabled,
+			"recoveryCodesRemaining": remaining,
+		})
+
+	case http.MethodDelete:
+		code, ok := decodeTOTPCode(w, req)
+		if !ok {
+			return
+		}
+		if err := r.authService.DisableTOTP(userID, code); err != nil {
+			writeTOTPError(w, err)
+			return
+		}
+		writeJSON(w, map[string]bool{"success": true})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleTOTPEnroll starts two-factor enrolment, returning a new secret and
+// the otpauth:// URI to show as a QR code
+func (r *Router) handleTOTPEnroll(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	secret, uri, err := r.authService.EnrollTOTP(userIDFromRequest(req))
+	if err != nil {
+		writeTOTPError(w, err)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, map[string]string{"secret": secret, "uri": uri})
+}
+
+// handleTOTPEnable finishes enrolment with a code from the authenticator app
+// and returns the recovery codes, which are only ever shown in this response
+func (r *Router) handleTOTPEnable(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	code, ok := decodeTOTPCode(w, req)
+	if !ok {
+		return
+	}
+
+	codes, err := r.authService.EnableTOTP(userIDFromRequest(req), code)
+	if err != nil {
+		writeTOTPError(w, err)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, map[string][]string{"recoveryCodes": codes})
+}
+
+// handleTOTPRecoveryCodes replaces the signed-in user's recovery codes
+func (r *Router) handleTOTPRecoveryCodes(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	code, ok := decodeTOTPCode(w, req)
+	if !ok {
+		return
+	}
+
+	codes, err := r.authService.RegenerateRecoveryCodes(userIDFromRequest(req), code)
+	if err != nil {
+		writeTOTPError(w, err)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, map[string][]string{"recoveryCodes": codes})
+}
+
+// decodeTOTPCode reads the {"code": "..."} request body, writing an error
+// response if it is invalid
+func decodeTOTPCode(w http.ResponseWriter, req *http.Request) (string, bool) {
+	var data struct {
+		Code string `json:"code"`
+	}
+	if err := json.NewDecoder(req.Body).Decode(&data); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return "", false
+	}
+	return data.Code, true
+}
+
+// writeTOTPError maps two-factor authentication errors to HTTP responses
+func writeTOTPError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, auth.ErrUserNotFound):
+		http.Error(w, "User not found", http.StatusNotFound)
+	case errors.Is(err, auth.ErrTOTPRequired), errors.Is(err, auth.ErrInvalidTOTP):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, auth.ErrTOTPEnabled), errors.Is(err, auth.ErrTOTPNotEnabled), errors.Is(err, auth.ErrTOTPNotEnrolled):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		log.Printf("Two-factor authentication failed: %v", err)
+		http.Error(w, "Failed to update two-factor authentication", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,190 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/weaversgrainthorpe/HOPS/internal/auth"
+)
+
+const usersPrefix = "/api/users/"
+
+// handleGetCurrentUser returns the signed-in user
+func (r *Router) handleGetCurrentUser(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	user, err := r.authService.GetUser(userIDFromRequest(req))
+	if err != nil {
+		writeUserError(w, err)
+		return
+	}
+	writeJSON(w, user)
+}
+
+// handleUsers lists (GET) or creates (POST) user accounts (admin only)
+func (r *Router) handleUsers(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		users, err := r.authService.ListUsers()
+		if err != nil {
+			http.Error(w, "Failed to load users", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, users)
+
+	case http.MethodPost:
+		var data struct {
+			Username string `json:"username"`
+			Password string `json:"password"`
+			Role     string `json:"role"`
+		}
+		if err := json.NewDecoder(req.Body).Decode(&data); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+		if data.Role == "" {
+			data.Role = auth.RoleViewer
+		}
+
+		user, err := r.authService.CreateUser(data.Username, data.Password, data.Role)
+		if err != nil {
+			writeUserError(w, err)
+			return
+		}
+		setAudit(req, "", strconv.Itoa(user.ID), fmt.Sprintf("%s (%s)", user.Username, user.Role))
+		writeJSON(w, user)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleUserActions reads (GET), updates (PUT/PATCH) or deletes (DELETE) a
+// single user account (admin only). Updates may change the username, role
+// and password; omitted fields are kept. DELETE /api/users/{id}/totp resets
+// the user's two-factor authentication and DELETE /api/users/{id}/lockout
+// ends a lockout after failed logins.
+func (r *Router) handleUserActions(w http.ResponseWriter, req *http.Request) {
+	path := strings.Trim(req.URL.Path[len(usersPrefix):], "/")
+	path, resetTOTP := strings.CutSuffix(path, "/totp")
+	path, unlock := strings.CutSuffix(path, "/lockout")
+	id, err := strconv.Atoi(path)
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	if resetTOTP {
+		if req.Method != http.MethodDelete {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if err := r.authService.ResetTOTP(id); err != nil {
+			writeUserError(w, err)
+			return
+		}
+		writeJSON(w, map[string]bool{"success": true})
+		return
+	}
+
+	if unlock {
+		if req.Method != http.MethodDelete {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if err := r.authService.UnlockUser(id); err != nil {
+			writeUserError(w, err)
+			return
+		}
+		writeJSON(w, map[string]bool{"success": true})
+		return
+	}
+
+	switch req.Method {
+	case http.MethodGet:
+		user, err := r.authService.GetUser(id)
+		if err != nil {
+			writeUserError(w, err)
+			return
+		}
+		writeJSON(w, user)
+
+	case http.MethodPut, http.MethodPatch:
+		var data struct {
+			Username           *string `json:"username"`
+			Role               *string `json:"role"`
+			Password           *string `json:"password"`
+			MustChangePassword *bool   `json:"mustChangePassword"`
+		}
+		if err := json.NewDecoder(req.Body).Decode(&data); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+
+		user, err := r.authService.UpdateUser(id, auth.UserUpdate{
+			Username:           data.Username,
+			Role:               data.Role,
+			Password:           data.Password,
+			MustChangePassword: data.MustChangePassword,
+		})
+		if err != nil {
+			writeUserError(w, err)
+			return
+		}
+		var changed []string
+		for field, set := range map[string]bool{
+			"username":           data.Username != nil,
+			"role":               data.Role != nil,
+			"password":           data.Password != nil,
+			"mustChangePassword": data.MustChangePassword != nil,
+		} {
+			if set {
+				changed = append(changed, field)
+			}
+		}
+		sort.Strings(changed)
+		setAudit(req, "", "", fmt.Sprintf("%s (%s), set %s", user.Username, user.Role, strings.Join(changed, ", ")))
+		writeJSON(w, user)
+
+	case http.MethodDelete:
+		if id == userIDFromRequest(req) {
+			http.Error(w, "You cannot delete your own account", http.StatusBadRequest)
+			return
+		}
+		if user, err := r.authService.GetUser(id); err == nil {
+			setAudit(req, "", "", user.Username)
+		}
+		if err := r.authService.DeleteUser(id); err != nil {
+			writeUserError(w, err)
+			return
+		}
+		writeJSON(w, map[string]bool{"success": true})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// writeUserError maps user management errors to HTTP responses
+func writeUserError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, auth.ErrUserNotFound):
+		http.Error(w, "User not found", http.StatusNotFound)
+	case errors.Is(err, auth.ErrUsernameTaken), errors.Is(err, auth.ErrLastAdmin):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, auth.ErrUsernameRequired), errors.Is(err, auth.ErrPasswordRequired), errors.Is(err, auth.ErrInvalidRole),
+		errors.Is(err, auth.ErrPasswordTooShort), errors.Is(err, auth.ErrPasswordBreached), errors.Is(err, auth.ErrPasswordReused):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		log.Printf("User management failed: %v", err)
+		http.Error(w, "Failed to update users", http.StatusInternalServerError)
+	}
+}
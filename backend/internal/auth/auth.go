@@ -4,35 +4,69 @@ import (
 	"crypto/rand"
 	"database/sql"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"log"
 	"time"
 
+	"github.com/weaversgrainthorpe/HOPS/internal/config"
+	"github.com/weaversgrainthorpe/HOPS/internal/models"
 	"golang.org/x/crypto/bcrypt"
 )
 
 // Service handles authentication operations
 type Service struct {
-	db *sql.DB
+	db          *sql.DB
+	idleTimeout time.Duration // sessions unused for this long expire; 0 disables
+	maxAge      time.Duration // sessions expire this long after login regardless
+	policy      config.PasswordPolicy
+	lockout     config.LoginLockout
 }
 
-// NewService creates a new auth service
-func NewService(db *sql.DB) *Service {
-	return &Service{db: db}
+// NewService creates a new auth service with the session lifetimes and
+// password policy from cfg
+func NewService(db *sql.DB, cfg *config.Config) *Service {
+	return &Service{
+		db:          db,
+		idleTimeout: cfg.SessionIdleTimeout,
+		maxAge:      cfg.SessionMaxAge,
+		policy:      cfg.PasswordPolicy,
+		lockout:     cfg.LoginLockout,
+	}
+}
+
+// Client describes the device a session was created from
+type Client struct {
+	IP        string
+	UserAgent string
 }
 
-// Login authenticates a user and creates a session
-func (s *Service) Login(username, password string) (string, error) {
+// Login authenticates a user and creates a session. Users with two-factor
+// authentication enabled also need a code from their authenticator app or a
+// recovery code; ErrTOTPRequired is only returned once the password is right.
+// Too many failures lock the username out with a *LockoutError.
+func (s *Service) Login(username, password, code string, client Client) (string, error) {
+	if err := s.checkLockout(username); err != nil {
+		return "", err
+	}
+	fail := func(err error) (string, error) {
+		if recordErr := s.recordLoginFailure(username); recordErr != nil {
+			log.Printf("Failed to record failed login: %v", recordErr)
+		}
+		return "", err
+	}
+
 	var userID int
 	var passwordHash string
+	var totpEnabled bool
 
 	err := s.db.QueryRow(
-		"SELECT id, password_hash FROM users WHERE username = ?",
+		"SELECT id, password_hash, totp_enabled FROM users WHERE username = ?",
 		username,
-	).Scan(&userID, &passwordHash)
+	).Scan(&userID, &passwordHash, &totpEnabled)
 
 	if err == sql.ErrNoRows {
-		return "", fmt.Errorf("invalid credentials")
+		return fail(fmt.Errorf("invalid credentials"))
 	}
 	if err != nil {
 		return "", fmt.Errorf("database error: %w", err)
@@ -40,20 +74,39 @@ func (s *Service) Login(username, password string) (string, error) {
 
 	// Verify password
 	if err := bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte(password)); err != nil {
-		return "", fmt.Errorf("invalid credentials")
+		return fail(fmt.Errorf("invalid credentials"))
 	}
 
-	// Create session
+	if totpEnabled {
+		err := s.verifyTOTP(userID, code, true)
+		if errors.Is(err, ErrInvalidTOTP) {
+			return fail(err)
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+
+	if err := s.clearLoginFailures(username); err != nil {
+		log.Printf("%v", err)
+	}
+	return s.CreateSession(userID, client)
+}
+
+// CreateSession signs a user in without a password, for logins that were
+// verified elsewhere such as single sign-on
+func (s *Service) CreateSession(userID int, client Client) (string, error) {
 	sessionID, err := generateSessionID()
 	if err != nil {
 		return "", fmt.Errorf("failed to generate session: %w", err)
 	}
 
-	expiresAt := time.Now().Add(24 * time.Hour)
+	now := time.Now().UTC().Truncate(time.Second)
+	expiresAt := now.Add(s.maxAge)
 
 	_, err = s.db.Exec(
-		"INSERT INTO sessions (id, user_id, expires_at) VALUES (?, ?, ?)",
-		sessionID, userID, expiresAt,
+		"INSERT INTO sessions (id, user_id, expires_at, created_at, last_seen_at, ip, user_agent) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		sessionID, userID, expiresAt, now, now, client.IP, truncate(client.UserAgent, maxUserAgentLength),
 	)
 	if err != nil {
 		return "", fmt.Errorf("failed to create session: %w", err)
@@ -64,28 +117,53 @@ func (s *Service) Login(username, password string) (string, error) {
 
 // ValidateSession checks if a session is valid
 func (s *Service) ValidateSession(sessionID string) (int, error) {
-	var userID int
-	var expiresAt time.Time
+	user, err := s.SessionUser(sessionID)
+	if err != nil {
+		return 0, err
+	}
+	return user.ID, nil
+}
 
-	err := s.db.QueryRow(
-		"SELECT user_id, expires_at FROM sessions WHERE id = ?",
-		sessionID,
-	).Scan(&userID, &expiresAt)
+// SessionUser returns the user signed in with a valid session, and records
+// that the session was used
+func (s *Service) SessionUser(sessionID string) (*models.User, error) {
+	var user models.User
+	var expiresAt, createdAt time.Time
+	var lastSeenAt sql.NullTime
+
+	err := s.db.QueryRow(`
+		SELECT u.id, u.username, u.role, u.must_change_password, u.created_at, s.expires_at, s.created_at, s.last_seen_at
+		FROM sessions s
+		JOIN users u ON u.id = s.user_id
+		WHERE s.id = ?
+	`, sessionID).Scan(&user.ID, &user.Username, &user.Role, &user.MustChangePassword, &user.CreatedAt, &expiresAt, &createdAt, &lastSeenAt)
 
 	if err == sql.ErrNoRows {
-		return 0, fmt.Errorf("invalid session")
+		return nil, fmt.Errorf("invalid session")
 	}
 	if err != nil {
-		return 0, fmt.Errorf("database error: %w", err)
+		return nil, fmt.Errorf("database error: %w", err)
 	}
 
-	if time.Now().After(expiresAt) {
+	now := time.Now()
+	if !lastSeenAt.Valid {
+		lastSeenAt.Time = createdAt // sessions from before last_seen_at was recorded
+	}
+	if now.After(expiresAt) || (s.idleTimeout > 0 && now.Sub(lastSeenAt.Time) > s.idleTimeout) {
 		// Clean up expired session
 		s.db.Exec("DELETE FROM sessions WHERE id = ?", sessionID)
-		return 0, fmt.Errorf("session expired")
+		return nil, fmt.Errorf("session expired")
 	}
 
-	return userID, nil
+	interval := sessionLastSeenInterval
+	if s.idleTimeout > 0 && s.idleTimeout/2 < interval {
+		interval = s.idleTimeout / 2
+	}
+	if now.Sub(lastSeenAt.Time) > interval {
+		s.db.Exec("UPDATE sessions SET last_seen_at = ? WHERE id = ?", now.UTC().Truncate(time.Second), sessionID)
+	}
+
+	return &user, nil
 }
 
 // Logout removes a session
@@ -94,7 +172,8 @@ func (s *Service) Logout(sessionID string) error {
 	return err
 }
 
-// ChangePassword updates a user's password
+// ChangePassword updates a user's password, if the new one meets the password
+// policy, and signs them out everywhere
 func (s *Service) ChangePassword(userID int, oldPassword, newPassword string) error {
 	var currentHash string
 	err := s.db.QueryRow(
@@ -111,21 +190,30 @@ func (s *Service) ChangePassword(userID int, oldPassword, newPassword string) er
 		return fmt.Errorf("invalid current password")
 	}
 
+	if err := s.checkPassword(userID, newPassword); err != nil {
+		return err
+	}
+
 	// Hash new password
 	newHash, err := bcrypt.GenerateFromPassword([]byte(newPassword), bcrypt.DefaultCost)
 	if err != nil {
 		return fmt.Errorf("failed to hash password: %w", err)
 	}
 
-	_, err = s.db.Exec(
-		"UPDATE users SET password_hash = ? WHERE id = ?",
-		newHash, userID,
-	)
+	tx, err := s.db.Begin()
 	if err != nil {
-		return fmt.Errorf("failed to update password: %w", err)
+		return fmt.Errorf("database error: %w", err)
 	}
+	defer tx.Rollback()
 
-	return nil
+	if err := s.setPassword(tx, userID, newHash, false); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM sessions WHERE user_id = ?", userID); err != nil {
+		return fmt.Errorf("failed to revoke sessions: %w", err)
+	}
+
+	return tx.Commit()
 }
 
 // generateSessionID creates a random session ID
@@ -139,11 +227,17 @@ func generateSessionID() (string, error) {
 
 // CleanupExpiredSessions removes all expired sessions
 func (s *Service) CleanupExpiredSessions() error {
-	_, err := s.db.Exec("DELETE FROM sessions WHERE expires_at < ?", time.Now())
+	now := time.Now().UTC()
+	idleSince := time.Time{}
+	if s.idleTimeout > 0 {
+		idleSince = now.Add(-s.idleTimeout)
+	}
+	_, err := s.db.Exec("DELETE FROM sessions WHERE expires_at < ? OR last_seen_at < ?", now, idleSince)
 	return err
 }
 
-// StartCleanupRoutine starts a background goroutine that periodically cleans up expired sessions
+// StartCleanupRoutine starts a background goroutine that periodically cleans
+// up expired sessions and old failed logins
 func (s *Service) StartCleanupRoutine(interval time.Duration, stop <-chan struct{}) {
 	go func() {
 		ticker := time.NewTicker(interval)
@@ -154,6 +248,9 @@ func (s *Service) StartCleanupRoutine(interval time.Duration, stop <-chan struct
 			// Log error but don't fail
 			log.Printf("Session cleanup error: %v", err)
 		}
+		if err := s.cleanupLoginFailures(); err != nil {
+			log.Printf("Failed login cleanup error: %v", err)
+		}
 
 		for {
 			select {
@@ -161,6 +258,9 @@ func (s *Service) StartCleanupRoutine(interval time.Duration, stop <-chan struct
 				if err := s.CleanupExpiredSessions(); err != nil {
 					log.Printf("Session cleanup error: %v", err)
 				}
+				if err := s.cleanupLoginFailures(); err != nil {
+					log.Printf("Failed login cleanup error: %v", err)
+				}
 			case <-stop:
 				return
 			}
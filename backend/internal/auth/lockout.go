@@ -0,0 +1,145 @@
+package auth
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// ErrAccountLocked is returned by Login while a username is locked out after
+// too many failed logins
+var ErrAccountLocked = errors.New("too many failed logins")
+
+// loginFailureWindow is how long failed logins are remembered. A username's
+// count starts again once it has had no failures for this long.
+const loginFailureWindow = 24 * time.Hour
+
+// LockoutError is returned by Login while a username is locked out. It
+// matches ErrAccountLocked with errors.Is.
+type LockoutError struct {
+	Until time.Time
+}
+
+func (e *LockoutError) Error() string {
+	return fmt.Sprintf("%v; try again in %s", ErrAccountLocked, e.RetryAfter())
+}
+
+func (e *LockoutError) Unwrap() error {
+	return ErrAccountLocked
+}
+
+// RetryAfter returns how long until the lockout ends, rounded up to a second
+func (e *LockoutError) RetryAfter() time.Duration {
+	return time.Until(e.Until).Truncate(time.Second) + time.Second
+}
+
+// checkLockout returns a *LockoutError if username is locked out. Unknown
+// usernames are tracked too, so lockouts do not reveal which users exist.
+func (s *Service) checkLockout(username string) error {
+	var lockedUntil sql.NullTime
+	err := s.db.QueryRow("SELECT locked_until FROM login_failures WHERE username = ?", username).Scan(&lockedUntil)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("database error: %w", err)
+	}
+	if until := activeLockout(lockedUntil); until != nil {
+		return &LockoutError{Until: *until}
+	}
+	return nil
+}
+
+// recordLoginFailure counts a failed login for username. From the lockout
+// threshold on, each failure locks the username for twice as long as the
+// last, from the lockout delay up to the maximum.
+func (s *Service) recordLoginFailure(username string) error {
+	if s.lockout.Threshold <= 0 {
+		return nil
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("database error: %w", err)
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	var failures int
+	var lastFailure time.Time
+	err = tx.QueryRow(
+		"SELECT failures, last_failure_at FROM login_failures WHERE username = ?", username,
+	).Scan(&failures, &lastFailure)
+	if err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("database error: %w", err)
+	}
+	if err == sql.ErrNoRows || now.Sub(lastFailure) > loginFailureWindow {
+		failures = 0
+	}
+	failures++
+
+	var lockedUntil sql.NullTime
+	if failures >= s.lockout.Threshold {
+		delay := s.lockout.Delay
+		for i := s.lockout.Threshold; i < failures && delay < s.lockout.MaxDelay; i++ {
+			delay *= 2
+		}
+		delay = min(delay, s.lockout.MaxDelay)
+		lockedUntil = sql.NullTime{Time: now.Add(delay), Valid: true}
+		log.Printf("[Auth] %d failed logins for %q, locked for %s", failures, username, delay)
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO login_failures (username, failures, last_failure_at, locked_until) VALUES (?, ?, ?, ?)
+		ON CONFLICT(username) DO UPDATE SET
+			failures = excluded.failures,
+			last_failure_at = excluded.last_failure_at,
+			locked_until = excluded.locked_until
+	`, username, failures, now, lockedUntil)
+	if err != nil {
+		return fmt.Errorf("failed to record failed login: %w", err)
+	}
+	return tx.Commit()
+}
+
+// clearLoginFailures forgets username's failed logins
+func (s *Service) clearLoginFailures(username string) error {
+	if _, err := s.db.Exec("DELETE FROM login_failures WHERE username = ?", username); err != nil {
+		return fmt.Errorf("failed to clear failed logins: %w", err)
+	}
+	return nil
+}
+
+// UnlockUser ends a user's lockout and forgets their failed logins
+func (s *Service) UnlockUser(userID int) error {
+	var username string
+	err := s.db.QueryRow("SELECT username FROM users WHERE id = ?", userID).Scan(&username)
+	if err == sql.ErrNoRows {
+		return ErrUserNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("database error: %w", err)
+	}
+	return s.clearLoginFailures(username)
+}
+
+// activeLockout returns lockedUntil if it is still in the future, or nil
+func activeLockout(lockedUntil sql.NullTime) *time.Time {
+	if !lockedUntil.Valid || !time.Now().Before(lockedUntil.Time) {
+		return nil
+	}
+	return &lockedUntil.Time
+}
+
+// cleanupLoginFailures forgets failures older than loginFailureWindow whose
+// lockout has ended
+func (s *Service) cleanupLoginFailures() error {
+	now := time.Now().UTC()
+	_, err := s.db.Exec(
+		"DELETE FROM login_failures WHERE last_failure_at < ? AND (locked_until IS NULL OR locked_until < ?)",
+		now.Add(-loginFailureWindow), now,
+	)
+	return err
+}
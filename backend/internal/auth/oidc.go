@@ -0,0 +1,426 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/weaversgrainthorpe/HOPS/internal/config"
+)
+
+const (
+	// OIDCLoginTimeout is how long a user has to finish signing in at the provider
+	OIDCLoginTimeout = 10 * time.Minute
+	// oidcMaxPending caps the logins waiting for a callback
+	oidcMaxPending = 1000
+	// oidcClockSkew is the leeway allowed when checking ID token expiry
+	oidcClockSkew = time.Minute
+)
+
+// Errors returned by the OpenID Connect login flow
+var (
+	ErrOIDCState          = errors.New("sign-in expired or was already used, please try again")
+	ErrOIDCNoRole         = errors.New("your account is not allowed to use HOPS")
+	ErrOIDCNotProvisioned = errors.New("no HOPS user is linked to your account")
+	ErrOIDCUsernameTaken  = errors.New("your username is already used by another HOPS account")
+)
+
+// OIDCProvider signs users in with an OpenID Connect provider using the
+// authorization code flow with PKCE. The ID token is received directly from
+// the provider's token endpoint over TLS, so as allowed by OpenID Connect
+// Core 3.1.3.7 its claims are checked but its signature is not.
+type OIDCProvider struct {
+	cfg    config.OIDCConfig
+	client *http.Client
+
+	mu        sync.Mutex
+	discovery *oidcDiscovery
+	pending   map[string]oidcLogin // by state
+}
+
+// OIDCIdentity describes the user the provider signed in
+type OIDCIdentity struct {
+	Subject  string
+	Username string
+	Role     string // the mapped HOPS role, or config.OIDCConfig.DefaultRole
+}
+
+// oidcDiscovery holds the fields HOPS uses from the provider's metadata
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	UserinfoEndpoint      string `json:"userinfo_endpoint"`
+}
+
+// oidcLogin is a login waiting for the provider to redirect back
+type oidcLogin struct {
+	verifier string
+	nonce    string
+	redirect string
+	expires  time.Time
+}
+
+// NewOIDCProvider creates a provider client. The provider's metadata is
+// fetched on the first login, so HOPS starts even if the provider is down.
+func NewOIDCProvider(cfg config.OIDCConfig) *OIDCProvider {
+	return &OIDCProvider{
+		cfg:     cfg,
+		client:  &http.Client{Timeout: 10 * time.Second},
+		pending: make(map[string]oidcLogin),
+	}
+}
+
+// AuthCodeURL starts a login and returns the provider URL to send the browser
+// to, and the login's state. redirect is the HOPS path to return to once
+// signed in. The browser must keep the state, such as in a cookie, and hand it
+// to Exchange, so that only it can complete the login.
+func (p *OIDCProvider) AuthCodeURL(ctx context.Context, redirect string) (authURL, state string, err error) {
+	discovery, err := p.discover(ctx)
+	if err != nil {
+		return "", "", err
+	}
+
+	state, err = randomString(24)
+	if err != nil {
+		return "", "", err
+	}
+	nonce, err := randomString(24)
+	if err != nil {
+		return "", "", err
+	}
+	verifier, err := randomString(48)
+	if err != nil {
+		return "", "", err
+	}
+
+	p.mu.Lock()
+	now := time.Now()
+	for key, login := range p.pending {
+		if now.After(login.expires) {
+			delete(p.pending, key)
+		}
+	}
+	if len(p.pending) >= oidcMaxPending {
+		p.mu.Unlock()
+		return "", "", errors.New("too many sign-ins in progress")
+	}
+	p.pending[state] = oidcLogin{verifier: verifier, nonce: nonce, redirect: redirect, expires: now.Add(OIDCLoginTimeout)}
+	p.mu.Unlock()
+
+	challenge := sha256.Sum256([]byte(verifier))
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.cfg.ClientID},
+		"redirect_uri":          {p.cfg.RedirectURL},
+		"scope":                 {strings.Join(p.cfg.Scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+	separator := "?"
+	if strings.Contains(discovery.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return discovery.AuthorizationEndpoint + separator + query.Encode(), state, nil
+}
+
+// Exchange completes a login started by AuthCodeURL with the state and code
+// the provider returned. browserState is the state the browser kept when the
+// login started; a login is only completed in the browser that started it, so
+// that nobody can sign a victim in to their own account by sending them a
+// callback URL. It returns the signed-in identity and the HOPS path the login
+// should return to.
+func (p *OIDCProvider) Exchange(ctx context.Context, state, browserState, code string) (*OIDCIdentity, string, error) {
+	if browserState == "" || subtle.ConstantTimeCompare([]byte(state), []byte(browserState)) != 1 {
+		return nil, "", ErrOIDCState
+	}
+
+	p.mu.Lock()
+	login, ok := p.pending[state]
+	delete(p.pending, state)
+	p.mu.Unlock()
+	if !ok || time.Now().After(login.expires) {
+		return nil, "", ErrOIDCState
+	}
+
+	discovery, err := p.discover(ctx)
+	if err != nil {
+		return nil, login.redirect, err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.cfg.RedirectURL},
+		"code_verifier": {login.verifier},
+	}
+	if p.cfg.ClientSecret == "" {
+		form.Set("client_id", p.cfg.ClientID)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, discovery.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, login.redirect, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.cfg.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.cfg.ClientID), url.QueryEscape(p.cfg.ClientSecret))
+	}
+
+	var tokens struct {
+		AccessToken      string `json:"access_token"`
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := p.doJSON(req, &tokens); err != nil && tokens.Error == "" {
+		return nil, login.redirect, fmt.Errorf("token request failed: %w", err)
+	}
+	if tokens.Error != "" {
+		return nil, login.redirect, fmt.Errorf("token request failed: %s %s", tokens.Error, tokens.ErrorDescription)
+	}
+
+	claims, err := p.idTokenClaims(tokens.IDToken, discovery.Issuer, login.nonce)
+	if err != nil {
+		return nil, login.redirect, err
+	}
+	if discovery.UserinfoEndpoint != "" && tokens.AccessToken != "" {
+		if err := p.mergeUserinfo(ctx, discovery.UserinfoEndpoint, tokens.AccessToken, claims); err != nil {
+			return nil, login.redirect, err
+		}
+	}
+
+	identity := &OIDCIdentity{Subject: claims["sub"].(string)}
+	identity.Username, _ = claims[p.cfg.UsernameClaim].(string)
+	if identity.Username == "" {
+		identity.Username = identity.Subject
+	}
+	identity.Role = p.role(claims)
+	if identity.Role == "" {
+		return nil, login.redirect, ErrOIDCNoRole
+	}
+	return identity, login.redirect, nil
+}
+
+// OIDCLogin signs in the user linked to an OpenID Connect identity and
+// returns a new session ID. Unknown identities get a user without a password
+// if the provider auto-provisions them; otherwise they are rejected. If the
+// provider maps roles, the user's role is updated from the identity.
+func (s *Service) OIDCLogin(provider *OIDCProvider, identity *OIDCIdentity, client Client) (string, error) {
+	var userID int
+	var role string
+	err := s.db.QueryRow("SELECT id, role FROM users WHERE oidc_subject = ?", identity.Subject).Scan(&userID, &role)
+
+	switch {
+	case err == sql.ErrNoRows:
+		if !provider.AutoProvision() {
+			return "", ErrOIDCNotProvisioned
+		}
+		subject := identity.Subject
+		user, err := s.createExternalUser(identity.Username, identity.Role, &subject)
+		if errors.Is(err, ErrUsernameTaken) {
+			return "", ErrOIDCUsernameTaken
+		}
+		if err != nil {
+			return "", err
+		}
+		userID = user.ID
+		log.Printf("[OIDC] Created user %q with role %s", identity.Username, identity.Role)
+
+	case err != nil:
+		return "", fmt.Errorf("database error: %w", err)
+
+	case provider.SyncsRoles():
+		if err := s.syncRole(userID, identity.Username, role, identity.Role); err != nil {
+			return "", err
+		}
+	}
+
+	return s.CreateSession(userID, client)
+}
+
+// SyncsRoles reports whether users' roles are updated from their claims on
+// every login, which is the case when a role mapping is configured
+func (p *OIDCProvider) SyncsRoles() bool {
+	return len(p.cfg.RoleMap) > 0
+}
+
+// AutoProvision reports whether users are created on their first login
+func (p *OIDCProvider) AutoProvision() bool {
+	return p.cfg.AutoProvision
+}
+
+// discover fetches and caches the provider's metadata
+func (p *OIDCProvider) discover(ctx context.Context) (*oidcDiscovery, error) {
+	p.mu.Lock()
+	discovery := p.discovery
+	p.mu.Unlock()
+	if discovery != nil {
+		return discovery, nil
+	}
+
+	issuer := strings.TrimSuffix(p.cfg.Issuer, "/")
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, issuer+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, err
+	}
+	discovery = &oidcDiscovery{}
+	if err := p.doJSON(req, discovery); err != nil {
+		return nil, fmt.Errorf("failed to fetch provider metadata: %w", err)
+	}
+	if strings.TrimSuffix(discovery.Issuer, "/") != issuer {
+		return nil, fmt.Errorf("provider metadata is for issuer %q, expected %q", discovery.Issuer, p.cfg.Issuer)
+	}
+	if discovery.AuthorizationEndpoint == "" || discovery.TokenEndpoint == "" {
+		return nil, errors.New("provider metadata has no authorization or token endpoint")
+	}
+
+	p.mu.Lock()
+	p.discovery = discovery
+	p.mu.Unlock()
+	return discovery, nil
+}
+
+// idTokenClaims decodes an ID token and checks its issuer, audience, expiry
+// and nonce
+func (p *OIDCProvider) idTokenClaims(idToken, issuer, nonce string) (map[string]interface{}, error) {
+	parts := strings.Split(idToken, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("token response has no valid ID token")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return nil, errors.New("token response has no valid ID token")
+	}
+	var claims map[string]interface{}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, errors.New("token response has no valid ID token")
+	}
+
+	if iss, _ := claims["iss"].(string); iss != issuer {
+		return nil, fmt.Errorf("ID token issuer %q does not match %q", iss, issuer)
+	}
+	audiences := claimValues(claims["aud"])
+	if !containsString(audiences, p.cfg.ClientID) {
+		return nil, errors.New("ID token was not issued to this client")
+	}
+	if azp, ok := claims["azp"].(string); ok && azp != p.cfg.ClientID {
+		return nil, errors.New("ID token was not issued to this client")
+	}
+	if exp, ok := claims["exp"].(float64); !ok || time.Now().Add(-oidcClockSkew).After(time.Unix(int64(exp), 0)) {
+		return nil, errors.New("ID token has expired")
+	}
+	if claimNonce, _ := claims["nonce"].(string); claimNonce != nonce {
+		return nil, errors.New("ID token nonce does not match")
+	}
+	if sub, _ := claims["sub"].(string); sub == "" {
+		return nil, errors.New("ID token has no subject")
+	}
+	return claims, nil
+}
+
+// mergeUserinfo adds claims from the userinfo endpoint that the ID token
+// does not have, as some providers only return groups there
+func (p *OIDCProvider) mergeUserinfo(ctx context.Context, endpoint, accessToken string, claims map[string]interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	req.Header.Set("Accept", "application/json")
+
+	var userinfo map[string]interface{}
+	if err := p.doJSON(req, &userinfo); err != nil {
+		return fmt.Errorf("userinfo request failed: %w", err)
+	}
+	if sub, _ := userinfo["sub"].(string); sub != claims["sub"] {
+		return errors.New("userinfo subject does not match the ID token")
+	}
+	for key, value := range userinfo {
+		if _, ok := claims[key]; !ok {
+			claims[key] = value
+		}
+	}
+	return nil
+}
+
+// role returns the HOPS role the claims map to
+func (p *OIDCProvider) role(claims map[string]interface{}) string {
+	var values []string
+	if p.cfg.RoleClaim != "" {
+		values = claimValues(claims[p.cfg.RoleClaim])
+	}
+	return mapRole(values, p.cfg.RoleMap, p.cfg.DefaultRole)
+}
+
+// doJSON sends req and decodes the JSON response into v. The body is decoded
+// even for error statuses, as OAuth errors are reported in it.
+func (p *OIDCProvider) doJSON(req *http.Request, v interface{}) error {
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	decodeErr := json.Unmarshal(body, v)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %s", req.URL.Host, resp.Status)
+	}
+	return decodeErr
+}
+
+// claimValues returns a claim that may be a string or an array of strings as
+// a list
+func claimValues(claim interface{}) []string {
+	switch claim := claim.(type) {
+	case string:
+		return []string{claim}
+	case []interface{}:
+		values := make([]string, 0, len(claim))
+		for _, item := range claim {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+// containsString reports whether list contains s
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// randomString returns n random bytes encoded as URL-safe base64
+func randomString(n int) (string, error) {
+	bytes := make([]byte, n)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(bytes), nil
+}
@@ -0,0 +1,371 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/weaversgrainthorpe/HOPS/internal/config"
+	"github.com/weaversgrainthorpe/HOPS/internal/database"
+)
+
+// fakeOIDCServer is an OpenID Connect provider serving discovery, token and
+// userinfo endpoints. The token endpoint checks the PKCE verifier against the
+// challenge of the last login and returns an ID token built by claims.
+type fakeOIDCServer struct {
+	*httptest.Server
+	t         *testing.T
+	challenge string
+	nonce     string
+	claims    func(nonce string) map[string]interface{}
+	userinfo  map[string]interface{}
+	tokenForm url.Values
+}
+
+func newFakeOIDCServer(t *testing.T) *fakeOIDCServer {
+	f := &fakeOIDCServer{t: t}
+	f.claims = f.validClaims
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		metadata := map[string]string{
+			"issuer":                 f.URL,
+			"authorization_endpoint": f.URL + "/authorize",
+			"token_endpoint":         f.URL + "/token",
+		}
+		if f.userinfo != nil {
+			metadata["userinfo_endpoint"] = f.URL + "/userinfo"
+		}
+		json.NewEncoder(w).Encode(metadata)
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Errorf("token request: %v", err)
+		}
+		f.tokenForm = r.PostForm
+		verifier := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+		if base64.RawURLEncoding.EncodeToString(verifier[:]) != f.challenge {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant", "error_description": "PKCE verification failed"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{
+			"access_token": "access",
+			"id_token":     fakeIDToken(f.claims(f.nonce)),
+		})
+	})
+	mux.HandleFunc("/userinfo", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer access" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(f.userinfo)
+	})
+	f.Server = httptest.NewServer(mux)
+	t.Cleanup(f.Close)
+	return f
+}
+
+// validClaims returns the claims of an ID token that should be accepted
+func (f *fakeOIDCServer) validClaims(nonce string) map[string]interface{} {
+	return map[string]interface{}{
+		"iss":                f.URL,
+		"sub":                "subject-1",
+		"aud":                []string{"hops", "other"},
+		"exp":                time.Now().Add(time.Hour).Unix(),
+		"nonce":              nonce,
+		"preferred_username": "alice",
+		"groups":             []string{"staff"},
+	}
+}
+
+// provider returns a provider client for the fake server
+func (f *fakeOIDCServer) provider(cfg config.OIDCConfig) *OIDCProvider {
+	cfg.Issuer = f.URL
+	cfg.ClientID = "hops"
+	cfg.RedirectURL = "https://hops.example.com/api/auth/oidc/callback"
+	cfg.Scopes = []string{"openid", "profile"}
+	if cfg.UsernameClaim == "" {
+		cfg.UsernameClaim = "preferred_username"
+	}
+	return NewOIDCProvider(cfg)
+}
+
+// login runs a login through the provider, as the browser would
+func (f *fakeOIDCServer) login(p *OIDCProvider) (*OIDCIdentity, string, error) {
+	f.t.Helper()
+	authURL, state, err := p.AuthCodeURL(context.Background(), "/dashboard")
+	if err != nil {
+		f.t.Fatalf("AuthCodeURL: %v", err)
+	}
+	u, err := url.Parse(authURL)
+	if err != nil {
+		f.t.Fatalf("AuthCodeURL returned %q: %v", authURL, err)
+	}
+	query := u.Query()
+	if u.Path != "/authorize" || query.Get("code_challenge_method") != "S256" {
+		f.t.Fatalf("AuthCodeURL returned %q", authURL)
+	}
+	f.challenge, f.nonce = query.Get("code_challenge"), query.Get("nonce")
+	if query.Get("state") != state {
+		f.t.Fatalf("AuthCodeURL returned state %q, want %q", state, query.Get("state"))
+	}
+	return p.Exchange(context.Background(), query.Get("state"), state, "code")
+}
+
+// fakeIDToken encodes claims as a JWT. The signature is not checked, as the
+// token comes straight from the token endpoint.
+func fakeIDToken(claims map[string]interface{}) string {
+	payload, _ := json.Marshal(claims)
+	encode := base64.RawURLEncoding.EncodeToString
+	return encode([]byte(`{"alg":"RS256"}`)) + "." + encode(payload) + "." + encode([]byte("signature"))
+}
+
+func TestOIDCExchange(t *testing.T) {
+	f := newFakeOIDCServer(t)
+	p := f.provider(config.OIDCConfig{DefaultRole: RoleViewer})
+
+	identity, redirect, err := f.login(p)
+	if err != nil {
+		t.Fatalf("Exchange: %v", err)
+	}
+	if redirect != "/dashboard" {
+		t.Errorf("redirect = %q, want /dashboard", redirect)
+	}
+	want := OIDCIdentity{Subject: "subject-1", Username: "alice", Role: RoleViewer}
+	if *identity != want {
+		t.Errorf("identity = %+v, want %+v", *identity, want)
+	}
+	if got := f.tokenForm.Get("client_id"); got != "hops" {
+		t.Errorf("public client sent client_id %q", got)
+	}
+	if got := f.tokenForm.Get("redirect_uri"); got != "https://hops.example.com/api/auth/oidc/callback" {
+		t.Errorf("token request redirect_uri = %q", got)
+	}
+
+	// Each state can only be used once
+	if _, _, err := p.Exchange(context.Background(), "unknown", "unknown", "code"); !errors.Is(err, ErrOIDCState) {
+		t.Errorf("Exchange with an unknown state returned %v, want ErrOIDCState", err)
+	}
+}
+
+func TestOIDCExchangeRequiresBrowserState(t *testing.T) {
+	f := newFakeOIDCServer(t)
+	p := f.provider(config.OIDCConfig{DefaultRole: RoleViewer})
+
+	authURL, state, err := p.AuthCodeURL(context.Background(), "/")
+	if err != nil {
+		t.Fatalf("AuthCodeURL: %v", err)
+	}
+	_, other, err := p.AuthCodeURL(context.Background(), "/")
+	if err != nil {
+		t.Fatalf("AuthCodeURL: %v", err)
+	}
+
+	// A callback from a browser without the state cookie, or with the cookie
+	// of another login, is rejected
+	for _, browserState := range []string{"", other} {
+		if _, _, err := p.Exchange(context.Background(), state, browserState, "code"); !errors.Is(err, ErrOIDCState) {
+			t.Errorf("Exchange with browser state %q returned %v, want ErrOIDCState", browserState, err)
+		}
+	}
+
+	// The rejected callbacks do not use up the login
+	u, _ := url.Parse(authURL)
+	f.challenge, f.nonce = u.Query().Get("code_challenge"), u.Query().Get("nonce")
+	if _, _, err := p.Exchange(context.Background(), state, state, "code"); err != nil {
+		t.Errorf("Exchange from the browser that started the login: %v", err)
+	}
+}
+
+func TestOIDCExchangeRejectsClaims(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(claims map[string]interface{})
+		want   string
+	}{
+		{"issuer", func(c map[string]interface{}) { c["iss"] = "https://evil.example.com" }, "issuer"},
+		{"audience", func(c map[string]interface{}) { c["aud"] = "other" }, "not issued to this client"},
+		{"authorized party", func(c map[string]interface{}) { c["azp"] = "other" }, "not issued to this client"},
+		{"expired", func(c map[string]interface{}) { c["exp"] = time.Now().Add(-2 * oidcClockSkew).Unix() }, "expired"},
+		{"no expiry", func(c map[string]interface{}) { delete(c, "exp") }, "expired"},
+		{"nonce", func(c map[string]interface{}) { c["nonce"] = "replayed" }, "nonce"},
+		{"no subject", func(c map[string]interface{}) { delete(c, "sub") }, "subject"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeOIDCServer(t)
+			f.claims = func(nonce string) map[string]interface{} {
+				claims := f.validClaims(nonce)
+				tt.change(claims)
+				return claims
+			}
+			_, _, err := f.login(f.provider(config.OIDCConfig{DefaultRole: RoleViewer}))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Exchange returned %v, want an error about %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestOIDCExchangeAllowsClockSkew(t *testing.T) {
+	f := newFakeOIDCServer(t)
+	f.claims = func(nonce string) map[string]interface{} {
+		claims := f.validClaims(nonce)
+		claims["exp"] = time.Now().Add(-oidcClockSkew / 2).Unix()
+		return claims
+	}
+	if _, _, err := f.login(f.provider(config.OIDCConfig{DefaultRole: RoleViewer})); err != nil {
+		t.Errorf("Exchange: %v", err)
+	}
+}
+
+func TestOIDCExchangePKCE(t *testing.T) {
+	f := newFakeOIDCServer(t)
+	p := f.provider(config.OIDCConfig{DefaultRole: RoleViewer, ClientSecret: "secret"})
+
+	authURL, state, err := p.AuthCodeURL(context.Background(), "/")
+	if err != nil {
+		t.Fatalf("AuthCodeURL: %v", err)
+	}
+	u, _ := url.Parse(authURL)
+	f.nonce = u.Query().Get("nonce")
+	// The token endpoint gets a verifier that does not match this challenge
+	f.challenge = "not-the-challenge"
+	_, _, err = p.Exchange(context.Background(), u.Query().Get("state"), state, "code")
+	if err == nil || !strings.Contains(err.Error(), "PKCE verification failed") {
+		t.Errorf("Exchange returned %v, want the provider's PKCE error", err)
+	}
+	if f.tokenForm.Get("client_id") != "" {
+		t.Errorf("confidential client sent client_id in the form")
+	}
+}
+
+func TestOIDCRoleMapping(t *testing.T) {
+	roleMap := map[string]string{"staff": RoleEditor, "ops": RoleAdmin}
+	tests := []struct {
+		name        string
+		groups      interface{}
+		defaultRole string
+		userinfo    bool // groups are only returned by the userinfo endpoint
+		want        string
+		wantErr     error
+	}{
+		{"highest role wins", []string{"staff", "ops"}, "", false, RoleAdmin, nil},
+		{"single string claim", "staff", "", false, RoleEditor, nil},
+		{"from userinfo", []string{"ops"}, "", true, RoleAdmin, nil},
+		{"unmapped uses default", []string{"guests"}, RoleViewer, false, RoleViewer, nil},
+		{"unmapped without default", []string{"guests"}, "", false, "", ErrOIDCNoRole},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeOIDCServer(t)
+			f.claims = func(nonce string) map[string]interface{} {
+				claims := f.validClaims(nonce)
+				claims["groups"] = tt.groups
+				if tt.userinfo {
+					delete(claims, "groups")
+				}
+				return claims
+			}
+			if tt.userinfo {
+				f.userinfo = map[string]interface{}{"sub": "subject-1", "groups": tt.groups}
+			}
+			p := f.provider(config.OIDCConfig{RoleClaim: "groups", RoleMap: roleMap, DefaultRole: tt.defaultRole})
+
+			identity, _, err := f.login(p)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Exchange returned %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && identity.Role != tt.want {
+				t.Errorf("role = %q, want %q", identity.Role, tt.want)
+			}
+		})
+	}
+}
+
+func TestOIDCUserinfoSubjectMismatch(t *testing.T) {
+	f := newFakeOIDCServer(t)
+	f.userinfo = map[string]interface{}{"sub": "someone-else", "groups": []string{"ops"}}
+	_, _, err := f.login(f.provider(config.OIDCConfig{DefaultRole: RoleViewer}))
+	if err == nil || !strings.Contains(err.Error(), "userinfo subject") {
+		t.Errorf("Exchange returned %v, want a subject mismatch error", err)
+	}
+}
+
+// newTestService returns a Service using a fresh database
+func newTestService(t *testing.T) *Service {
+	t.Helper()
+	db, err := database.Initialize(filepath.Join(t.TempDir(), "hops.db"))
+	if err != nil {
+		t.Fatalf("database.Initialize: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return NewService(db, &config.Config{SessionMaxAge: time.Hour})
+}
+
+func TestOIDCLogin(t *testing.T) {
+	s := newTestService(t)
+	identity := &OIDCIdentity{Subject: "subject-1", Username: "alice", Role: RoleEditor}
+	client := Client{IP: "192.0.2.1", UserAgent: "test"}
+
+	manual := NewOIDCProvider(config.OIDCConfig{})
+	if _, err := s.OIDCLogin(manual, identity, client); !errors.Is(err, ErrOIDCNotProvisioned) {
+		t.Fatalf("OIDCLogin without auto-provisioning returned %v, want ErrOIDCNotProvisioned", err)
+	}
+
+	provisioning := NewOIDCProvider(config.OIDCConfig{AutoProvision: true, RoleMap: map[string]string{"ops": RoleAdmin}})
+	sessionID, err := s.OIDCLogin(provisioning, identity, client)
+	if err != nil {
+		t.Fatalf("OIDCLogin: %v", err)
+	}
+	user, err := s.SessionUser(sessionID)
+	if err != nil {
+		t.Fatalf("SessionUser: %v", err)
+	}
+	if user.Username != "alice" || user.Role != RoleEditor {
+		t.Errorf("provisioned user = %q with role %q, want alice with role editor", user.Username, user.Role)
+	}
+
+	// Later logins find the user by subject, even once renamed, and sync the role
+	newName := "alice2"
+	if _, err := s.UpdateUser(user.ID, UserUpdate{Username: &newName}); err != nil {
+		t.Fatalf("UpdateUser: %v", err)
+	}
+	identity.Role = RoleViewer
+	sessionID, err = s.OIDCLogin(provisioning, identity, client)
+	if err != nil {
+		t.Fatalf("second OIDCLogin: %v", err)
+	}
+	user, err = s.SessionUser(sessionID)
+	if err != nil {
+		t.Fatalf("SessionUser: %v", err)
+	}
+	if user.Username != newName || user.Role != RoleViewer {
+		t.Errorf("user after second login = %q with role %q, want %s with role viewer", user.Username, user.Role, newName)
+	}
+
+	// Without a role map, roles set in HOPS are kept
+	identity.Role = RoleAdmin
+	if _, err := s.OIDCLogin(NewOIDCProvider(config.OIDCConfig{}), identity, client); err != nil {
+		t.Fatalf("OIDCLogin: %v", err)
+	}
+	if user, _ = s.GetUser(user.ID); user.Role != RoleViewer {
+		t.Errorf("role without a role map = %q, want viewer", user.Role)
+	}
+
+	// A new identity may not take over an existing username
+	other := &OIDCIdentity{Subject: "subject-2", Username: "admin", Role: RoleViewer}
+	if _, err := s.OIDCLogin(provisioning, other, client); !errors.Is(err, ErrOIDCUsernameTaken) {
+		t.Errorf("OIDCLogin with a taken username returned %v, want ErrOIDCUsernameTaken", err)
+	}
+}
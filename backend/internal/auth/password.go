@@ -0,0 +1,121 @@
+package auth
+
+import (
+	"bufio"
+	"database/sql"
+	_ "embed"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Errors returned when a new password breaks the password policy
+var (
+	ErrPasswordTooShort = errors.New("password is too short")
+	ErrPasswordBreached = errors.New("password is too common; it appears in lists of breached passwords")
+	ErrPasswordReused   = errors.New("password was used recently; choose a new one")
+)
+
+//go:embed common_passwords.txt
+var commonPasswordList string
+
+// commonPasswords is the bundled list of breached passwords, lowercased
+var commonPasswords = parsePasswordList(commonPasswordList)
+
+// parsePasswordList reads one password per line, skipping blank lines and
+// # comments
+func parsePasswordList(list string) map[string]bool {
+	passwords := make(map[string]bool)
+	scanner := bufio.NewScanner(strings.NewReader(list))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" && !strings.HasPrefix(line, "#") {
+			passwords[strings.ToLower(line)] = true
+		}
+	}
+	return passwords
+}
+
+// checkPassword reports whether password may be set as the new password of
+// a user, or of a new user if userID is 0
+func (s *Service) checkPassword(userID int, password string) error {
+	if password == "" {
+		return ErrPasswordRequired
+	}
+	if utf8.RuneCountInString(password) < s.policy.MinLength {
+		return fmt.Errorf("%w: use at least %d characters", ErrPasswordTooShort, s.policy.MinLength)
+	}
+	if s.policy.CheckBreached && commonPasswords[strings.ToLower(password)] {
+		return ErrPasswordBreached
+	}
+	if userID == 0 || s.policy.History == 0 {
+		return nil
+	}
+
+	rows, err := s.db.Query(`
+		SELECT password_hash FROM users WHERE id = ?
+		UNION ALL
+		SELECT password_hash FROM (
+			SELECT password_hash FROM password_history WHERE user_id = ? ORDER BY id DESC LIMIT ?
+		)
+	`, userID, userID, s.policy.History)
+	if err != nil {
+		return fmt.Errorf("database error: %w", err)
+	}
+	var hashes []string
+	for rows.Next() {
+		var hash string
+		if err := rows.Scan(&hash); err != nil {
+			rows.Close()
+			return fmt.Errorf("database error: %w", err)
+		}
+		hashes = append(hashes, hash)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("database error: %w", err)
+	}
+
+	for _, hash := range hashes {
+		if bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil {
+			return ErrPasswordReused
+		}
+	}
+	return nil
+}
+
+// setPassword replaces a user's password hash inside tx, keeping the old hash
+// in the password history and clearing must_change_password unless the admin
+// setting it asks for another change
+func (s *Service) setPassword(tx *sql.Tx, userID int, hash []byte, mustChange bool) error {
+	if s.policy.History > 0 {
+		_, err := tx.Exec(
+			"INSERT INTO password_history (user_id, password_hash) SELECT id, password_hash FROM users WHERE id = ?",
+			userID,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to record password history: %w", err)
+		}
+	}
+	// Keep only as much history as the policy checks
+	_, err := tx.Exec(`
+		DELETE FROM password_history WHERE user_id = ? AND id NOT IN (
+			SELECT id FROM password_history WHERE user_id = ? ORDER BY id DESC LIMIT ?
+		)
+	`, userID, userID, s.policy.History)
+	if err != nil {
+		return fmt.Errorf("failed to trim password history: %w", err)
+	}
+
+	_, err = tx.Exec(
+		"UPDATE users SET password_hash = ?, must_change_password = ? WHERE id = ?",
+		hash, mustChange, userID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update password: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+package auth
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"github.com/weaversgrainthorpe/HOPS/internal/config"
+	"github.com/weaversgrainthorpe/HOPS/internal/models"
+)
+
+// Errors returned by reverse proxy authentication
+var (
+	ErrProxyNoRole         = errors.New("user is not allowed to use HOPS")
+	ErrProxyNotProvisioned = errors.New("no HOPS user exists for the proxy's user")
+	ErrProxyUsernameTaken  = errors.New("the proxy's username is used by a local HOPS account")
+)
+
+// ProxyAuth reads the identity a trusted reverse proxy asserts in request
+// headers. Headers from any other client are ignored, as they could be forged.
+type ProxyAuth struct {
+	cfg     config.ProxyAuthConfig
+	trusted []netip.Prefix
+}
+
+// NewProxyAuth creates a reverse proxy authenticator that trusts requests
+// whose remote address is in one of the trusted prefixes
+func NewProxyAuth(cfg config.ProxyAuthConfig, trusted []netip.Prefix) *ProxyAuth {
+	return &ProxyAuth{cfg: cfg, trusted: trusted}
+}
+
+// Identity returns the username and HOPS role asserted by the request's
+// headers. ok is false if the request did not come from a trusted proxy or
+// has no user header; role is empty if the user is not allowed in.
+func (p *ProxyAuth) Identity(req *http.Request) (username, role string, ok bool) {
+	username = strings.TrimSpace(req.Header.Get(p.cfg.UserHeader))
+	if username == "" || !IsTrustedProxy(req.RemoteAddr, p.trusted) {
+		return "", "", false
+	}
+
+	var groups []string
+	if p.cfg.GroupsHeader != "" {
+		for _, group := range strings.Split(req.Header.Get(p.cfg.GroupsHeader), ",") {
+			if group = strings.TrimSpace(group); group != "" {
+				groups = append(groups, group)
+			}
+		}
+	}
+	return username, mapRole(groups, p.cfg.RoleMap, p.cfg.DefaultRole), true
+}
+
+// ProxyUser returns the HOPS user with the username a trusted proxy asserted,
+// creating it if the proxy auto-provisions users. If a role map is configured
+// the user's role is updated from their groups, and users none of whose
+// groups map to a role are refused. Local accounts, which have a password,
+// are never matched, so that the proxy cannot sign in as them with their
+// stored role, such as the initial admin.
+func (s *Service) ProxyUser(proxy *ProxyAuth, username, role string) (*models.User, error) {
+	var user models.User
+	var passwordHash string
+	err := s.db.QueryRow(
+		"SELECT id, username, role, must_change_password, created_at, password_hash FROM users WHERE username = ?",
+		username,
+	).Scan(&user.ID, &user.Username, &user.Role, &user.MustChangePassword, &user.CreatedAt, &passwordHash)
+
+	switch {
+	case err == sql.ErrNoRows:
+		if !proxy.cfg.AutoProvision {
+			return nil, ErrProxyNotProvisioned
+		}
+		if role == "" {
+			return nil, ErrProxyNoRole
+		}
+		created, err := s.createExternalUser(username, role, nil)
+		if errors.Is(err, ErrUsernameTaken) {
+			// Created by a concurrent request
+			return s.ProxyUser(proxy, username, role)
+		}
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("[Auth] Created user %q with role %s for the reverse proxy", username, role)
+		return created, nil
+
+	case err != nil:
+		return nil, fmt.Errorf("database error: %w", err)
+
+	case passwordHash != "":
+		return nil, ErrProxyUsernameTaken
+
+	case len(proxy.cfg.RoleMap) > 0 && role == "":
+		return nil, ErrProxyNoRole
+
+	case len(proxy.cfg.RoleMap) > 0 && user.Role != role:
+		if err := s.syncRole(user.ID, username, user.Role, role); err != nil {
+			return nil, err
+		}
+		return s.GetUser(user.ID)
+	}
+	return &user, nil
+}
+
+// IsTrustedProxy reports whether remoteAddr, in the host:port form of
+// http.Request.RemoteAddr, is within one of the trusted prefixes
+func IsTrustedProxy(remoteAddr string, trusted []netip.Prefix) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range trusted {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,121 @@
+package auth
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/weaversgrainthorpe/HOPS/internal/models"
+)
+
+// ErrSessionNotFound is returned when revoking a session that does not exist
+// or belongs to another user
+var ErrSessionNotFound = errors.New("session not found")
+
+const (
+	// sessionLastSeenInterval limits how often a session's last-seen time is written
+	sessionLastSeenInterval = time.Minute
+
+	maxUserAgentLength = 256
+)
+
+// ListSessions returns a user's sessions, most recently used first.
+// currentID is the session ID of the request, which is marked as current.
+func (s *Service) ListSessions(userID int, currentID string) ([]models.Session, error) {
+	rows, err := s.db.Query(`
+		SELECT id, user_id, ip, user_agent, created_at, last_seen_at, expires_at
+		FROM sessions WHERE user_id = ?
+		ORDER BY COALESCE(last_seen_at, created_at) DESC
+	`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+	defer rows.Close()
+
+	now := time.Now()
+	sessions := []models.Session{}
+	for rows.Next() {
+		var id string
+		var session models.Session
+		var lastSeenAt sql.NullTime
+		err := rows.Scan(&id, &session.UserID, &session.IP, &session.UserAgent,
+			&session.CreatedAt, &lastSeenAt, &session.ExpiresAt)
+		if err != nil {
+			return nil, fmt.Errorf("database error: %w", err)
+		}
+
+		session.LastSeenAt = session.CreatedAt
+		if lastSeenAt.Valid {
+			session.LastSeenAt = lastSeenAt.Time
+		}
+		if s.idleTimeout > 0 && session.LastSeenAt.Add(s.idleTimeout).Before(session.ExpiresAt) {
+			session.ExpiresAt = session.LastSeenAt.Add(s.idleTimeout)
+		}
+		if now.After(session.ExpiresAt) {
+			continue
+		}
+
+		session.ID = sessionHandle(id)
+		session.Current = id == currentID
+		sessions = append(sessions, session)
+	}
+	return sessions, rows.Err()
+}
+
+// RevokeSession signs out one of a user's sessions, identified by the ID
+// ListSessions returned for it
+func (s *Service) RevokeSession(userID int, handle string) error {
+	rows, err := s.db.Query("SELECT id FROM sessions WHERE user_id = ?", userID)
+	if err != nil {
+		return fmt.Errorf("database error: %w", err)
+	}
+	var sessionID string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return fmt.Errorf("database error: %w", err)
+		}
+		if sessionHandle(id) == handle {
+			sessionID = id
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("database error: %w", err)
+	}
+	if sessionID == "" {
+		return ErrSessionNotFound
+	}
+
+	if _, err := s.db.Exec("DELETE FROM sessions WHERE id = ?", sessionID); err != nil {
+		return fmt.Errorf("failed to revoke session: %w", err)
+	}
+	return nil
+}
+
+// RevokeOtherSessions signs a user out everywhere except the session keepID,
+// and returns how many sessions were revoked
+func (s *Service) RevokeOtherSessions(userID int, keepID string) (int, error) {
+	result, err := s.db.Exec("DELETE FROM sessions WHERE user_id = ? AND id != ?", userID, keepID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to revoke sessions: %w", err)
+	}
+	n, _ := result.RowsAffected()
+	return int(n), nil
+}
+
+// sessionHandle returns the ID that identifies a session in the API. Session
+// IDs are credentials, so they are never listed themselves.
+func sessionHandle(sessionID string) string {
+	return hashToken(sessionID)[:16]
+}
+
+// truncate shortens s to at most n bytes
+func truncate(s string, n int) string {
+	if len(s) > n {
+		return s[:n]
+	}
+	return s
+}
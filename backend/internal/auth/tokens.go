@@ -0,0 +1,216 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/weaversgrainthorpe/HOPS/internal/models"
+)
+
+// TokenPrefix starts every API token, which tells them apart from session IDs
+const TokenPrefix = "hops_"
+
+// API token scopes. A token can only do what both its scopes and its owner's
+// role allow.
+const (
+	ScopeRead        = "read"         // read the config, dashboards, icons and backgrounds
+	ScopeConfigWrite = "config-write" // also change them
+	ScopeBackup      = "backup"       // list, create, download and restore backups
+)
+
+var tokenScopes = []string{ScopeRead, ScopeConfigWrite, ScopeBackup}
+
+// Errors returned by the API token methods
+var (
+	ErrTokenNotFound  = errors.New("API token not found")
+	ErrTokenName      = errors.New("token name is required")
+	ErrTokenScopes    = errors.New("scopes must be one or more of read, config-write, backup")
+	ErrInvalidToken   = errors.New("invalid API token")
+	ErrTokenExpiresAt = errors.New("expiresAt must be in the future")
+)
+
+// tokenLastUsedInterval limits how often a token's last-used time is written
+const tokenLastUsedInterval = time.Minute
+
+// TokenAllows reports whether a token with scopes may make a request that
+// needs the required scope. config-write implies read.
+func TokenAllows(scopes []string, required string) bool {
+	for _, scope := range scopes {
+		if scope == required || (scope == ScopeConfigWrite && required == ScopeRead) {
+			return true
+		}
+	}
+	return false
+}
+
+// CreateToken creates an API token for a user and returns it with the secret
+// token value, which is only stored hashed and cannot be retrieved again
+func (s *Service) CreateToken(userID int, name string, scopes []string, expiresAt *time.Time) (string, *models.APIToken, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", nil, ErrTokenName
+	}
+	if !validScopes(scopes) {
+		return "", nil, ErrTokenScopes
+	}
+	var expires sql.NullTime
+	if expiresAt != nil {
+		if !expiresAt.After(time.Now()) {
+			return "", nil, ErrTokenExpiresAt
+		}
+		expires = sql.NullTime{Time: expiresAt.UTC(), Valid: true}
+	}
+
+	bytes := make([]byte, 32)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", nil, fmt.Errorf("failed to generate token: %w", err)
+	}
+	token := TokenPrefix + base64.RawURLEncoding.EncodeToString(bytes)
+
+	result, err := s.db.Exec(
+		"INSERT INTO api_tokens (name, token_hash, prefix, scopes, user_id, expires_at) VALUES (?, ?, ?, ?, ?, ?)",
+		name, hashToken(token), token[:len(TokenPrefix)+4], strings.Join(scopes, ","), userID, expires,
+	)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to create token: %w", err)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return "", nil, fmt.Errorf("database error: %w", err)
+	}
+
+	apiToken, err := s.GetToken(int(id))
+	if err != nil {
+		return "", nil, err
+	}
+	return token, apiToken, nil
+}
+
+// ListTokens returns the API tokens of a user, or of all users if userID is 0
+func (s *Service) ListTokens(userID int) ([]models.APIToken, error) {
+	rows, err := s.db.Query(`
+		SELECT id, name, prefix, scopes, user_id, expires_at, last_used_at, created_at
+		FROM api_tokens WHERE ? = 0 OR user_id = ? ORDER BY created_at DESC, id DESC
+	`, userID, userID)
+	if err != nil {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+	defer rows.Close()
+
+	tokens := []models.APIToken{}
+	for rows.Next() {
+		token, err := scanToken(rows)
+		if err != nil {
+			return nil, fmt.Errorf("database error: %w", err)
+		}
+		tokens = append(tokens, *token)
+	}
+	return tokens, rows.Err()
+}
+
+// GetToken returns an API token by ID
+func (s *Service) GetToken(id int) (*models.APIToken, error) {
+	row := s.db.QueryRow(`
+		SELECT id, name, prefix, scopes, user_id, expires_at, last_used_at, created_at
+		FROM api_tokens WHERE id = ?
+	`, id)
+	token, err := scanToken(row)
+	if err == sql.ErrNoRows {
+		return nil, ErrTokenNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+	return token, nil
+}
+
+// RevokeToken deletes an API token
+func (s *Service) RevokeToken(id int) error {
+	result, err := s.db.Exec("DELETE FROM api_tokens WHERE id = ?", id)
+	if err != nil {
+		return fmt.Errorf("failed to revoke token: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrTokenNotFound
+	}
+	return nil
+}
+
+// TokenUser returns the owner of a valid API token and the token's scopes,
+// and records that the token was used
+func (s *Service) TokenUser(token string) (*models.User, []string, error) {
+	var user models.User
+	var id int
+	var scopes string
+	var expiresAt, lastUsedAt sql.NullTime
+
+	err := s.db.QueryRow(`
+		SELECT u.id, u.username, u.role, u.must_change_password, u.created_at, t.id, t.scopes, t.expires_at, t.last_used_at
+		FROM api_tokens t
+		JOIN users u ON u.id = t.user_id
+		WHERE t.token_hash = ?
+	`, hashToken(token)).Scan(&user.ID, &user.Username, &user.Role, &user.MustChangePassword, &user.CreatedAt, &id, &scopes, &expiresAt, &lastUsedAt)
+
+	if err == sql.ErrNoRows {
+		return nil, nil, ErrInvalidToken
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("database error: %w", err)
+	}
+
+	now := time.Now()
+	if expiresAt.Valid && now.After(expiresAt.Time) {
+		return nil, nil, ErrInvalidToken
+	}
+	if !lastUsedAt.Valid || now.Sub(lastUsedAt.Time) > tokenLastUsedInterval {
+		s.db.Exec("UPDATE api_tokens SET last_used_at = ? WHERE id = ?", now.UTC().Truncate(time.Second), id)
+	}
+
+	return &user, strings.Split(scopes, ","), nil
+}
+
+// scanToken reads an API token selected by GetToken or ListTokens
+func scanToken(row interface{ Scan(...interface{}) error }) (*models.APIToken, error) {
+	var token models.APIToken
+	var scopes string
+	var expiresAt, lastUsedAt sql.NullTime
+	err := row.Scan(&token.ID, &token.Name, &token.Prefix, &scopes, &token.UserID, &expiresAt, &lastUsedAt, &token.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	token.Scopes = strings.Split(scopes, ",")
+	if expiresAt.Valid {
+		token.ExpiresAt = &expiresAt.Time
+	}
+	if lastUsedAt.Valid {
+		token.LastUsedAt = &lastUsedAt.Time
+	}
+	return &token, nil
+}
+
+// validScopes reports whether scopes is a non-empty list of known scopes
+func validScopes(scopes []string) bool {
+	if len(scopes) == 0 {
+		return false
+	}
+	for _, scope := range scopes {
+		if !containsString(tokenScopes, scope) {
+			return false
+		}
+	}
+	return true
+}
+
+// hashToken returns the SHA-256 hash of an API token. Tokens are random, so
+// a fast hash is enough and keeps checking them cheap on every request.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
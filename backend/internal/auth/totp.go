@@ -0,0 +1,308 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"database/sql"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// TOTP parameters (RFC 6238). These are the defaults every authenticator app
+// supports, so they are not configurable.
+const (
+	totpDigits = 6
+	totpPeriod = 30 * time.Second
+	totpSkew   = 1 // steps either side of now, for clock drift
+
+	recoveryCodeCount = 10
+)
+
+// Errors returned by the two-factor authentication methods
+var (
+	ErrTOTPRequired    = errors.New("two-factor code required")
+	ErrInvalidTOTP     = errors.New("invalid two-factor code")
+	ErrTOTPEnabled     = errors.New("two-factor authentication is already enabled")
+	ErrTOTPNotEnabled  = errors.New("two-factor authentication is not enabled")
+	ErrTOTPNotEnrolled = errors.New("start two-factor enrolment first")
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// TOTPStatus reports whether a user has two-factor authentication enabled and
+// how many unused recovery codes they have left
+func (s *Service) TOTPStatus(userID int) (bool, int, error) {
+	var enabled bool
+	err := s.db.QueryRow("SELECT totp_enabled FROM users WHERE id = ?", userID).Scan(&enabled)
+	if err == sql.ErrNoRows {
+		return false, 0, ErrUserNotFound
+	}
+	if err != nil {
+		return false, 0, fmt.Errorf("database error: %w", err)
+	}
+
+	var remaining int
+	err = s.db.QueryRow(
+		"SELECT COUNT(*) FROM totp_recovery_codes WHERE user_id = ? AND used_at IS NULL",
+		userID,
+	).Scan(&remaining)
+	if err != nil {
+		return false, 0, fmt.Errorf("database error: %w", err)
+	}
+	return enabled, remaining, nil
+}
+
+// EnrollTOTP generates a new TOTP secret for a user and returns it with its
+// otpauth:// provisioning URI, which authenticator apps read from a QR code.
+// The secret is only required at login once EnableTOTP confirms it.
+func (s *Service) EnrollTOTP(userID int) (string, string, error) {
+	var username string
+	var enabled bool
+	err := s.db.QueryRow("SELECT username, totp_enabled FROM users WHERE id = ?", userID).Scan(&username, &enabled)
+	if err == sql.ErrNoRows {
+		return "", "", ErrUserNotFound
+	}
+	if err != nil {
+		return "", "", fmt.Errorf("database error: %w", err)
+	}
+	if enabled {
+		return "", "", ErrTOTPEnabled
+	}
+
+	bytes := make([]byte, 20)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", "", fmt.Errorf("failed to generate secret: %w", err)
+	}
+	secret := totpEncoding.EncodeToString(bytes)
+
+	if _, err := s.db.Exec("UPDATE users SET totp_secret = ? WHERE id = ?", secret, userID); err != nil {
+		return "", "", fmt.Errorf("failed to save secret: %w", err)
+	}
+
+	uri := url.URL{
+		Scheme: "otpauth",
+		Host:   "totp",
+		Path:   "/HOPS:" + username,
+		RawQuery: url.Values{
+			"secret":    {secret},
+			"issuer":    {"HOPS"},
+			"algorithm": {"SHA1"},
+			"digits":    {fmt.Sprint(totpDigits)},
+			"period":    {fmt.Sprint(int(totpPeriod.Seconds()))},
+		}.Encode(),
+	}
+	return secret, uri.String(), nil
+}
+
+// EnableTOTP turns on two-factor authentication once the user proves their
+// authenticator app has the enrolled secret, and returns their recovery codes
+func (s *Service) EnableTOTP(userID int, code string) ([]string, error) {
+	var secret sql.NullString
+	var enabled bool
+	err := s.db.QueryRow("SELECT totp_secret, totp_enabled FROM users WHERE id = ?", userID).Scan(&secret, &enabled)
+	if err == sql.ErrNoRows {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+	if enabled {
+		return nil, ErrTOTPEnabled
+	}
+	if !secret.Valid {
+		return nil, ErrTOTPNotEnrolled
+	}
+
+	step, ok := matchTOTP(secret.String, code, 0, time.Now())
+	if !ok {
+		return nil, ErrInvalidTOTP
+	}
+	return s.replaceRecoveryCodes(userID, "UPDATE users SET totp_enabled = 1, totp_last_step = ? WHERE id = ?", step, userID)
+}
+
+// RegenerateRecoveryCodes replaces a user's recovery codes, after checking a
+// current code from their authenticator app
+func (s *Service) RegenerateRecoveryCodes(userID int, code string) ([]string, error) {
+	if err := s.verifyTOTP(userID, code, false); err != nil {
+		return nil, err
+	}
+	return s.replaceRecoveryCodes(userID, "")
+}
+
+// DisableTOTP turns off a user's two-factor authentication, after checking a
+// code from their authenticator app or a recovery code
+func (s *Service) DisableTOTP(userID int, code string) error {
+	if err := s.verifyTOTP(userID, code, true); err != nil {
+		return err
+	}
+	return s.ResetTOTP(userID)
+}
+
+// ResetTOTP turns off a user's two-factor authentication and deletes their
+// secret and recovery codes, for admins helping a user who lost their device
+func (s *Service) ResetTOTP(userID int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("database error: %w", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(
+		"UPDATE users SET totp_secret = NULL, totp_enabled = 0, totp_last_step = 0 WHERE id = ?",
+		userID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to reset two-factor authentication: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrUserNotFound
+	}
+	if _, err := tx.Exec("DELETE FROM totp_recovery_codes WHERE user_id = ?", userID); err != nil {
+		return fmt.Errorf("failed to delete recovery codes: %w", err)
+	}
+	return tx.Commit()
+}
+
+// verifyTOTP checks a code from a user's authenticator app, or also one of
+// their recovery codes if allowRecovery is set. Each code can only be used once.
+func (s *Service) verifyTOTP(userID int, code string, allowRecovery bool) error {
+	var secret sql.NullString
+	var enabled bool
+	var lastStep int64
+	err := s.db.QueryRow(
+		"SELECT totp_secret, totp_enabled, totp_last_step FROM users WHERE id = ?",
+		userID,
+	).Scan(&secret, &enabled, &lastStep)
+	if err == sql.ErrNoRows {
+		return ErrUserNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("database error: %w", err)
+	}
+	if !enabled || !secret.Valid {
+		return ErrTOTPNotEnabled
+	}
+
+	code = strings.TrimSpace(code)
+	if code == "" {
+		return ErrTOTPRequired
+	}
+
+	if step, ok := matchTOTP(secret.String, code, lastStep, time.Now()); ok {
+		// The condition stops a concurrent request using the same code
+		result, err := s.db.Exec(
+			"UPDATE users SET totp_last_step = ? WHERE id = ? AND totp_last_step < ?",
+			step, userID, step,
+		)
+		if err != nil {
+			return fmt.Errorf("database error: %w", err)
+		}
+		if n, _ := result.RowsAffected(); n == 1 {
+			return nil
+		}
+		return ErrInvalidTOTP
+	}
+
+	if !allowRecovery {
+		return ErrInvalidTOTP
+	}
+	result, err := s.db.Exec(
+		"UPDATE totp_recovery_codes SET used_at = ? WHERE user_id = ? AND code_hash = ? AND used_at IS NULL",
+		time.Now().UTC().Truncate(time.Second), userID, hashToken(normalizeRecoveryCode(code)),
+	)
+	if err != nil {
+		return fmt.Errorf("database error: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrInvalidTOTP
+	}
+	return nil
+}
+
+// replaceRecoveryCodes generates new recovery codes for a user, replacing any
+// they had, and runs the optional extra statement in the same transaction
+func (s *Service) replaceRecoveryCodes(userID int, query string, args ...interface{}) ([]string, error) {
+	codes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		bytes := make([]byte, 10)
+		if _, err := rand.Read(bytes); err != nil {
+			return nil, fmt.Errorf("failed to generate recovery codes: %w", err)
+		}
+		code := strings.ToLower(totpEncoding.EncodeToString(bytes))
+		codes[i] = code[:8] + "-" + code[8:16]
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+	defer tx.Rollback()
+
+	if query != "" {
+		if _, err := tx.Exec(query, args...); err != nil {
+			return nil, fmt.Errorf("database error: %w", err)
+		}
+	}
+	if _, err := tx.Exec("DELETE FROM totp_recovery_codes WHERE user_id = ?", userID); err != nil {
+		return nil, fmt.Errorf("failed to delete recovery codes: %w", err)
+	}
+	for _, code := range codes {
+		_, err := tx.Exec(
+			"INSERT INTO totp_recovery_codes (user_id, code_hash) VALUES (?, ?)",
+			userID, hashToken(normalizeRecoveryCode(code)),
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to save recovery codes: %w", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+	return codes, nil
+}
+
+// normalizeRecoveryCode lowercases a recovery code and removes the separators
+// people may or may not type
+func normalizeRecoveryCode(code string) string {
+	return strings.NewReplacer("-", "", " ", "").Replace(strings.ToLower(code))
+}
+
+// matchTOTP reports whether code is valid for secret at now, allowing for
+// clock drift, and returns its time step. Steps up to lastStep have already
+// been used and are rejected.
+func matchTOTP(secret, code string, lastStep int64, now time.Time) (int64, bool) {
+	if len(code) != totpDigits {
+		return 0, false
+	}
+	key, err := totpEncoding.DecodeString(secret)
+	if err != nil {
+		return 0, false
+	}
+
+	current := now.Unix() / int64(totpPeriod.Seconds())
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if step > lastStep && hmac.Equal([]byte(totpCode(key, step)), []byte(code)) {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// totpCode computes the code for a time step (RFC 4226 section 5.3)
+func totpCode(key []byte, step int64) string {
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%1000000)
+}
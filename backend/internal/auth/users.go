@@ -0,0 +1,322 @@
+package auth
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/weaversgrainthorpe/HOPS/internal/models"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// User roles, from least to most privileged. Viewers can sign in to see
+// restricted dashboards, editors can also change dashboards, icons and
+// backgrounds, and admins can also manage users and backups.
+const (
+	RoleViewer = "viewer"
+	RoleEditor = "editor"
+	RoleAdmin  = "admin"
+)
+
+// roleRanks orders the roles for HasRole
+var roleRanks = map[string]int{
+	RoleViewer: 1,
+	RoleEditor: 2,
+	RoleAdmin:  3,
+}
+
+// Errors returned by the user management methods
+var (
+	ErrUserNotFound     = errors.New("user not found")
+	ErrUsernameTaken    = errors.New("username is already taken")
+	ErrUsernameRequired = errors.New("username is required")
+	ErrPasswordRequired = errors.New("password is required")
+	ErrInvalidRole      = errors.New("role must be admin, editor or viewer")
+	ErrLastAdmin        = errors.New("at least one admin account is required")
+)
+
+// ValidRole reports whether role is one of the known roles
+func ValidRole(role string) bool {
+	_, ok := roleRanks[role]
+	return ok
+}
+
+// HasRole reports whether role grants at least the privileges of required
+func HasRole(role, required string) bool {
+	return roleRanks[role] >= roleRanks[required] && ValidRole(role)
+}
+
+// mapRole returns the highest role that roleMap maps any of values (group or
+// role names from an identity provider) to, or defaultRole if none match
+func mapRole(values []string, roleMap map[string]string, defaultRole string) string {
+	role := ""
+	for _, value := range values {
+		if mapped, ok := roleMap[value]; ok && roleRanks[mapped] > roleRanks[role] {
+			role = mapped
+		}
+	}
+	if role == "" {
+		role = defaultRole
+	}
+	return role
+}
+
+// UserUpdate holds the fields to change in UpdateUser; nil fields are kept
+type UserUpdate struct {
+	Username           *string
+	Role               *string
+	Password           *string
+	MustChangePassword *bool // make the user choose a new password at their next login
+}
+
+// ListUsers returns all users ordered by username
+func (s *Service) ListUsers() ([]models.User, error) {
+	rows, err := s.db.Query(`
+		SELECT u.id, u.username, u.role, u.totp_enabled, u.must_change_password, u.created_at, f.locked_until
+		FROM users u LEFT JOIN login_failures f ON f.username = u.username
+		ORDER BY u.username
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+	defer rows.Close()
+
+	users := []models.User{}
+	for rows.Next() {
+		var user models.User
+		var lockedUntil sql.NullTime
+		if err := rows.Scan(&user.ID, &user.Username, &user.Role, &user.TOTPEnabled, &user.MustChangePassword, &user.CreatedAt, &lockedUntil); err != nil {
+			return nil, fmt.Errorf("database error: %w", err)
+		}
+		user.LockedUntil = activeLockout(lockedUntil)
+		users = append(users, user)
+	}
+	return users, rows.Err()
+}
+
+// GetUser returns a user by ID
+func (s *Service) GetUser(id int) (*models.User, error) {
+	var user models.User
+	var lockedUntil sql.NullTime
+	err := s.db.QueryRow(`
+		SELECT u.id, u.username, u.role, u.totp_enabled, u.must_change_password, u.created_at, f.locked_until
+		FROM users u LEFT JOIN login_failures f ON f.username = u.username
+		WHERE u.id = ?
+	`, id).Scan(&user.ID, &user.Username, &user.Role, &user.TOTPEnabled, &user.MustChangePassword, &user.CreatedAt, &lockedUntil)
+	if err == sql.ErrNoRows {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+	user.LockedUntil = activeLockout(lockedUntil)
+	return &user, nil
+}
+
+// CreateUser adds a user with the given password and role. The password must
+// meet the password policy.
+func (s *Service) CreateUser(username, password, role string) (*models.User, error) {
+	username = strings.TrimSpace(username)
+	if username == "" {
+		return nil, ErrUsernameRequired
+	}
+	if !ValidRole(role) {
+		return nil, ErrInvalidRole
+	}
+	if err := s.checkPassword(0, password); err != nil {
+		return nil, err
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash password: %w", err)
+	}
+
+	result, err := s.db.Exec(
+		"INSERT INTO users (username, password_hash, role) VALUES (?, ?, ?)",
+		username, hash, role,
+	)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return nil, ErrUsernameTaken
+		}
+		return nil, fmt.Errorf("failed to create user: %w", err)
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+	return s.GetUser(int(id))
+}
+
+// UpdateUser changes a user's username, role or password. Setting a new
+// password, which must meet the password policy, signs the user out
+// everywhere. The last admin cannot be demoted.
+func (s *Service) UpdateUser(id int, update UserUpdate) (*models.User, error) {
+	var hash []byte
+	if update.Password != nil {
+		if err := s.checkPassword(id, *update.Password); err != nil {
+			return nil, err
+		}
+		var err error
+		if hash, err = bcrypt.GenerateFromPassword([]byte(*update.Password), bcrypt.DefaultCost); err != nil {
+			return nil, fmt.Errorf("failed to hash password: %w", err)
+		}
+	}
+	if update.Role != nil && !ValidRole(*update.Role) {
+		return nil, ErrInvalidRole
+	}
+	if update.Username != nil && strings.TrimSpace(*update.Username) == "" {
+		return nil, ErrUsernameRequired
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+	defer tx.Rollback()
+
+	var role string
+	err = tx.QueryRow("SELECT role FROM users WHERE id = ?", id).Scan(&role)
+	if err == sql.ErrNoRows {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+
+	if update.Role != nil && role == RoleAdmin && *update.Role != RoleAdmin {
+		if err := checkOtherAdmins(tx, id); err != nil {
+			return nil, err
+		}
+	}
+
+	if update.Username != nil {
+		_, err := tx.Exec("UPDATE users SET username = ? WHERE id = ?", strings.TrimSpace(*update.Username), id)
+		if isUniqueViolation(err) {
+			return nil, ErrUsernameTaken
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to update user: %w", err)
+		}
+	}
+	if update.Role != nil {
+		if _, err := tx.Exec("UPDATE users SET role = ? WHERE id = ?", *update.Role, id); err != nil {
+			return nil, fmt.Errorf("failed to update user: %w", err)
+		}
+	}
+	if hash != nil {
+		if err := s.setPassword(tx, id, hash, update.MustChangePassword != nil && *update.MustChangePassword); err != nil {
+			return nil, err
+		}
+		if _, err := tx.Exec("DELETE FROM sessions WHERE user_id = ?", id); err != nil {
+			return nil, fmt.Errorf("failed to revoke sessions: %w", err)
+		}
+	} else if update.MustChangePassword != nil {
+		if _, err := tx.Exec("UPDATE users SET must_change_password = ? WHERE id = ?", *update.MustChangePassword, id); err != nil {
+			return nil, fmt.Errorf("failed to update user: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+	return s.GetUser(id)
+}
+
+// DeleteUser removes a user with their sessions, API tokens, recovery codes
+// and password history. The last admin cannot be deleted.
+func (s *Service) DeleteUser(id int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("database error: %w", err)
+	}
+	defer tx.Rollback()
+
+	var role string
+	err = tx.QueryRow("SELECT role FROM users WHERE id = ?", id).Scan(&role)
+	if err == sql.ErrNoRows {
+		return ErrUserNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("database error: %w", err)
+	}
+
+	if role == RoleAdmin {
+		if err := checkOtherAdmins(tx, id); err != nil {
+			return err
+		}
+	}
+
+	if _, err := tx.Exec("DELETE FROM sessions WHERE user_id = ?", id); err != nil {
+		return fmt.Errorf("failed to revoke sessions: %w", err)
+	}
+	if _, err := tx.Exec("DELETE FROM api_tokens WHERE user_id = ?", id); err != nil {
+		return fmt.Errorf("failed to revoke API tokens: %w", err)
+	}
+	if _, err := tx.Exec("DELETE FROM totp_recovery_codes WHERE user_id = ?", id); err != nil {
+		return fmt.Errorf("failed to delete recovery codes: %w", err)
+	}
+	if _, err := tx.Exec("DELETE FROM password_history WHERE user_id = ?", id); err != nil {
+		return fmt.Errorf("failed to delete password history: %w", err)
+	}
+	if _, err := tx.Exec("DELETE FROM users WHERE id = ?", id); err != nil {
+		return fmt.Errorf("failed to delete user: %w", err)
+	}
+	return tx.Commit()
+}
+
+// createExternalUser adds a user without a password for an identity
+// provider. oidcSubject links the user to an OpenID Connect identity.
+func (s *Service) createExternalUser(username, role string, oidcSubject *string) (*models.User, error) {
+	result, err := s.db.Exec(
+		"INSERT INTO users (username, password_hash, role, oidc_subject) VALUES (?, '', ?, ?)",
+		username, role, oidcSubject,
+	)
+	if isUniqueViolation(err) {
+		return nil, ErrUsernameTaken
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create user: %w", err)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+	return s.GetUser(int(id))
+}
+
+// syncRole changes a user's role to the one their identity provider maps
+// them to, except that the last admin is not demoted
+func (s *Service) syncRole(userID int, username, current, role string) error {
+	if current == role {
+		return nil
+	}
+	_, err := s.UpdateUser(userID, UserUpdate{Role: &role})
+	if errors.Is(err, ErrLastAdmin) {
+		log.Printf("[Auth] Not demoting %q: they are the last admin", username)
+		return nil
+	}
+	return err
+}
+
+// checkOtherAdmins returns ErrLastAdmin unless an admin other than id exists
+func checkOtherAdmins(tx *sql.Tx, id int) error {
+	var admins int
+	err := tx.QueryRow("SELECT COUNT(*) FROM users WHERE role = ? AND id != ?", RoleAdmin, id).Scan(&admins)
+	if err != nil {
+		return fmt.Errorf("database error: %w", err)
+	}
+	if admins == 0 {
+		return ErrLastAdmin
+	}
+	return nil
+}
+
+// isUniqueViolation reports whether err is a SQLite UNIQUE constraint failure
+func isUniqueViolation(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "UNIQUE constraint failed")
+}
@@ -1,10 +1,90 @@
 package config
 
+import (
+	"net/netip"
+	"time"
+)
+
 // Config holds the application configuration
 type Config struct {
 	Port                 string
 	DataDir              string
 	FrontendDir          string
-	AllowedOrigins       []string // CORS allowed origins
-	LoginRateLimitPerMin int      // Rate limit login attempts per minute
+	AllowedOrigins       []string       // CORS allowed origins
+	LoginRateLimitPerMin int            // Rate limit login attempts per minute
+	SessionIdleTimeout   time.Duration  // sessions unused for this long expire; 0 disables
+	SessionMaxAge        time.Duration  // sessions expire this long after login
+	SecureCookies        bool           // always mark session cookies HTTPS-only
+	TrustedProxies       []netip.Prefix // reverse proxies whose headers are trusted
+	AuditRetention       time.Duration  // audit log entries older than this are deleted; 0 keeps them
+	AuditMaxEntries      int            // keep at most this many audit log entries; 0 for no limit
+	ConfigHistory        time.Duration  // config revisions older than this are deleted, except the current one; 0 keeps them
+	ConfigHistoryMax     int            // keep at most this many config revisions; 0 for no limit
+	StatusHistoryRaw     time.Duration  // individual status check results older than this are deleted; 0 keeps them
+	StatusHistory        time.Duration  // hourly status check totals older than this are deleted; 0 keeps them
+	NotifyThreshold      int            // consecutive status check results that confirm a change before it is notified
+	RateLimits           RateLimits
+	PasswordPolicy       PasswordPolicy
+	LoginLockout         LoginLockout
+	OIDC                 OIDCConfig
+	ProxyAuth            ProxyAuthConfig
+}
+
+// RateLimits holds how many API requests a minute each client IP may make in
+// each route class; 0 disables a class's limit
+type RateLimits struct {
+	Read   int // GET and HEAD requests
+	Write  int // requests that change something, other than uploads
+	Upload int // icon, background and config import uploads
+}
+
+// PasswordPolicy holds the rules new passwords must follow
+type PasswordPolicy struct {
+	MinLength     int  // minimum length in characters
+	CheckBreached bool // reject passwords on the bundled list of common, breached passwords
+	History       int  // reject the current password and this many before it; 0 allows reuse
+}
+
+// LoginLockout holds how failed password logins lock a username out. Each
+// failure from the threshold on locks it for twice as long as the last.
+type LoginLockout struct {
+	Threshold int           // failures before the first lockout; 0 disables lockouts
+	Delay     time.Duration // length of the first lockout
+	MaxDelay  time.Duration // longest lockout
+}
+
+// OIDCConfig configures single sign-on with an OpenID Connect provider such
+// as Authelia or Keycloak. It is disabled when Issuer is empty.
+type OIDCConfig struct {
+	Issuer        string // e.g. https://auth.example.com
+	ClientID      string
+	ClientSecret  string            // empty for public clients
+	RedirectURL   string            // e.g. https://hops.example.com/api/auth/oidc/callback
+	Scopes        []string          // requested scopes, including "openid"
+	UsernameClaim string            // claim used as the HOPS username, e.g. preferred_username
+	RoleClaim     string            // claim holding group or role names, e.g. groups
+	RoleMap       map[string]string // RoleClaim value -> HOPS role
+	DefaultRole   string            // role for users no RoleMap entry matches; empty denies them
+	AutoProvision bool              // create users on their first login
+}
+
+// Enabled reports whether OpenID Connect sign-in is configured
+func (c OIDCConfig) Enabled() bool {
+	return c.Issuer != ""
+}
+
+// ProxyAuthConfig configures sign-in with identity headers set by a trusted
+// reverse proxy doing forward authentication, such as Authelia or
+// oauth2-proxy. It is disabled when UserHeader is empty.
+type ProxyAuthConfig struct {
+	UserHeader    string            // e.g. Remote-User
+	GroupsHeader  string            // e.g. Remote-Groups, a comma-separated list
+	RoleMap       map[string]string // group name -> HOPS role
+	DefaultRole   string            // role for users no RoleMap entry matches; empty denies them
+	AutoProvision bool              // create users on their first request
+}
+
+// Enabled reports whether reverse proxy authentication is configured
+func (c ProxyAuthConfig) Enabled() bool {
+	return c.UserHeader != ""
 }
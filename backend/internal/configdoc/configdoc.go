@@ -0,0 +1,136 @@
+// Package configdoc provides helpers for working with the config document in
+// its generic JSON form (dashboards > tabs > groups > entries).
+package configdoc
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+)
+
+// Level identifies a depth in the dashboard hierarchy
+type Level int
+
+const (
+	LevelDashboard Level = iota
+	LevelTab
+	LevelGroup
+	LevelEntry
+)
+
+// levelInfo holds the singular name of each level and the key under which its
+// items are stored in the parent node
+var levelInfo = [...]struct {
+	kind       string
+	collection string
+}{
+	LevelDashboard: {"dashboard", "dashboards"},
+	LevelTab:       {"tab", "tabs"},
+	LevelGroup:     {"group", "groups"},
+	LevelEntry:     {"entry", "entries"},
+}
+
+// Kind returns the singular name of the level (e.g. "tab")
+func (l Level) Kind() string {
+	return levelInfo[l].kind
+}
+
+// Collection returns the key holding this level's items in the parent node
+func (l Level) Collection() string {
+	return levelInfo[l].collection
+}
+
+// ChildCollection returns the key holding the child items of a node at this
+// level, or an empty string for entries
+func (l Level) ChildCollection() string {
+	if l == LevelEntry {
+		return ""
+	}
+	return levelInfo[l+1].collection
+}
+
+// Items returns the list stored under key in node, or nil if missing
+func Items(node map[string]interface{}, key string) []interface{} {
+	items, _ := node[key].([]interface{})
+	return items
+}
+
+// FindByID returns the item with the given id and its index, or nil and -1
+func FindByID(items []interface{}, id string) (map[string]interface{}, int) {
+	for i, item := range items {
+		node, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if nodeID, _ := node["id"].(string); nodeID == id {
+			return node, i
+		}
+	}
+	return nil, -1
+}
+
+// Renumber sets each item's "order" field to its index in the list
+func Renumber(items []interface{}) {
+	for i, item := range items {
+		if node, ok := item.(map[string]interface{}); ok {
+			node["order"] = i
+		}
+	}
+}
+
+// Entries returns every entry beneath node, whose own items are at level
+// (LevelDashboard for the document root)
+func Entries(node map[string]interface{}, level Level) []map[string]interface{} {
+	var entries []map[string]interface{}
+	for _, item := range Items(node, level.Collection()) {
+		child, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if level == LevelEntry {
+			entries = append(entries, child)
+		} else {
+			entries = append(entries, Entries(child, level+1)...)
+		}
+	}
+	return entries
+}
+
+// EntryIDs returns the set of entry IDs used anywhere in the document
+func EntryIDs(doc map[string]interface{}) map[string]bool {
+	ids := make(map[string]bool)
+	for _, entry := range Entries(doc, LevelDashboard) {
+		if id, _ := entry["id"].(string); id != "" {
+			ids[id] = true
+		}
+	}
+	return ids
+}
+
+// UniqueEntryIDs gives every entry beneath node (see Entries) whose ID is
+// missing or already present in used a generated ID, and records the final
+// IDs in used
+func UniqueEntryIDs(node map[string]interface{}, level Level, used map[string]bool) error {
+	for _, entry := range Entries(node, level) {
+		id, _ := entry["id"].(string)
+		if id == "" || used[id] {
+			newID, err := GenerateID(LevelEntry.Kind())
+			if err != nil {
+				return err
+			}
+			entry["id"] = newID
+			id = newID
+		}
+		used[id] = true
+	}
+	return nil
+}
+
+// GenerateID creates a random ID such as "entry-1a2b3c4d5e6f7a8b"
+func GenerateID(kind string) (string, error) {
+	randomBytes := make([]byte, 8)
+	if _, err := rand.Read(randomBytes); err != nil {
+		return "", fmt.Errorf("failed to generate ID: %w", err)
+	}
+	return kind + "-" + hex.EncodeToString(randomBytes), nil
+}
@@ -0,0 +1,139 @@
+package configdoc
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// NodeChange describes a dashboard, tab, group or entry that differs between
+// two config documents
+type NodeChange struct {
+	Kind   string   `json:"kind"`
+	ID     string   `json:"id"`
+	Path   string   `json:"path"` // IDs from the dashboard down, e.g. "home/main/media/plex"
+	Name   string   `json:"name,omitempty"`
+	Fields []string `json:"fields,omitempty"` // changed fields, for modified nodes
+}
+
+// Diff is the structural difference between two config documents. Nodes are
+// matched by their ID path, so a node moved to another parent shows up as
+// removed and added.
+type Diff struct {
+	Added   []NodeChange `json:"added"`
+	Removed []NodeChange `json:"removed"`
+	Changed []NodeChange `json:"changed"`
+	// Top-level fields other than dashboards (theme, settings, ...) that changed
+	Config []string `json:"config"`
+}
+
+// flatNode is a node with its child collection removed
+type flatNode struct {
+	kind   string
+	id     string
+	path   string
+	fields map[string]interface{}
+}
+
+// Compare returns the structural difference from one config document to another
+func Compare(from, to map[string]interface{}) *Diff {
+	diff := &Diff{
+		Added:   []NodeChange{},
+		Removed: []NodeChange{},
+		Changed: []NodeChange{},
+		Config:  changedFields(withoutKey(from, LevelDashboard.Collection()), withoutKey(to, LevelDashboard.Collection())),
+	}
+
+	fromNodes := flatten(from)
+	toNodes := flatten(to)
+
+	fromByPath := make(map[string]flatNode, len(fromNodes))
+	for _, node := range fromNodes {
+		fromByPath[node.path] = node
+	}
+	toByPath := make(map[string]flatNode, len(toNodes))
+	for _, node := range toNodes {
+		toByPath[node.path] = node
+	}
+
+	for _, node := range toNodes {
+		old, ok := fromByPath[node.path]
+		if !ok {
+			diff.Added = append(diff.Added, node.change(nil))
+			continue
+		}
+		if fields := changedFields(old.fields, node.fields); len(fields) > 0 {
+			diff.Changed = append(diff.Changed, node.change(fields))
+		}
+	}
+	for _, node := range fromNodes {
+		if _, ok := toByPath[node.path]; !ok {
+			diff.Removed = append(diff.Removed, node.change(nil))
+		}
+	}
+
+	return diff
+}
+
+// change converts the node into a NodeChange
+func (n flatNode) change(fields []string) NodeChange {
+	name, _ := n.fields["name"].(string)
+	return NodeChange{Kind: n.kind, ID: n.id, Path: n.path, Name: name, Fields: fields}
+}
+
+// flatten lists every node in the document in depth-first order
+func flatten(doc map[string]interface{}) []flatNode {
+	var nodes []flatNode
+	var walk func(parent map[string]interface{}, level Level, prefix []string)
+	walk = func(parent map[string]interface{}, level Level, prefix []string) {
+		for _, item := range Items(parent, level.Collection()) {
+			node, ok := item.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			id, _ := node["id"].(string)
+			path := append(append([]string{}, prefix...), id)
+			child := level.ChildCollection()
+
+			nodes = append(nodes, flatNode{
+				kind:   level.Kind(),
+				id:     id,
+				path:   strings.Join(path, "/"),
+				fields: withoutKey(node, child),
+			})
+			if child != "" {
+				walk(node, level+1, path)
+			}
+		}
+	}
+	walk(doc, LevelDashboard, nil)
+	return nodes
+}
+
+// changedFields returns the sorted keys whose values differ between a and b
+func changedFields(a, b map[string]interface{}) []string {
+	fields := []string{}
+	for key, value := range a {
+		if other, ok := b[key]; !ok || !reflect.DeepEqual(value, other) {
+			fields = append(fields, key)
+		}
+	}
+	for key := range b {
+		if _, ok := a[key]; !ok {
+			fields = append(fields, key)
+		}
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+// withoutKey returns a shallow copy of node without the given key
+func withoutKey(node map[string]interface{}, key string) map[string]interface{} {
+	result := make(map[string]interface{}, len(node))
+	for k, v := range node {
+		if k != key {
+			result[k] = v
+		}
+	}
+	return result
+}
@@ -0,0 +1,291 @@
+package configdoc
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// PatchOperation is a single RFC 6902 JSON Patch operation
+type PatchOperation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	From  string      `json:"from,omitempty"`
+	Value interface{} `json:"value"`
+}
+
+// TestFailedError is returned when a JSON Patch "test" operation does not match
+type TestFailedError struct {
+	Path string
+}
+
+func (e *TestFailedError) Error() string {
+	return fmt.Sprintf("test operation failed at %q", e.Path)
+}
+
+// ApplyJSONPatch applies an RFC 6902 JSON Patch to doc and returns the result.
+// doc may be modified even if an error is returned.
+func ApplyJSONPatch(doc interface{}, patch []PatchOperation) (interface{}, error) {
+	for i, op := range patch {
+		path, err := parsePointer(op.Path)
+		if err != nil {
+			return nil, fmt.Errorf("operation %d: %w", i, err)
+		}
+
+		switch op.Op {
+		case "add":
+			doc, err = addValue(doc, path, op.Value)
+		case "remove":
+			doc, _, err = removeValue(doc, path)
+		case "replace":
+			if _, err = getValue(doc, path); err == nil {
+				doc, err = replaceValue(doc, path, op.Value)
+			}
+		case "move", "copy":
+			var from []string
+			if from, err = parsePointer(op.From); err != nil {
+				break
+			}
+			var value interface{}
+			if op.Op == "move" {
+				if isPrefix(from, path) {
+					err = fmt.Errorf("cannot move %q into itself", op.From)
+					break
+				}
+				doc, value, err = removeValue(doc, from)
+			} else {
+				value, err = getValue(doc, from)
+				value = deepCopy(value)
+			}
+			if err == nil {
+				doc, err = addValue(doc, path, value)
+			}
+		case "test":
+			var value interface{}
+			if value, err = getValue(doc, path); err == nil && !reflect.DeepEqual(value, op.Value) {
+				return nil, &TestFailedError{Path: op.Path}
+			}
+		default:
+			err = fmt.Errorf("unknown op %q", op.Op)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("operation %d (%s %s): %w", i, op.Op, op.Path, err)
+		}
+	}
+	return doc, nil
+}
+
+// ApplyMergePatch applies an RFC 7396 JSON Merge Patch to target and returns the result
+func ApplyMergePatch(target, patch interface{}) interface{} {
+	patchObject, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+
+	targetObject, ok := target.(map[string]interface{})
+	if !ok {
+		targetObject = make(map[string]interface{})
+	}
+	for key, value := range patchObject {
+		if value == nil {
+			delete(targetObject, key)
+		} else {
+			targetObject[key] = ApplyMergePatch(targetObject[key], value)
+		}
+	}
+	return targetObject
+}
+
+// parsePointer splits an RFC 6901 JSON Pointer into unescaped reference tokens
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return []string{}, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("invalid JSON pointer %q", pointer)
+	}
+
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+	}
+	return tokens, nil
+}
+
+// isPrefix reports whether prefix is a proper prefix of path
+func isPrefix(prefix, path []string) bool {
+	if len(prefix) >= len(path) {
+		return false
+	}
+	for i := range prefix {
+		if prefix[i] != path[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// arrayIndex parses an array index token; "-" is allowed (as len) only if allowEnd is set
+func arrayIndex(token string, length int, allowEnd bool) (int, error) {
+	if token == "-" && allowEnd {
+		return length, nil
+	}
+	index, err := strconv.Atoi(token)
+	if err != nil || strings.TrimLeft(token, "0123456789") != "" || (token != "0" && strings.HasPrefix(token, "0")) {
+		return 0, fmt.Errorf("invalid array index %q", token)
+	}
+	max := length - 1
+	if allowEnd {
+		max = length
+	}
+	if index > max {
+		return 0, fmt.Errorf("array index %d out of range", index)
+	}
+	return index, nil
+}
+
+// getValue returns the value at path
+func getValue(node interface{}, path []string) (interface{}, error) {
+	for _, token := range path {
+		switch n := node.(type) {
+		case map[string]interface{}:
+			value, ok := n[token]
+			if !ok {
+				return nil, fmt.Errorf("path not found: %q", token)
+			}
+			node = value
+		case []interface{}:
+			index, err := arrayIndex(token, len(n), false)
+			if err != nil {
+				return nil, err
+			}
+			node = n[index]
+		default:
+			return nil, fmt.Errorf("cannot traverse into %q", token)
+		}
+	}
+	return node, nil
+}
+
+// updateChild replaces the child of node addressed by token with the result
+// of fn, returning the (possibly reallocated) node
+func updateChild(node interface{}, token string, fn func(child interface{}) (interface{}, error)) (interface{}, error) {
+	switch n := node.(type) {
+	case map[string]interface{}:
+		child, ok := n[token]
+		if !ok {
+			return nil, fmt.Errorf("path not found: %q", token)
+		}
+		updated, err := fn(child)
+		if err != nil {
+			return nil, err
+		}
+		n[token] = updated
+		return n, nil
+	case []interface{}:
+		index, err := arrayIndex(token, len(n), false)
+		if err != nil {
+			return nil, err
+		}
+		updated, err := fn(n[index])
+		if err != nil {
+			return nil, err
+		}
+		n[index] = updated
+		return n, nil
+	default:
+		return nil, fmt.Errorf("cannot traverse into %q", token)
+	}
+}
+
+// addValue adds value at path, inserting into arrays, and returns the new node
+func addValue(node interface{}, path []string, value interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+	if len(path) > 1 {
+		return updateChild(node, path[0], func(child interface{}) (interface{}, error) {
+			return addValue(child, path[1:], value)
+		})
+	}
+
+	switch n := node.(type) {
+	case map[string]interface{}:
+		n[path[0]] = value
+		return n, nil
+	case []interface{}:
+		index, err := arrayIndex(path[0], len(n), true)
+		if err != nil {
+			return nil, err
+		}
+		result := make([]interface{}, 0, len(n)+1)
+		result = append(result, n[:index]...)
+		result = append(result, value)
+		return append(result, n[index:]...), nil
+	default:
+		return nil, fmt.Errorf("cannot add to %q", path[0])
+	}
+}
+
+// replaceValue sets the existing value at path and returns the new node
+func replaceValue(node interface{}, path []string, value interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+	return updateChild(node, path[0], func(child interface{}) (interface{}, error) {
+		return replaceValue(child, path[1:], value)
+	})
+}
+
+// removeValue removes the value at path and returns the new node and the removed value
+func removeValue(node interface{}, path []string) (interface{}, interface{}, error) {
+	if len(path) == 0 {
+		return nil, nil, fmt.Errorf("cannot remove the whole document")
+	}
+
+	var removed interface{}
+	if len(path) > 1 {
+		updated, err := updateChild(node, path[0], func(child interface{}) (interface{}, error) {
+			updated, value, err := removeValue(child, path[1:])
+			removed = value
+			return updated, err
+		})
+		return updated, removed, err
+	}
+
+	switch n := node.(type) {
+	case map[string]interface{}:
+		value, ok := n[path[0]]
+		if !ok {
+			return nil, nil, fmt.Errorf("path not found: %q", path[0])
+		}
+		delete(n, path[0])
+		return n, value, nil
+	case []interface{}:
+		index, err := arrayIndex(path[0], len(n), false)
+		if err != nil {
+			return nil, nil, err
+		}
+		removed = n[index]
+		result := make([]interface{}, 0, len(n)-1)
+		result = append(result, n[:index]...)
+		return append(result, n[index+1:]...), removed, nil
+	default:
+		return nil, nil, fmt.Errorf("cannot remove from %q", path[0])
+	}
+}
+
+// deepCopy copies a generic JSON value
+func deepCopy(value interface{}) interface{} {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return value
+	}
+	var copied interface{}
+	if err := json.Unmarshal(data, &copied); err != nil {
+		return value
+	}
+	return copied
+}
@@ -0,0 +1,57 @@
+package configdoc
+
+import (
+	"fmt"
+
+	"github.com/weaversgrainthorpe/HOPS/internal/models"
+)
+
+// upgrades[v] converts a document from schema version v to v+1. A document
+// without a schemaVersion field is version 0. Bump models.CurrentSchemaVersion
+// and append a step here whenever the stored shape changes.
+var upgrades = []func(doc map[string]interface{}) error{
+	// 0 -> 1: entry IDs key status checks, but imports used to reuse
+	// converter-generated IDs such as "entry-0-0"
+	func(doc map[string]interface{}) error {
+		used := make(map[string]bool)
+		return UniqueEntryIDs(doc, LevelDashboard, used)
+	},
+}
+
+func init() {
+	if len(upgrades) != models.CurrentSchemaVersion {
+		panic(fmt.Sprintf("configdoc: %d upgrade steps for schema version %d", len(upgrades), models.CurrentSchemaVersion))
+	}
+}
+
+// SchemaVersion returns the document's schemaVersion, or 0 if it has none
+func SchemaVersion(doc map[string]interface{}) int {
+	switch version := doc["schemaVersion"].(type) {
+	case float64:
+		return int(version)
+	case int:
+		return version
+	default:
+		return 0
+	}
+}
+
+// Upgrade converts doc in place to models.CurrentSchemaVersion and reports
+// whether it was changed. Documents from a newer schema are left untouched.
+func Upgrade(doc map[string]interface{}) (bool, error) {
+	version := SchemaVersion(doc)
+	if version >= models.CurrentSchemaVersion {
+		return false, nil
+	}
+	if version < 0 {
+		version = 0
+	}
+
+	for ; version < models.CurrentSchemaVersion; version++ {
+		if err := upgrades[version](doc); err != nil {
+			return false, fmt.Errorf("failed to upgrade config from schema version %d: %w", version, err)
+		}
+		doc["schemaVersion"] = version + 1
+	}
+	return true, nil
+}
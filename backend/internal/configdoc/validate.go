@@ -0,0 +1,404 @@
+package configdoc
+
+import (
+	"fmt"
+	"math"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Allowed values for enumerated config fields, matching the frontend types
+var (
+	openModes        = []string{"iframe", "newtab", "sametab", "modal", "popup"}
+	entrySizes       = []string{"small", "medium", "large", "wide"}
+	statusCheckTypes = []string{"http", "icmp", "tcp"}
+	backgroundTypes  = []string{"image", "slideshow", "color"}
+	backgroundFits   = []string{"cover", "contain", "fill"}
+	transitions      = []string{
+		"crossfade", "slide", "slide-up", "slide-down", "zoom", "zoom-out", "fade-black", "blur", "flip", "swirl",
+		"wipe", "curtain", "circle", "diamond", "dissolve", "flash", "glitch", "kenburns", "none", "random",
+	}
+	textColors    = []string{"auto", "light", "dark"}
+	displayStyles = []string{"header", "folder"}
+	themeModes    = []string{"light", "dark", "auto"}
+	userRoles     = []string{"admin", "editor", "viewer"}
+)
+
+// schemePattern matches a URL that starts with a scheme such as "https:"
+var schemePattern = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9+.-]*:`)
+
+// ValidationError describes a single problem in a config document
+type ValidationError struct {
+	Path    string `json:"path"` // JSON pointer to the offending value, e.g. "/dashboards/0/tabs/1/name"
+	Message string `json:"message"`
+}
+
+// ValidationErrors is the list of problems returned by Validate
+type ValidationErrors []ValidationError
+
+func (e ValidationErrors) Error() string {
+	if len(e) == 1 {
+		return fmt.Sprintf("%s: %s", e[0].Path, e[0].Message)
+	}
+	return fmt.Sprintf("%d validation errors, first: %s: %s", len(e), e[0].Path, e[0].Message)
+}
+
+// Validate checks a whole config document against the config model: field
+// types, required fields, enumerated values, URLs, numeric ranges and the
+// uniqueness of dashboard IDs and paths, tab and group IDs within their parent
+// and entry IDs across the document. It returns nil or a ValidationErrors
+// listing every problem found. Fields the model does not know are allowed.
+func Validate(doc map[string]interface{}) error {
+	v := newValidator()
+
+	if _, ok := doc["dashboards"]; !ok {
+		v.add("/dashboards", "is required")
+	}
+	v.number(doc, "", "schemaVersion", 1, math.MaxInt32, true)
+	v.nodes(doc, "", LevelDashboard)
+
+	if theme := v.object(doc, "", "theme"); theme != nil {
+		v.enum(theme, "/theme", "mode", themeModes)
+		v.string(theme, "/theme", "customCss")
+	}
+	if settings := v.object(doc, "", "settings"); settings != nil {
+		v.string(settings, "/settings", "searchHotkey")
+		v.string(settings, "/settings", "defaultView")
+	}
+
+	return v.result()
+}
+
+// ValidateNode checks a single dashboard, tab, group or entry and its
+// descendants. pointer is the node's location, used to prefix error paths.
+// Uniqueness is only checked within the node itself.
+func ValidateNode(level Level, node map[string]interface{}, pointer string) error {
+	v := newValidator()
+	v.node(node, pointer, level)
+	return v.result()
+}
+
+// validator accumulates errors while walking a config document
+type validator struct {
+	errors   ValidationErrors
+	entryIDs map[string]string // entry ID -> pointer of the first entry using it
+	paths    map[string]string // dashboard path -> pointer of the first dashboard using it
+}
+
+func newValidator() *validator {
+	return &validator{
+		entryIDs: make(map[string]string),
+		paths:    make(map[string]string),
+	}
+}
+
+func (v *validator) result() error {
+	if len(v.errors) == 0 {
+		return nil
+	}
+	return v.errors
+}
+
+func (v *validator) add(pointer, format string, args ...interface{}) {
+	v.errors = append(v.errors, ValidationError{Path: pointer, Message: fmt.Sprintf(format, args...)})
+}
+
+// nodes validates the collection of child nodes at level held by parent
+func (v *validator) nodes(parent map[string]interface{}, pointer string, level Level) {
+	key := level.Collection()
+	value, ok := parent[key]
+	if !ok || value == nil {
+		return
+	}
+	collectionPointer := pointer + "/" + key
+	items, ok := value.([]interface{})
+	if !ok {
+		v.add(collectionPointer, "must be an array")
+		return
+	}
+
+	ids := make(map[string]string)
+	for i, item := range items {
+		itemPointer := collectionPointer + "/" + strconv.Itoa(i)
+		node, ok := item.(map[string]interface{})
+		if !ok {
+			v.add(itemPointer, "must be an object")
+			continue
+		}
+
+		// Entry IDs are checked across the whole document by entry
+		if id, ok := node["id"].(string); ok && id != "" && level != LevelEntry {
+			if first, exists := ids[id]; exists {
+				v.add(itemPointer+"/id", "duplicate %s ID %q (also used at %s)", level.Kind(), id, first)
+			} else {
+				ids[id] = itemPointer
+			}
+		}
+		v.node(node, itemPointer, level)
+	}
+}
+
+// node validates a single node at level and its descendants
+func (v *validator) node(node map[string]interface{}, pointer string, level Level) {
+	if id, ok := v.string(node, pointer, "id"); ok && strings.TrimSpace(id) == "" {
+		v.add(pointer+"/id", "must not be empty")
+	} else if !ok && node["id"] == nil {
+		v.add(pointer+"/id", "is required")
+	}
+	if name, ok := v.string(node, pointer, "name"); ok && strings.TrimSpace(name) == "" {
+		v.add(pointer+"/name", "must not be empty")
+	} else if !ok && node["name"] == nil {
+		v.add(pointer+"/name", "is required")
+	}
+	v.number(node, pointer, "order", 0, math.MaxInt32, true)
+
+	switch level {
+	case LevelDashboard:
+		v.dashboard(node, pointer)
+	case LevelTab:
+		v.appearance(node, pointer)
+		v.background(node, pointer)
+	case LevelGroup:
+		v.appearance(node, pointer)
+		v.boolean(node, pointer, "collapsed")
+		v.enum(node, pointer, "displayStyle", displayStyles)
+	case LevelEntry:
+		v.entry(node, pointer)
+	}
+
+	if child := level.ChildCollection(); child != "" {
+		v.nodes(node, pointer, level+1)
+	}
+}
+
+func (v *validator) dashboard(node map[string]interface{}, pointer string) {
+	if path, ok := v.string(node, pointer, "path"); ok {
+		if !strings.HasPrefix(path, "/") {
+			v.add(pointer+"/path", "must start with /")
+		} else if first, exists := v.paths[path]; exists {
+			v.add(pointer+"/path", "duplicate dashboard path %q (also used at %s)", path, first)
+		} else {
+			v.paths[path] = pointer
+		}
+	} else if node["path"] == nil {
+		v.add(pointer+"/path", "is required")
+	}
+
+	v.background(node, pointer)
+	v.boolean(node, pointer, "perTabBackgrounds")
+	if header := v.object(node, pointer, "header"); header != nil {
+		headerPointer := pointer + "/header"
+		v.string(header, headerPointer, "leftText")
+		v.string(header, headerPointer, "centerTitle")
+		v.boolean(header, headerPointer, "showLeft")
+		v.boolean(header, headerPointer, "showCenter")
+	}
+	if theme := v.object(node, pointer, "theme"); theme != nil {
+		v.string(theme, pointer+"/theme", "color")
+		v.number(theme, pointer+"/theme", "opacity", 0, 1, false)
+	}
+
+	v.enum(node, pointer, "visibility", visibilities)
+	v.stringList(node, pointer, "allowedUsers", nil)
+	v.stringList(node, pointer, "allowedRoles", userRoles)
+}
+
+// appearance validates the styling fields shared by tabs and groups
+func (v *validator) appearance(node map[string]interface{}, pointer string) {
+	v.string(node, pointer, "icon")
+	v.string(node, pointer, "iconUrl")
+	v.string(node, pointer, "color")
+	v.number(node, pointer, "opacity", 0, 1, false)
+	v.enum(node, pointer, "textColor", textColors)
+}
+
+func (v *validator) entry(node map[string]interface{}, pointer string) {
+	if id, ok := node["id"].(string); ok && id != "" {
+		if first, exists := v.entryIDs[id]; exists {
+			v.add(pointer+"/id", "duplicate entry ID %q (also used at %s)", id, first)
+		} else {
+			v.entryIDs[id] = pointer
+		}
+	}
+
+	v.url(node, pointer, "url")
+	v.string(node, pointer, "icon")
+	v.string(node, pointer, "iconUrl")
+	v.string(node, pointer, "description")
+	v.enum(node, pointer, "openMode", openModes)
+	v.enum(node, pointer, "size", entrySizes)
+	v.string(node, pointer, "color")
+	v.number(node, pointer, "opacity", 0, 1, false)
+	v.boolean(node, pointer, "showStatus")
+	v.boolean(node, pointer, "fetchFavicon")
+
+	if check := v.object(node, pointer, "statusCheck"); check != nil {
+		checkPointer := pointer + "/statusCheck"
+		v.enum(check, checkPointer, "type", statusCheckTypes)
+		v.boolean(check, checkPointer, "enabled")
+		v.number(check, checkPointer, "interval", 0, math.MaxInt32, true)
+		v.string(check, checkPointer, "target")
+		v.number(check, checkPointer, "timeout", 0, 300, true)
+	}
+}
+
+func (v *validator) background(node map[string]interface{}, pointer string) {
+	background := v.object(node, pointer, "background")
+	if background == nil {
+		return
+	}
+	pointer += "/background"
+
+	if _, ok := v.string(background, pointer, "type"); !ok && background["type"] == nil {
+		v.add(pointer+"/type", "is required")
+	}
+	v.enum(background, pointer, "type", backgroundTypes)
+	v.string(background, pointer, "value")
+	v.stringList(background, pointer, "images", nil)
+	v.number(background, pointer, "interval", 0, math.MaxInt32, true)
+	v.enum(background, pointer, "fit", backgroundFits)
+	v.enum(background, pointer, "transition", transitions)
+	v.number(background, pointer, "transitionDuration", 0.5, 5, false)
+}
+
+// string returns node[key] if it is a string. A value of any other type is
+// reported; a missing or null value returns false without an error.
+func (v *validator) string(node map[string]interface{}, pointer, key string) (string, bool) {
+	value, ok := node[key]
+	if !ok || value == nil {
+		return "", false
+	}
+	s, ok := value.(string)
+	if !ok {
+		v.add(pointer+"/"+escapeToken(key), "must be a string")
+	}
+	return s, ok
+}
+
+// enum checks that node[key], if set and non-empty, is one of allowed
+func (v *validator) enum(node map[string]interface{}, pointer, key string, allowed []string) {
+	value, ok := v.string(node, pointer, key)
+	if !ok || value == "" || contains(allowed, value) {
+		return
+	}
+	v.add(pointer+"/"+escapeToken(key), "must be one of %s", strings.Join(allowed, ", "))
+}
+
+// stringList checks that node[key], if set, is an array of strings, each one
+// of allowed unless allowed is nil
+func (v *validator) stringList(node map[string]interface{}, pointer, key string, allowed []string) {
+	value, ok := node[key]
+	if !ok || value == nil {
+		return
+	}
+	fieldPointer := pointer + "/" + escapeToken(key)
+	list, ok := value.([]interface{})
+	if !ok {
+		v.add(fieldPointer, "must be an array")
+		return
+	}
+	for i, item := range list {
+		s, ok := item.(string)
+		if !ok {
+			v.add(fieldPointer+"/"+strconv.Itoa(i), "must be a string")
+		} else if allowed != nil && !contains(allowed, s) {
+			v.add(fieldPointer+"/"+strconv.Itoa(i), "must be one of %s", strings.Join(allowed, ", "))
+		}
+	}
+}
+
+// boolean checks that node[key], if set, is a boolean
+func (v *validator) boolean(node map[string]interface{}, pointer, key string) {
+	if value, ok := node[key]; ok && value != nil {
+		if _, ok := value.(bool); !ok {
+			v.add(pointer+"/"+escapeToken(key), "must be a boolean")
+		}
+	}
+}
+
+// number checks that node[key], if set, is a number within [min, max]
+func (v *validator) number(node map[string]interface{}, pointer, key string, min, max float64, integer bool) {
+	value, ok := node[key]
+	if !ok || value == nil {
+		return
+	}
+	fieldPointer := pointer + "/" + escapeToken(key)
+	var n float64
+	switch value := value.(type) {
+	case float64:
+		n = value
+	case int: // set by Renumber
+		n = float64(value)
+	default:
+		ok = false
+	}
+	switch {
+	case !ok:
+		v.add(fieldPointer, "must be a number")
+	case integer && n != math.Trunc(n):
+		v.add(fieldPointer, "must be a whole number")
+	case n < min || n > max:
+		if max == math.MaxInt32 {
+			v.add(fieldPointer, "must be at least %g", min)
+		} else {
+			v.add(fieldPointer, "must be between %g and %g", min, max)
+		}
+	}
+}
+
+// object returns node[key] if it is an object, reporting any other type
+func (v *validator) object(node map[string]interface{}, pointer, key string) map[string]interface{} {
+	value, ok := node[key]
+	if !ok || value == nil {
+		return nil
+	}
+	object, ok := value.(map[string]interface{})
+	if !ok {
+		v.add(pointer+"/"+escapeToken(key), "must be an object")
+	}
+	return object
+}
+
+// blockedSchemes are URL schemes that run code or read local files when
+// opened from a link, so may not be used
+var blockedSchemes = []string{"javascript", "data", "vbscript", "file"}
+
+// url checks that node[key], if set and non-empty, is a URL the frontend can
+// open: an absolute URL, a path starting with /, or a host without a scheme
+// (which the frontend opens over https). Schemes in blockedSchemes are
+// rejected.
+func (v *validator) url(node map[string]interface{}, pointer, key string) {
+	value, ok := v.string(node, pointer, key)
+	value = strings.TrimSpace(value)
+	if !ok || value == "" || strings.HasPrefix(value, "/") {
+		return
+	}
+	if !schemePattern.MatchString(value) {
+		value = "https://" + value
+	}
+
+	u, err := url.Parse(value)
+	switch {
+	case err != nil || ((u.Scheme == "http" || u.Scheme == "https") && u.Host == ""):
+		v.add(pointer+"/"+escapeToken(key), "must be a valid URL")
+	case contains(blockedSchemes, u.Scheme):
+		v.add(pointer+"/"+escapeToken(key), "must not be a %s: URL", u.Scheme)
+	}
+}
+
+// contains reports whether list contains s
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// escapeToken escapes a key for use in a JSON pointer
+func escapeToken(key string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(key, "~", "~0"), "/", "~1")
+}
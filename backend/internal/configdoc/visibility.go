@@ -0,0 +1,57 @@
+package configdoc
+
+// Dashboard visibility values. A dashboard without a visibility is public.
+const (
+	VisibilityPublic        = "public"
+	VisibilityAuthenticated = "authenticated" // any signed-in user
+	VisibilityRestricted    = "restricted"    // the users and roles listed in allowedUsers and allowedRoles
+)
+
+var visibilities = []string{VisibilityPublic, VisibilityAuthenticated, VisibilityRestricted}
+
+// Visible reports whether a reader may see dashboard. username and role are
+// empty for guests. Unknown visibility values are treated as restricted.
+func Visible(dashboard map[string]interface{}, username, role string) bool {
+	visibility, _ := dashboard["visibility"].(string)
+	switch visibility {
+	case "", VisibilityPublic:
+		return true
+	case VisibilityAuthenticated:
+		return username != ""
+	}
+
+	if username == "" {
+		return false
+	}
+	return listContains(dashboard["allowedUsers"], username) || listContains(dashboard["allowedRoles"], role)
+}
+
+// FilterDashboards returns a shallow copy of doc containing only the
+// dashboards for which visible returns true, and the number removed
+func FilterDashboards(doc map[string]interface{}, visible func(dashboard map[string]interface{}) bool) (map[string]interface{}, int) {
+	filtered := make(map[string]interface{}, len(doc))
+	for key, value := range doc {
+		filtered[key] = value
+	}
+
+	dashboards := Items(doc, LevelDashboard.Collection())
+	kept := make([]interface{}, 0, len(dashboards))
+	for _, item := range dashboards {
+		if dashboard, ok := item.(map[string]interface{}); ok && visible(dashboard) {
+			kept = append(kept, item)
+		}
+	}
+	filtered[LevelDashboard.Collection()] = kept
+	return filtered, len(dashboards) - len(kept)
+}
+
+// listContains reports whether list is an array containing the string s
+func listContains(list interface{}, s string) bool {
+	items, _ := list.([]interface{})
+	for _, item := range items {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -60,6 +60,7 @@ type DashyItem struct {
 	URL         string `yaml:"url"`
 	Target      string `yaml:"target"`
 	Tags        []string `yaml:"tags"`
+	Color       string `yaml:"color"`
 }
 
 // ConvertFromHomer converts Homer config to HOPS format
@@ -175,6 +176,7 @@ func ConvertFromDashy(yamlData []byte) ([]byte, error) {
 				Icon:        convertDashyIcon(item.Icon),
 				OpenMode:    "newtab",
 				Size:        "medium",
+				Color:       item.Color,
 				Order:       j,
 			}
 
@@ -273,6 +275,7 @@ func ConvertFromHeimdall(jsonData []byte) ([]byte, error) {
 			Icon:        "mdi:application",
 			OpenMode:    "newtab",
 			Size:        "medium",
+			Color:       item.Colour,
 			Order:       i,
 		}
 
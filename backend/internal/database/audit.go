@@ -0,0 +1,173 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/weaversgrainthorpe/HOPS/internal/models"
+)
+
+// AuditStore records actions taken through the API in the audit_log table
+type AuditStore struct {
+	db *sql.DB
+}
+
+// NewAuditStore creates a new audit store
+func NewAuditStore(db *sql.DB) *AuditStore {
+	return &AuditStore{db: db}
+}
+
+// AuditFilter selects audit log entries. Empty fields match everything.
+type AuditFilter struct {
+	Username string    // exact username
+	Action   string    // exact action, or a prefix such as "config" for config.*
+	Target   string    // exact target
+	Since    time.Time // entries at or after this time
+	Until    time.Time // entries before this time
+}
+
+// Record adds an entry to the audit log
+func (s *AuditStore) Record(entry models.AuditEntry) error {
+	var userID sql.NullInt64
+	if entry.UserID != nil {
+		userID = sql.NullInt64{Int64: int64(*entry.UserID), Valid: true}
+	}
+	_, err := s.db.Exec(`
+		INSERT INTO audit_log (user_id, username, ip, action, target, summary, status, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, userID, entry.Username, entry.IP, entry.Action, entry.Target, entry.Summary, entry.Status, time.Now().UTC())
+	if err != nil {
+		return fmt.Errorf("failed to record audit entry: %w", err)
+	}
+	return nil
+}
+
+// List returns the entries matching filter, newest first, and how many match
+// in total
+func (s *AuditStore) List(filter AuditFilter, limit, offset int) ([]models.AuditEntry, int, error) {
+	var conditions []string
+	var args []interface{}
+	if filter.Username != "" {
+		conditions = append(conditions, "username = ?")
+		args = append(args, filter.Username)
+	}
+	if filter.Action != "" {
+		conditions = append(conditions, "(action = ? OR action LIKE ? ESCAPE '\\')")
+		args = append(args, filter.Action, escapeLike(filter.Action)+".%")
+	}
+	if filter.Target != "" {
+		conditions = append(conditions, "target = ?")
+		args = append(args, filter.Target)
+	}
+	if !filter.Since.IsZero() {
+		conditions = append(conditions, "created_at >= ?")
+		args = append(args, filter.Since.UTC())
+	}
+	if !filter.Until.IsZero() {
+		conditions = append(conditions, "created_at < ?")
+		args = append(args, filter.Until.UTC())
+	}
+	where := ""
+	if len(conditions) > 0 {
+		where = "WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	var total int
+	if err := s.db.QueryRow("SELECT COUNT(*) FROM audit_log "+where, args...).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("failed to count audit log: %w", err)
+	}
+
+	rows, err := s.db.Query(`
+		SELECT id, user_id, username, ip, action, target, summary, status, created_at
+		FROM audit_log `+where+`
+		ORDER BY id DESC
+		LIMIT ? OFFSET ?
+	`, append(args, limit, offset)...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to list audit log: %w", err)
+	}
+	defer rows.Close()
+
+	entries := []models.AuditEntry{}
+	for rows.Next() {
+		var entry models.AuditEntry
+		var userID sql.NullInt64
+		err := rows.Scan(&entry.ID, &userID, &entry.Username, &entry.IP, &entry.Action,
+			&entry.Target, &entry.Summary, &entry.Status, &entry.CreatedAt)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to scan audit log: %w", err)
+		}
+		if userID.Valid {
+			id := int(userID.Int64)
+			entry.UserID = &id
+		}
+		entries = append(entries, entry)
+	}
+	return entries, total, rows.Err()
+}
+
+// Prune deletes entries older than maxAge and all but the newest maxEntries
+// entries. A zero maxAge or maxEntries disables that limit.
+func (s *AuditStore) Prune(maxAge time.Duration, maxEntries int) (int64, error) {
+	var deleted int64
+	if maxAge > 0 {
+		result, err := s.db.Exec("DELETE FROM audit_log WHERE created_at < ?", time.Now().Add(-maxAge).UTC())
+		if err != nil {
+			return 0, fmt.Errorf("failed to prune audit log: %w", err)
+		}
+		n, _ := result.RowsAffected()
+		deleted += n
+	}
+	if maxEntries > 0 {
+		result, err := s.db.Exec(`
+			DELETE FROM audit_log WHERE id <= (
+				SELECT id FROM audit_log ORDER BY id DESC LIMIT 1 OFFSET ?
+			)
+		`, maxEntries)
+		if err != nil {
+			return deleted, fmt.Errorf("failed to prune audit log: %w", err)
+		}
+		n, _ := result.RowsAffected()
+		deleted += n
+	}
+	return deleted, nil
+}
+
+// StartCleanupRoutine prunes the audit log now and then every interval, until
+// stop is closed
+func (s *AuditStore) StartCleanupRoutine(interval, maxAge time.Duration, maxEntries int, stop <-chan struct{}) {
+	if maxAge <= 0 && maxEntries <= 0 {
+		return
+	}
+	prune := func() {
+		deleted, err := s.Prune(maxAge, maxEntries)
+		if err != nil {
+			log.Printf("[Audit] Cleanup error: %v", err)
+		} else if deleted > 0 {
+			log.Printf("[Audit] Removed %d old entries", deleted)
+		}
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		prune()
+		for {
+			select {
+			case <-ticker.C:
+				prune()
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// escapeLike escapes the wildcards of a LIKE pattern
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
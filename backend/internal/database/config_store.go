@@ -0,0 +1,331 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/weaversgrainthorpe/HOPS/internal/configdoc"
+)
+
+// RevisionConflictError is returned when a write was based on a revision of
+// the config that is no longer current
+type RevisionConflictError struct {
+	Expected int64
+	Current  int64
+}
+
+func (e *RevisionConflictError) Error() string {
+	return fmt.Sprintf("config revision conflict: expected %d, current is %d", e.Expected, e.Current)
+}
+
+// Change describes who made a config write and why, for the revision history
+type Change struct {
+	UserID int // 0 if not made by a logged-in user
+	Reason string
+}
+
+// ConfigStore provides transactional access to the single-row config document.
+// The document is handled in its generic JSON form so that fields the server
+// does not model are preserved on every write. Each successful write increments
+// the document's revision and is recorded in config_history. Documents from an
+// older schema version are upgraded (see configdoc.Upgrade) when written.
+type ConfigStore struct {
+	db *sql.DB
+}
+
+// NewConfigStore creates a new config store
+func NewConfigStore(db *sql.DB) *ConfigStore {
+	return &ConfigStore{db: db}
+}
+
+// LoadRaw returns the stored config JSON and its revision
+func (s *ConfigStore) LoadRaw() (string, int64, error) {
+	var data string
+	var revision int64
+	err := s.db.QueryRow("SELECT data, revision FROM config WHERE id = 1").Scan(&data, &revision)
+	if err == sql.ErrNoRows {
+		return "", 0, nil
+	}
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to load config: %w", err)
+	}
+	return data, revision, nil
+}
+
+// Revision returns the current config revision without loading the document
+func (s *ConfigStore) Revision() (int64, error) {
+	var revision int64
+	err := s.db.QueryRow("SELECT revision FROM config WHERE id = 1").Scan(&revision)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to load config revision: %w", err)
+	}
+	return revision, nil
+}
+
+// Load returns the current config document and its revision
+func (s *ConfigStore) Load() (map[string]interface{}, int64, error) {
+	data, revision, err := s.LoadRaw()
+	if err != nil {
+		return nil, 0, err
+	}
+	doc, err := decodeConfig(data)
+	if err != nil {
+		return nil, 0, err
+	}
+	return doc, revision, nil
+}
+
+// Update loads the config document inside a transaction, passes it to fn for
+// modification and saves the result under the next revision, which is
+// returned and recorded in the history together with change. If
+// expectedRevision is non-zero and does not match the stored revision a
+// *RevisionConflictError is returned. If fn returns an error the transaction
+// is rolled back and that error is returned unchanged.
+//
+// fn runs while the database connection is held by the transaction, so it
+// must not query the database itself.
+func (s *ConfigStore) Update(expectedRevision int64, change Change, fn func(doc map[string]interface{}) error) (int64, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	revision, err := updateConfig(tx, expectedRevision, change, fn)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit config: %w", err)
+	}
+	return revision, nil
+}
+
+// updateConfig performs an Update within an existing transaction. Documents
+// from an older schema version are upgraded after fn has run.
+func updateConfig(tx *sql.Tx, expectedRevision int64, change Change, fn func(doc map[string]interface{}) error) (int64, error) {
+	var data string
+	var revision int64
+	err := tx.QueryRow("SELECT data, revision FROM config WHERE id = 1").Scan(&data, &revision)
+	if err != nil && err != sql.ErrNoRows {
+		return 0, fmt.Errorf("failed to load config: %w", err)
+	}
+
+	if expectedRevision != 0 && expectedRevision != revision {
+		return 0, &RevisionConflictError{Expected: expectedRevision, Current: revision}
+	}
+
+	doc, err := decodeConfig(data)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := fn(doc); err != nil {
+		return 0, err
+	}
+	if _, err := configdoc.Upgrade(doc); err != nil {
+		return 0, err
+	}
+
+	encoded, err := json.Marshal(doc)
+	if err != nil {
+		return 0, fmt.Errorf("failed to encode config: %w", err)
+	}
+
+	revision++
+	_, err = tx.Exec(
+		"INSERT OR REPLACE INTO config (id, data, revision, updated_at) VALUES (1, ?, ?, CURRENT_TIMESTAMP)",
+		string(encoded), revision,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to save config: %w", err)
+	}
+
+	var userID sql.NullInt64
+	if change.UserID != 0 {
+		userID = sql.NullInt64{Int64: int64(change.UserID), Valid: true}
+	}
+	_, err = tx.Exec(
+		"INSERT INTO config_history (revision, data, user_id, reason) VALUES (?, ?, ?, ?)",
+		revision, string(encoded), userID, change.Reason,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to record config history: %w", err)
+	}
+
+	return revision, nil
+}
+
+// Replace stores doc as the new config document, subject to the same
+// revision check as Update
+func (s *ConfigStore) Replace(expectedRevision int64, change Change, doc map[string]interface{}) (int64, error) {
+	return s.Update(expectedRevision, change, func(current map[string]interface{}) error {
+		for key := range current {
+			delete(current, key)
+		}
+		for key, value := range doc {
+			current[key] = value
+		}
+		return nil
+	})
+}
+
+// HistoryEntry describes a recorded config revision
+type HistoryEntry struct {
+	Revision  int64     `json:"revision"`
+	UserID    *int      `json:"userId,omitempty"`
+	Username  string    `json:"username,omitempty"`
+	Reason    string    `json:"reason"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// ListHistory returns recorded revisions, newest first, and the total count
+func (s *ConfigStore) ListHistory(limit, offset int) ([]HistoryEntry, int, error) {
+	var total int
+	if err := s.db.QueryRow("SELECT COUNT(*) FROM config_history").Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("failed to count history: %w", err)
+	}
+
+	rows, err := s.db.Query(`
+		SELECT h.revision, h.user_id, u.username, h.reason, h.created_at
+		FROM config_history h
+		LEFT JOIN users u ON u.id = h.user_id
+		ORDER BY h.revision DESC
+		LIMIT ? OFFSET ?
+	`, limit, offset)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to list history: %w", err)
+	}
+	defer rows.Close()
+
+	entries := []HistoryEntry{}
+	for rows.Next() {
+		var entry HistoryEntry
+		var userID sql.NullInt64
+		var username sql.NullString
+		if err := rows.Scan(&entry.Revision, &userID, &username, &entry.Reason, &entry.CreatedAt); err != nil {
+			return nil, 0, fmt.Errorf("failed to scan history: %w", err)
+		}
+		if userID.Valid {
+			id := int(userID.Int64)
+			entry.UserID = &id
+		}
+		entry.Username = username.String
+		entries = append(entries, entry)
+	}
+	return entries, total, rows.Err()
+}
+
+// LoadRevision returns the config document recorded for a revision and its
+// history entry, or sql.ErrNoRows if the revision is unknown
+func (s *ConfigStore) LoadRevision(revision int64) (map[string]interface{}, *HistoryEntry, error) {
+	var data string
+	var userID sql.NullInt64
+	var username sql.NullString
+	entry := &HistoryEntry{Revision: revision}
+	err := s.db.QueryRow(`
+		SELECT h.data, h.user_id, u.username, h.reason, h.created_at
+		FROM config_history h
+		LEFT JOIN users u ON u.id = h.user_id
+		WHERE h.revision = ?
+	`, revision).Scan(&data, &userID, &username, &entry.Reason, &entry.CreatedAt)
+	if err != nil {
+		return nil, nil, err
+	}
+	if userID.Valid {
+		id := int(userID.Int64)
+		entry.UserID = &id
+	}
+	entry.Username = username.String
+
+	doc, err := decodeConfig(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	return doc, entry, nil
+}
+
+// PruneHistory deletes revisions older than maxAge and all but the newest
+// maxEntries revisions. The current revision is always kept. A zero maxAge or
+// maxEntries disables that limit.
+func (s *ConfigStore) PruneHistory(maxAge time.Duration, maxEntries int) (int64, error) {
+	var deleted int64
+	if maxAge > 0 {
+		result, err := s.db.Exec(`
+			DELETE FROM config_history
+			WHERE created_at < ? AND revision < (SELECT COALESCE(MAX(revision), 0) FROM config)
+		`, time.Now().Add(-maxAge).UTC())
+		if err != nil {
+			return 0, fmt.Errorf("failed to prune config history: %w", err)
+		}
+		n, _ := result.RowsAffected()
+		deleted += n
+	}
+	if maxEntries > 0 {
+		result, err := s.db.Exec(`
+			DELETE FROM config_history WHERE revision <= (
+				SELECT revision FROM config_history ORDER BY revision DESC LIMIT 1 OFFSET ?
+			)
+		`, maxEntries)
+		if err != nil {
+			return deleted, fmt.Errorf("failed to prune config history: %w", err)
+		}
+		n, _ := result.RowsAffected()
+		deleted += n
+	}
+	return deleted, nil
+}
+
+// StartHistoryCleanupRoutine prunes the config history now and then every
+// interval, until stop is closed
+func (s *ConfigStore) StartHistoryCleanupRoutine(interval, maxAge time.Duration, maxEntries int, stop <-chan struct{}) {
+	if maxAge <= 0 && maxEntries <= 0 {
+		return
+	}
+	prune := func() {
+		deleted, err := s.PruneHistory(maxAge, maxEntries)
+		if err != nil {
+			log.Printf("[Config] History cleanup error: %v", err)
+		} else if deleted > 0 {
+			log.Printf("[Config] Removed %d old revisions from the history", deleted)
+		}
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		prune()
+		for {
+			select {
+			case <-ticker.C:
+				prune()
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// decodeConfig parses a stored config document, treating an empty string as
+// an empty config
+func decodeConfig(data string) (map[string]interface{}, error) {
+	if data == "" {
+		return map[string]interface{}{"dashboards": []interface{}{}}, nil
+	}
+	var doc map[string]interface{}
+	if err := json.Unmarshal([]byte(data), &doc); err != nil {
+		return nil, fmt.Errorf("failed to parse config: %w", err)
+	}
+	if doc == nil {
+		doc = make(map[string]interface{})
+	}
+	return doc, nil
+}
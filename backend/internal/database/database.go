@@ -53,80 +53,10 @@ func Initialize(dbPath string) (*sql.DB, error) {
 	return db, nil
 }
 
-// runMigrations executes database migrations
+// runMigrations applies schema migrations and seeds the default data
 func runMigrations(db *sql.DB) error {
-	migrations := []string{
-		// Users table for admin accounts
-		`CREATE TABLE IF NOT EXISTS users (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			username TEXT UNIQUE NOT NULL,
-			password_hash TEXT NOT NULL,
-			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
-		)`,
-
-		// Sessions table for authentication
-		`CREATE TABLE IF NOT EXISTS sessions (
-			id TEXT PRIMARY KEY,
-			user_id INTEGER NOT NULL,
-			expires_at DATETIME NOT NULL,
-			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-			FOREIGN KEY (user_id) REFERENCES users(id)
-		)`,
-
-		// Config table for dashboard configurations
-		`CREATE TABLE IF NOT EXISTS config (
-			id INTEGER PRIMARY KEY CHECK (id = 1),
-			data TEXT NOT NULL,
-			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
-		)`,
-
-		// Status cache for HTTP/ICMP checks
-		`CREATE TABLE IF NOT EXISTS status_cache (
-			entry_id TEXT PRIMARY KEY,
-			status TEXT NOT NULL,
-			response_time INTEGER,
-			last_checked DATETIME DEFAULT CURRENT_TIMESTAMP
-		)`,
-
-		// Secrets table for secret dashboard URLs (reserved for future use)
-		`CREATE TABLE IF NOT EXISTS secrets (
-			id TEXT PRIMARY KEY,
-			dashboard_id TEXT NOT NULL,
-			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
-		)`,
-
-		// Icon categories table
-		`CREATE TABLE IF NOT EXISTS icon_categories (
-			id TEXT PRIMARY KEY,
-			name TEXT NOT NULL,
-			icon TEXT NOT NULL,
-			order_num INTEGER NOT NULL,
-			is_preset BOOLEAN NOT NULL DEFAULT 0,
-			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
-		)`,
-
-		// Icons table
-		`CREATE TABLE IF NOT EXISTS icons (
-			id TEXT PRIMARY KEY,
-			name TEXT NOT NULL,
-			icon TEXT NOT NULL,
-			category_id TEXT NOT NULL,
-			color TEXT,
-			image_url TEXT,
-			is_preset BOOLEAN NOT NULL DEFAULT 0,
-			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-			FOREIGN KEY (category_id) REFERENCES icon_categories(id) ON DELETE CASCADE
-		)`,
-
-		// Index for faster category lookups
-		`CREATE INDEX IF NOT EXISTS idx_icons_category ON icons(category_id)`,
-		`CREATE INDEX IF NOT EXISTS idx_icons_preset ON icons(is_preset)`,
-	}
-
-	for _, migration := range migrations {
-		if _, err := db.Exec(migration); err != nil {
-			return fmt.Errorf("migration failed: %w", err)
-		}
+	if err := applyMigrations(db); err != nil {
+		return err
 	}
 
 	// Create default admin user if none exists
@@ -137,10 +67,13 @@ func runMigrations(db *sql.DB) error {
 	}
 
 	if count == 0 {
-		// Default password: "admin" - should be changed on first login
+		// Default password: "admin" - must be changed on first login
 		// This is bcrypt hash of "admin"
 		defaultHash := "$2a$10$trkEbQD4PIkE23o.7Gn4TOBCOYo48m70IlqFpJZH98JcIi1s6oeTG"
-		_, err := db.Exec("INSERT INTO users (username, password_hash) VALUES (?, ?)", "admin", defaultHash)
+		_, err := db.Exec(
+			"INSERT INTO users (username, password_hash, role, must_change_password) VALUES (?, ?, ?, 1)",
+			"admin", defaultHash, "admin",
+		)
 		if err != nil {
 			return fmt.Errorf("failed to create default admin user: %w", err)
 		}
@@ -160,6 +93,7 @@ func runMigrations(db *sql.DB) error {
 
 	if configCount == 0 {
 		defaultConfig := `{
+  "schemaVersion": 1,
   "dashboards": [
     {
       "id": "sample",
@@ -295,5 +229,14 @@ func runMigrations(db *sql.DB) error {
 		}
 	}
 
+	// Record the current config as the first history entry if there is none yet
+	_, err = db.Exec(`
+		INSERT OR IGNORE INTO config_history (revision, data, reason)
+		SELECT revision, data, 'initial' FROM config WHERE id = 1
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to record initial config history: %w", err)
+	}
+
 	return nil
 }
@@ -0,0 +1,483 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+
+	"github.com/weaversgrainthorpe/HOPS/internal/configdoc"
+	"github.com/weaversgrainthorpe/HOPS/internal/models"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// defaultAdminPassword is the password of the admin account HOPS creates on
+// first run
+const defaultAdminPassword = "admin"
+
+// migration is a numbered schema change. Exactly one of sql and fn is set.
+// Migrations are applied in order, each in its own transaction, and recorded
+// in schema_migrations. Never edit or reorder a released migration; append a
+// new one instead.
+type migration struct {
+	version     int
+	description string
+	sql         string
+	fn          func(tx *sql.Tx) error
+}
+
+// migrations lists every schema change. The early ones use IF NOT EXISTS and
+// column checks because databases created before schema_migrations existed
+// may already contain their changes.
+var migrations = []migration{
+	{
+		version:     1,
+		description: "initial schema",
+		sql: `
+			-- Users table for admin accounts
+			CREATE TABLE IF NOT EXISTS users (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				username TEXT UNIQUE NOT NULL,
+				password_hash TEXT NOT NULL,
+				created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+			);
+
+			-- Sessions table for authentication
+			CREATE TABLE IF NOT EXISTS sessions (
+				id TEXT PRIMARY KEY,
+				user_id INTEGER NOT NULL,
+				expires_at DATETIME NOT NULL,
+				created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+				FOREIGN KEY (user_id) REFERENCES users(id)
+			);
+
+			-- Config table for dashboard configurations
+			CREATE TABLE IF NOT EXISTS config (
+				id INTEGER PRIMARY KEY CHECK (id = 1),
+				data TEXT NOT NULL,
+				updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+			);
+
+			-- Status cache for HTTP/ICMP checks
+			CREATE TABLE IF NOT EXISTS status_cache (
+				entry_id TEXT PRIMARY KEY,
+				status TEXT NOT NULL,
+				response_time INTEGER,
+				last_checked DATETIME DEFAULT CURRENT_TIMESTAMP
+			);
+
+			-- Secrets table for secret dashboard URLs (reserved for future use)
+			CREATE TABLE IF NOT EXISTS secrets (
+				id TEXT PRIMARY KEY,
+				dashboard_id TEXT NOT NULL,
+				created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+			);
+
+			-- Icon categories table
+			CREATE TABLE IF NOT EXISTS icon_categories (
+				id TEXT PRIMARY KEY,
+				name TEXT NOT NULL,
+				icon TEXT NOT NULL,
+				order_num INTEGER NOT NULL,
+				is_preset BOOLEAN NOT NULL DEFAULT 0,
+				created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+			);
+
+			-- Icons table
+			CREATE TABLE IF NOT EXISTS icons (
+				id TEXT PRIMARY KEY,
+				name TEXT NOT NULL,
+				icon TEXT NOT NULL,
+				category_id TEXT NOT NULL,
+				color TEXT,
+				image_url TEXT,
+				is_preset BOOLEAN NOT NULL DEFAULT 0,
+				created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+				FOREIGN KEY (category_id) REFERENCES icon_categories(id) ON DELETE CASCADE
+			);
+
+			-- Index for faster category lookups
+			CREATE INDEX IF NOT EXISTS idx_icons_category ON icons(category_id);
+			CREATE INDEX IF NOT EXISTS idx_icons_preset ON icons(is_preset);
+		`,
+	},
+	{
+		version:     2,
+		description: "config revision number",
+		fn: func(tx *sql.Tx) error {
+			return addColumnIfMissing(tx, "config", "revision", "INTEGER NOT NULL DEFAULT 1")
+		},
+	},
+	{
+		version:     3,
+		description: "config revision history",
+		sql: `
+			-- Every saved revision of the config document
+			CREATE TABLE IF NOT EXISTS config_history (
+				revision INTEGER PRIMARY KEY,
+				data TEXT NOT NULL,
+				user_id INTEGER,
+				reason TEXT NOT NULL DEFAULT '',
+				created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+			);
+		`,
+	},
+	{
+		version:     4,
+		description: "config document schema version 1",
+		fn:          upgradeConfigDocument,
+	},
+	{
+		version:     5,
+		description: "user roles",
+		// Existing users had full access, so they become admins. New users
+		// get the least access unless a role is given.
+		sql: `
+			ALTER TABLE users ADD COLUMN role TEXT NOT NULL DEFAULT 'viewer';
+			UPDATE users SET role = 'admin';
+		`,
+	},
+	{
+		version:     6,
+		description: "dashboard share links",
+		// The secrets table was reserved for secret dashboard URLs. A link's
+		// ID is the SHA-256 hash of its token, so that a copy of the database
+		// does not give working links; prefix tells links apart.
+		sql: `
+			ALTER TABLE secrets ADD COLUMN label TEXT NOT NULL DEFAULT '';
+			ALTER TABLE secrets ADD COLUMN expires_at DATETIME;
+			ALTER TABLE secrets ADD COLUMN revoked_at DATETIME;
+			ALTER TABLE secrets ADD COLUMN created_by INTEGER;
+			ALTER TABLE secrets ADD COLUMN prefix TEXT NOT NULL DEFAULT '';
+		`,
+	},
+	{
+		version:     7,
+		description: "API tokens",
+		sql: `
+			-- Long-lived tokens for scripts, stored as SHA-256 hashes
+			CREATE TABLE api_tokens (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				name TEXT NOT NULL,
+				token_hash TEXT UNIQUE NOT NULL,
+				prefix TEXT NOT NULL,
+				scopes TEXT NOT NULL,
+				user_id INTEGER NOT NULL,
+				expires_at DATETIME,
+				last_used_at DATETIME,
+				created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+				FOREIGN KEY (user_id) REFERENCES users(id)
+			);
+		`,
+	},
+	{
+		version:     8,
+		description: "OpenID Connect users",
+		sql: `
+			-- The provider's subject identifier for users who sign in with OpenID Connect
+			ALTER TABLE users ADD COLUMN oidc_subject TEXT;
+			CREATE UNIQUE INDEX idx_users_oidc_subject ON users(oidc_subject);
+		`,
+	},
+	{
+		version:     9,
+		description: "TOTP two-factor authentication",
+		sql: `
+			-- The TOTP secret is set on enrolment and only required at login
+			-- once totp_enabled is set; totp_last_step stops codes being reused
+			ALTER TABLE users ADD COLUMN totp_secret TEXT;
+			ALTER TABLE users ADD COLUMN totp_enabled INTEGER NOT NULL DEFAULT 0;
+			ALTER TABLE users ADD COLUMN totp_last_step INTEGER NOT NULL DEFAULT 0;
+
+			-- One-time recovery codes, stored as SHA-256 hashes
+			CREATE TABLE totp_recovery_codes (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				user_id INTEGER NOT NULL,
+				code_hash TEXT NOT NULL,
+				used_at DATETIME,
+				FOREIGN KEY (user_id) REFERENCES users(id)
+			);
+			CREATE INDEX idx_totp_recovery_codes_user ON totp_recovery_codes(user_id);
+		`,
+	},
+	{
+		version:     10,
+		description: "session activity",
+		sql: `
+			-- Where each session was created from and when it was last used,
+			-- for listing sessions and expiring idle ones
+			ALTER TABLE sessions ADD COLUMN last_seen_at DATETIME;
+			ALTER TABLE sessions ADD COLUMN ip TEXT NOT NULL DEFAULT '';
+			ALTER TABLE sessions ADD COLUMN user_agent TEXT NOT NULL DEFAULT '';
+			CREATE INDEX idx_sessions_user ON sessions(user_id);
+		`,
+	},
+	{
+		version:     11,
+		description: "password changes and history",
+		fn:          addPasswordHistory,
+	},
+	{
+		version:     12,
+		description: "audit log",
+		sql: `
+			-- Who did what, from where. The username is kept as it was so
+			-- entries survive the user being renamed or deleted.
+			CREATE TABLE audit_log (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				user_id INTEGER,
+				username TEXT NOT NULL DEFAULT '',
+				ip TEXT NOT NULL DEFAULT '',
+				action TEXT NOT NULL,
+				target TEXT NOT NULL DEFAULT '',
+				summary TEXT NOT NULL DEFAULT '',
+				status INTEGER NOT NULL DEFAULT 0,
+				created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+			);
+			CREATE INDEX idx_audit_log_created ON audit_log(created_at);
+			CREATE INDEX idx_audit_log_action ON audit_log(action);
+		`,
+	},
+	{
+		version:     13,
+		description: "login lockouts",
+		sql: `
+			-- Recent failed logins per username, including unknown ones, so
+			-- lockouts survive restarts
+			CREATE TABLE login_failures (
+				username TEXT PRIMARY KEY,
+				failures INTEGER NOT NULL DEFAULT 0,
+				last_failure_at DATETIME NOT NULL,
+				locked_until DATETIME
+			);
+		`,
+	},
+	{
+		version:     14,
+		description: "status history",
+		sql: `
+			-- Every status check result, kept for a short time for latency
+			-- percentiles and detailed series
+			CREATE TABLE status_history (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				entry_id TEXT NOT NULL,
+				status TEXT NOT NULL,
+				response_time INTEGER,
+				checked_at DATETIME NOT NULL
+			);
+			CREATE INDEX idx_status_history_entry ON status_history(entry_id, checked_at);
+			CREATE INDEX idx_status_history_checked ON status_history(checked_at);
+
+			-- Check results per entry and hour, kept for longer for uptime.
+			-- Response times are of successful checks only.
+			CREATE TABLE status_history_hourly (
+				entry_id TEXT NOT NULL,
+				hour DATETIME NOT NULL,
+				checks INTEGER NOT NULL DEFAULT 0,
+				up INTEGER NOT NULL DEFAULT 0,
+				response_time_total INTEGER NOT NULL DEFAULT 0,
+				response_time_count INTEGER NOT NULL DEFAULT 0,
+				PRIMARY KEY (entry_id, hour)
+			);
+			CREATE INDEX idx_status_history_hourly_hour ON status_history_hourly(hour);
+		`,
+	},
+	{
+		version:     15,
+		description: "status notifications",
+		sql: `
+			-- Where status changes are sent. Settings are a JSON object whose
+			-- keys depend on the type. Entry and group IDs are comma-separated;
+			-- a channel with neither gets every entry.
+			CREATE TABLE notification_channels (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				name TEXT NOT NULL,
+				type TEXT NOT NULL,
+				settings TEXT NOT NULL DEFAULT '{}',
+				entry_ids TEXT NOT NULL DEFAULT '',
+				group_ids TEXT NOT NULL DEFAULT '',
+				notify_recovery BOOLEAN NOT NULL DEFAULT 1,
+				enabled BOOLEAN NOT NULL DEFAULT 1,
+				created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+			);
+
+			-- The last status notified per entry, so restarts neither repeat
+			-- nor miss notifications
+			CREATE TABLE notification_state (
+				entry_id TEXT PRIMARY KEY,
+				status TEXT NOT NULL,
+				changed_at DATETIME NOT NULL
+			);
+		`,
+	},
+}
+
+// applyMigrations brings the database schema up to date. It refuses to run
+// against a database that has migrations this version of HOPS does not know.
+func applyMigrations(db *sql.DB) error {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		description TEXT NOT NULL,
+		applied_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	)`)
+	if err != nil {
+		return fmt.Errorf("failed to create schema_migrations table: %w", err)
+	}
+
+	var current int
+	if err := db.QueryRow("SELECT COALESCE(MAX(version), 0) FROM schema_migrations").Scan(&current); err != nil {
+		return fmt.Errorf("failed to read schema version: %w", err)
+	}
+
+	latest := migrations[len(migrations)-1].version
+	if current > latest {
+		return fmt.Errorf("database schema version %d is newer than this version of HOPS supports (%d); upgrade HOPS or restore a backup", current, latest)
+	}
+
+	for _, m := range migrations {
+		if m.version <= current {
+			continue
+		}
+		if err := applyMigration(db, m); err != nil {
+			return fmt.Errorf("migration %d (%s) failed: %w", m.version, m.description, err)
+		}
+		log.Printf("[Database] Applied migration %d: %s", m.version, m.description)
+	}
+	return nil
+}
+
+// applyMigration runs a single migration and records it in one transaction
+func applyMigration(db *sql.DB, m migration) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if m.fn != nil {
+		err = m.fn(tx)
+	} else {
+		_, err = tx.Exec(m.sql)
+	}
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec("INSERT INTO schema_migrations (version, description) VALUES (?, ?)", m.version, m.description); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// addColumnIfMissing adds a column to an existing table if it is not already present
+func addColumnIfMissing(tx *sql.Tx, table, column, definition string) error {
+	rows, err := tx.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, colType string
+		var defaultValue sql.NullString
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &defaultValue, &pk); err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+
+	_, err = tx.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	return err
+}
+
+// addPasswordHistory adds the must_change_password flag and the password
+// history table, and flags accounts still using the default password
+func addPasswordHistory(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+		ALTER TABLE users ADD COLUMN must_change_password INTEGER NOT NULL DEFAULT 0;
+
+		-- Hashes of users' previous passwords, which cannot be reused
+		CREATE TABLE password_history (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			password_hash TEXT NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (user_id) REFERENCES users(id)
+		);
+		CREATE INDEX idx_password_history_user ON password_history(user_id);
+	`)
+	if err != nil {
+		return err
+	}
+
+	rows, err := tx.Query("SELECT id, password_hash FROM users")
+	if err != nil {
+		return err
+	}
+	var flagged []int
+	for rows.Next() {
+		var id int
+		var hash string
+		if err := rows.Scan(&id, &hash); err != nil {
+			rows.Close()
+			return err
+		}
+		if bcrypt.CompareHashAndPassword([]byte(hash), []byte(defaultAdminPassword)) == nil {
+			flagged = append(flagged, id)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, id := range flagged {
+		if _, err := tx.Exec("UPDATE users SET must_change_password = 1 WHERE id = ?", id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// upgradeConfigDocument upgrades the stored config document to
+// models.CurrentSchemaVersion, saving the result as a new revision. Add a
+// migration that calls it whenever CurrentSchemaVersion is increased.
+func upgradeConfigDocument(tx *sql.Tx) error {
+	var data string
+	err := tx.QueryRow("SELECT data FROM config WHERE id = 1").Scan(&data)
+	if err == sql.ErrNoRows {
+		return nil // the default config is created at the current version
+	}
+	if err != nil {
+		return err
+	}
+
+	doc, err := decodeConfig(data)
+	if err != nil {
+		return err
+	}
+	if configdoc.SchemaVersion(doc) >= models.CurrentSchemaVersion {
+		return nil
+	}
+
+	// Keep the document as it was before the upgrade in the history
+	_, err = tx.Exec(`
+		INSERT OR IGNORE INTO config_history (revision, data, reason)
+		SELECT revision, data, 'initial' FROM config WHERE id = 1
+	`)
+	if err != nil {
+		return err
+	}
+
+	reason := fmt.Sprintf("upgrade to schema version %d", models.CurrentSchemaVersion)
+	_, err = updateConfig(tx, 0, Change{Reason: reason}, func(doc map[string]interface{}) error {
+		return nil // updateConfig upgrades the document
+	})
+	return err
+}
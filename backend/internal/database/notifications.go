@@ -0,0 +1,179 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/weaversgrainthorpe/HOPS/internal/models"
+)
+
+// ErrNotificationChannelNotFound is returned for channels that do not exist
+var ErrNotificationChannelNotFound = errors.New("notification channel not found")
+
+// NotificationStore manages notification channels, and the last status
+// notified for each entry
+type NotificationStore struct {
+	db *sql.DB
+}
+
+// NewNotificationStore creates a new notification store
+func NewNotificationStore(db *sql.DB) *NotificationStore {
+	return &NotificationStore{db: db}
+}
+
+// List returns all notification channels, oldest first
+func (s *NotificationStore) List() ([]models.NotificationChannel, error) {
+	rows, err := s.db.Query(`
+		SELECT id, name, type, settings, entry_ids, group_ids, notify_recovery, enabled, created_at
+		FROM notification_channels ORDER BY id
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list notification channels: %w", err)
+	}
+	defer rows.Close()
+
+	channels := []models.NotificationChannel{}
+	for rows.Next() {
+		channel, err := scanNotificationChannel(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list notification channels: %w", err)
+		}
+		channels = append(channels, *channel)
+	}
+	return channels, rows.Err()
+}
+
+// Get returns a notification channel
+func (s *NotificationStore) Get(id int) (*models.NotificationChannel, error) {
+	row := s.db.QueryRow(`
+		SELECT id, name, type, settings, entry_ids, group_ids, notify_recovery, enabled, created_at
+		FROM notification_channels WHERE id = ?
+	`, id)
+	channel, err := scanNotificationChannel(row)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotificationChannelNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+	return channel, nil
+}
+
+// Create adds a notification channel. The channel's ID and CreatedAt are
+// ignored.
+func (s *NotificationStore) Create(channel models.NotificationChannel) (*models.NotificationChannel, error) {
+	settings, err := json.Marshal(channel.Settings)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode settings: %w", err)
+	}
+	result, err := s.db.Exec(`
+		INSERT INTO notification_channels (name, type, settings, entry_ids, group_ids, notify_recovery, enabled)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, channel.Name, channel.Type, string(settings), strings.Join(channel.EntryIDs, ","),
+		strings.Join(channel.GroupIDs, ","), channel.NotifyRecovery, channel.Enabled)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create notification channel: %w", err)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+	return s.Get(int(id))
+}
+
+// Update replaces a notification channel's name, type, settings, routing and
+// flags
+func (s *NotificationStore) Update(channel models.NotificationChannel) (*models.NotificationChannel, error) {
+	settings, err := json.Marshal(channel.Settings)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode settings: %w", err)
+	}
+	result, err := s.db.Exec(`
+		UPDATE notification_channels
+		SET name = ?, type = ?, settings = ?, entry_ids = ?, group_ids = ?, notify_recovery = ?, enabled = ?
+		WHERE id = ?
+	`, channel.Name, channel.Type, string(settings), strings.Join(channel.EntryIDs, ","),
+		strings.Join(channel.GroupIDs, ","), channel.NotifyRecovery, channel.Enabled, channel.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update notification channel: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return nil, ErrNotificationChannelNotFound
+	}
+	return s.Get(channel.ID)
+}
+
+// Delete removes a notification channel
+func (s *NotificationStore) Delete(id int) error {
+	result, err := s.db.Exec("DELETE FROM notification_channels WHERE id = ?", id)
+	if err != nil {
+		return fmt.Errorf("failed to delete notification channel: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrNotificationChannelNotFound
+	}
+	return nil
+}
+
+// States returns the last status notified for each entry
+func (s *NotificationStore) States() (map[string]string, error) {
+	rows, err := s.db.Query("SELECT entry_id, status FROM notification_state")
+	if err != nil {
+		return nil, fmt.Errorf("failed to load notification state: %w", err)
+	}
+	defer rows.Close()
+
+	states := make(map[string]string)
+	for rows.Next() {
+		var entryID, status string
+		if err := rows.Scan(&entryID, &status); err != nil {
+			return nil, fmt.Errorf("failed to load notification state: %w", err)
+		}
+		states[entryID] = status
+	}
+	return states, rows.Err()
+}
+
+// SetState records the status last notified for an entry
+func (s *NotificationStore) SetState(entryID, status string, changedAt time.Time) error {
+	_, err := s.db.Exec(`
+		INSERT INTO notification_state (entry_id, status, changed_at) VALUES (?, ?, ?)
+		ON CONFLICT(entry_id) DO UPDATE SET status = excluded.status, changed_at = excluded.changed_at
+	`, entryID, status, changedAt.UTC())
+	if err != nil {
+		return fmt.Errorf("failed to save notification state: %w", err)
+	}
+	return nil
+}
+
+// scanNotificationChannel reads a channel selected by Get or List
+func scanNotificationChannel(row interface{ Scan(...interface{}) error }) (*models.NotificationChannel, error) {
+	var channel models.NotificationChannel
+	var settings, entryIDs, groupIDs string
+	err := row.Scan(&channel.ID, &channel.Name, &channel.Type, &settings, &entryIDs, &groupIDs,
+		&channel.NotifyRecovery, &channel.Enabled, &channel.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(settings), &channel.Settings); err != nil {
+		return nil, fmt.Errorf("invalid settings for notification channel %d: %w", channel.ID, err)
+	}
+	if channel.Settings == nil {
+		channel.Settings = map[string]string{}
+	}
+	channel.EntryIDs = splitIDs(entryIDs)
+	channel.GroupIDs = splitIDs(groupIDs)
+	return &channel, nil
+}
+
+// splitIDs splits a comma-separated list, returning an empty slice for ""
+func splitIDs(ids string) []string {
+	if ids == "" {
+		return []string{}
+	}
+	return strings.Split(ids, ",")
+}
@@ -0,0 +1,167 @@
+package database
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/weaversgrainthorpe/HOPS/internal/models"
+)
+
+// ErrShareNotFound is returned for share links that do not exist, have been
+// revoked or have expired
+var ErrShareNotFound = errors.New("share link not found")
+
+// sharePrefixLength is how many characters of a share token are kept to tell
+// links apart
+const sharePrefixLength = 8
+
+// ShareStore manages share links, which are kept in the secrets table. Only
+// the SHA-256 hash of each token is stored, as the link's ID.
+type ShareStore struct {
+	db *sql.DB
+}
+
+// NewShareStore creates a new share store
+func NewShareStore(db *sql.DB) *ShareStore {
+	return &ShareStore{db: db}
+}
+
+// Create adds a share link for a dashboard. expiresAt may be nil for a link
+// that does not expire. The returned link is the only one with its Token set.
+func (s *ShareStore) Create(dashboardID, label string, expiresAt *time.Time, createdBy int) (*models.ShareLink, error) {
+	token, err := generateShareToken()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate token: %w", err)
+	}
+
+	var creator sql.NullInt64
+	if createdBy != 0 {
+		creator = sql.NullInt64{Int64: int64(createdBy), Valid: true}
+	}
+	var expires sql.NullTime
+	if expiresAt != nil {
+		expires = sql.NullTime{Time: expiresAt.UTC(), Valid: true}
+	}
+
+	id := hashShareToken(token)
+	_, err = s.db.Exec(
+		"INSERT INTO secrets (id, prefix, dashboard_id, label, expires_at, created_by) VALUES (?, ?, ?, ?, ?, ?)",
+		id, token[:sharePrefixLength], dashboardID, label, expires, creator,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create share link: %w", err)
+	}
+	link, err := s.get(id)
+	if err != nil {
+		return nil, err
+	}
+	link.Token = token
+	return link, nil
+}
+
+// List returns all share links, including revoked and expired ones, newest first
+func (s *ShareStore) List() ([]models.ShareLink, error) {
+	rows, err := s.db.Query(`
+		SELECT id, prefix, dashboard_id, label, expires_at, revoked_at, created_by, created_at
+		FROM secrets ORDER BY created_at DESC, rowid DESC
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list share links: %w", err)
+	}
+	defer rows.Close()
+
+	links := []models.ShareLink{}
+	for rows.Next() {
+		link, err := scanShareLink(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list share links: %w", err)
+		}
+		links = append(links, *link)
+	}
+	return links, rows.Err()
+}
+
+// Lookup returns the share link for token if it can still be used
+func (s *ShareStore) Lookup(token string) (*models.ShareLink, error) {
+	link, err := s.get(hashShareToken(token))
+	if err != nil {
+		return nil, err
+	}
+	if link.RevokedAt != nil || (link.ExpiresAt != nil && time.Now().After(*link.ExpiresAt)) {
+		return nil, ErrShareNotFound
+	}
+	return link, nil
+}
+
+// Revoke disables the share link with the given ID. Revoked links stay in the
+// list.
+func (s *ShareStore) Revoke(id string) error {
+	result, err := s.db.Exec("UPDATE secrets SET revoked_at = ? WHERE id = ? AND revoked_at IS NULL", time.Now().UTC().Truncate(time.Second), id)
+	if err != nil {
+		return fmt.Errorf("failed to revoke share link: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		if _, err := s.get(id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// get returns the share link with the given ID whether or not it can still
+// be used
+func (s *ShareStore) get(id string) (*models.ShareLink, error) {
+	row := s.db.QueryRow(`
+		SELECT id, prefix, dashboard_id, label, expires_at, revoked_at, created_by, created_at
+		FROM secrets WHERE id = ?
+	`, id)
+	link, err := scanShareLink(row)
+	if err == sql.ErrNoRows {
+		return nil, ErrShareNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+	return link, nil
+}
+
+// scanShareLink reads a share link selected by get or List
+func scanShareLink(row interface{ Scan(...interface{}) error }) (*models.ShareLink, error) {
+	var link models.ShareLink
+	var expiresAt, revokedAt sql.NullTime
+	var createdBy sql.NullInt64
+	err := row.Scan(&link.ID, &link.Prefix, &link.DashboardID, &link.Label, &expiresAt, &revokedAt, &createdBy, &link.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if expiresAt.Valid {
+		link.ExpiresAt = &expiresAt.Time
+	}
+	if revokedAt.Valid {
+		link.RevokedAt = &revokedAt.Time
+	}
+	link.CreatedBy = int(createdBy.Int64)
+	return &link, nil
+}
+
+// generateShareToken returns a random URL-safe token with 192 bits of entropy
+func generateShareToken() (string, error) {
+	bytes := make([]byte, 24)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(bytes), nil
+}
+
+// hashShareToken returns the SHA-256 hash of a share token, which is stored
+// as the link's ID. Tokens are random, so a fast hash is enough.
+func hashShareToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
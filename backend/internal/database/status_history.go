@@ -0,0 +1,303 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"math"
+	"time"
+)
+
+// StatusHistoryStore keeps the results of status checks: every result in
+// status_history for a short time, for latency percentiles and detailed
+// series, and totals per entry and hour in status_history_hourly for longer,
+// for uptime
+type StatusHistoryStore struct {
+	db *sql.DB
+}
+
+// NewStatusHistoryStore creates a new status history store
+func NewStatusHistoryStore(db *sql.DB) *StatusHistoryStore {
+	return &StatusHistoryStore{db: db}
+}
+
+// LatencyStats summarises the response times of successful checks, in
+// milliseconds. Percentiles are nearest-rank.
+type LatencyStats struct {
+	Samples int     `json:"samples"`
+	Min     int64   `json:"min"`
+	Avg     float64 `json:"avg"`
+	P50     int64   `json:"p50"`
+	P90     int64   `json:"p90"`
+	P95     int64   `json:"p95"`
+	P99     int64   `json:"p99"`
+	Max     int64   `json:"max"`
+}
+
+// StatusPoint summarises the checks of an entry in one interval of a series.
+// Uptime and ResponseTime are nil when there were no (successful) checks.
+type StatusPoint struct {
+	Time         time.Time `json:"time"`
+	Checks       int       `json:"checks"`
+	Uptime       *float64  `json:"uptime"`       // percentage of checks that were up
+	ResponseTime *float64  `json:"responseTime"` // mean of successful checks, in milliseconds
+}
+
+// Record adds a check result. Response times count toward latency only for
+// results that are up.
+func (s *StatusHistoryStore) Record(entryID, status string, responseTime int64, checkedAt time.Time) error {
+	checkedAt = checkedAt.UTC()
+	var storedTime sql.NullInt64
+	if status == "up" || responseTime > 0 {
+		storedTime = sql.NullInt64{Int64: responseTime, Valid: true}
+	}
+	up, latencyCount, latencyTotal := 0, 0, int64(0)
+	if status == "up" {
+		up, latencyCount, latencyTotal = 1, 1, responseTime
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("database error: %w", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(
+		"INSERT INTO status_history (entry_id, status, response_time, checked_at) VALUES (?, ?, ?, ?)",
+		entryID, status, storedTime, checkedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to record status history: %w", err)
+	}
+	_, err = tx.Exec(`
+		INSERT INTO status_history_hourly (entry_id, hour, checks, up, response_time_total, response_time_count)
+		VALUES (?, ?, 1, ?, ?, ?)
+		ON CONFLICT(entry_id, hour) DO UPDATE SET
+			checks = checks + 1,
+			up = up + excluded.up,
+			response_time_total = response_time_total + excluded.response_time_total,
+			response_time_count = response_time_count + excluded.response_time_count
+	`, entryID, checkedAt.Truncate(time.Hour), up, latencyTotal, latencyCount)
+	if err != nil {
+		return fmt.Errorf("failed to record status history: %w", err)
+	}
+	return tx.Commit()
+}
+
+// Uptime returns the percentage of an entry's checks that were up in the
+// hours since since, or nil if there were none
+func (s *StatusHistoryStore) Uptime(entryID string, since time.Time) (*float64, error) {
+	var checks, up sql.NullInt64
+	err := s.db.QueryRow(
+		"SELECT SUM(checks), SUM(up) FROM status_history_hourly WHERE entry_id = ? AND hour >= ?",
+		entryID, since.UTC().Truncate(time.Hour),
+	).Scan(&checks, &up)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load uptime: %w", err)
+	}
+	if checks.Int64 == 0 {
+		return nil, nil
+	}
+	return percentage(up.Int64, checks.Int64), nil
+}
+
+// Latency summarises the response times of an entry's successful checks
+// since since, as far back as individual results are kept. It returns nil if
+// there were none.
+func (s *StatusHistoryStore) Latency(entryID string, since time.Time) (*LatencyStats, error) {
+	rows, err := s.db.Query(`
+		SELECT response_time FROM status_history
+		WHERE entry_id = ? AND checked_at >= ? AND status = 'up' AND response_time IS NOT NULL
+		ORDER BY response_time
+	`, entryID, since.UTC())
+	if err != nil {
+		return nil, fmt.Errorf("failed to load latency: %w", err)
+	}
+	defer rows.Close()
+
+	var times []int64
+	var total int64
+	for rows.Next() {
+		var t int64
+		if err := rows.Scan(&t); err != nil {
+			return nil, fmt.Errorf("failed to load latency: %w", err)
+		}
+		times = append(times, t)
+		total += t
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to load latency: %w", err)
+	}
+	if len(times) == 0 {
+		return nil, nil
+	}
+
+	percentile := func(p float64) int64 {
+		rank := int(math.Ceil(p / 100 * float64(len(times))))
+		return times[max(rank-1, 0)]
+	}
+	return &LatencyStats{
+		Samples: len(times),
+		Min:     times[0],
+		Avg:     math.Round(float64(total)/float64(len(times))*10) / 10,
+		P50:     percentile(50),
+		P90:     percentile(90),
+		P95:     percentile(95),
+		P99:     percentile(99),
+		Max:     times[len(times)-1],
+	}, nil
+}
+
+// Series divides the time from since to until into points equal intervals
+// and summarises an entry's checks in each. Intervals of an hour or more are
+// built from the hourly totals, shorter ones from individual results.
+func (s *StatusHistoryStore) Series(entryID string, since, until time.Time, points int) ([]StatusPoint, error) {
+	since, until = since.UTC(), until.UTC()
+	step := until.Sub(since) / time.Duration(points)
+	if step <= 0 {
+		return nil, fmt.Errorf("invalid series range")
+	}
+
+	type bucket struct {
+		checks, up, latencyCount int64
+		latencyTotal             int64
+	}
+	buckets := make([]bucket, points)
+	add := func(t time.Time, checks, up, latencyTotal, latencyCount int64) {
+		i := int(t.Sub(since) / step)
+		if i < 0 || i >= points {
+			return
+		}
+		buckets[i].checks += checks
+		buckets[i].up += up
+		buckets[i].latencyTotal += latencyTotal
+		buckets[i].latencyCount += latencyCount
+	}
+
+	var rows *sql.Rows
+	var err error
+	hourly := step >= time.Hour
+	if hourly {
+		rows, err = s.db.Query(`
+			SELECT hour, checks, up, response_time_total, response_time_count FROM status_history_hourly
+			WHERE entry_id = ? AND hour >= ? AND hour < ?
+		`, entryID, since.Truncate(time.Hour), until)
+	} else {
+		rows, err = s.db.Query(`
+			SELECT checked_at, status, response_time FROM status_history
+			WHERE entry_id = ? AND checked_at >= ? AND checked_at < ?
+		`, entryID, since, until)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load status history: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var t time.Time
+		if hourly {
+			var checks, up, latencyTotal, latencyCount int64
+			if err := rows.Scan(&t, &checks, &up, &latencyTotal, &latencyCount); err != nil {
+				return nil, fmt.Errorf("failed to load status history: %w", err)
+			}
+			// Hours that started before since count toward the first point
+			add(maxTime(t, since), checks, up, latencyTotal, latencyCount)
+			continue
+		}
+
+		var status string
+		var responseTime sql.NullInt64
+		if err := rows.Scan(&t, &status, &responseTime); err != nil {
+			return nil, fmt.Errorf("failed to load status history: %w", err)
+		}
+		if status == "up" {
+			add(t, 1, 1, responseTime.Int64, 1)
+		} else {
+			add(t, 1, 0, 0, 0)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to load status history: %w", err)
+	}
+
+	series := make([]StatusPoint, points)
+	for i, b := range buckets {
+		series[i] = StatusPoint{Time: since.Add(time.Duration(i) * step), Checks: int(b.checks)}
+		if b.checks > 0 {
+			series[i].Uptime = percentage(b.up, b.checks)
+		}
+		if b.latencyCount > 0 {
+			mean := math.Round(float64(b.latencyTotal)/float64(b.latencyCount)*10) / 10
+			series[i].ResponseTime = &mean
+		}
+	}
+	return series, nil
+}
+
+// Prune deletes individual results older than rawMaxAge and hourly totals
+// older than maxAge. A zero age keeps that data.
+func (s *StatusHistoryStore) Prune(rawMaxAge, maxAge time.Duration) (int64, error) {
+	var deleted int64
+	if rawMaxAge > 0 {
+		result, err := s.db.Exec("DELETE FROM status_history WHERE checked_at < ?", time.Now().Add(-rawMaxAge).UTC())
+		if err != nil {
+			return 0, fmt.Errorf("failed to prune status history: %w", err)
+		}
+		n, _ := result.RowsAffected()
+		deleted += n
+	}
+	if maxAge > 0 {
+		result, err := s.db.Exec("DELETE FROM status_history_hourly WHERE hour < ?", time.Now().Add(-maxAge).UTC())
+		if err != nil {
+			return deleted, fmt.Errorf("failed to prune status history: %w", err)
+		}
+		n, _ := result.RowsAffected()
+		deleted += n
+	}
+	return deleted, nil
+}
+
+// StartCleanupRoutine prunes the status history now and then every interval,
+// until stop is closed
+func (s *StatusHistoryStore) StartCleanupRoutine(interval, rawMaxAge, maxAge time.Duration, stop <-chan struct{}) {
+	if rawMaxAge <= 0 && maxAge <= 0 {
+		return
+	}
+	prune := func() {
+		deleted, err := s.Prune(rawMaxAge, maxAge)
+		if err != nil {
+			log.Printf("[Status] History cleanup error: %v", err)
+		} else if deleted > 0 {
+			log.Printf("[Status] Removed %d old history rows", deleted)
+		}
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		prune()
+		for {
+			select {
+			case <-ticker.C:
+				prune()
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// percentage returns part as a percentage of whole, to two decimal places
+func percentage(part, whole int64) *float64 {
+	p := math.Round(float64(part)*10000/float64(whole)) / 100
+	return &p
+}
+
+// maxTime returns the later of a and b
+func maxTime(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
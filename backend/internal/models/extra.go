@@ -0,0 +1,216 @@
+package models
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// The config models keep JSON fields they do not declare in their Extra map
+// and write them back out when marshalled, so a server that is older than the
+// client (or than a config it imports) does not drop data it does not model.
+
+// knownFields caches the JSON field names declared by each struct type
+var knownFields sync.Map // reflect.Type -> map[string]bool
+
+// fieldNames returns the JSON field names declared by struct type t
+func fieldNames(t reflect.Type) map[string]bool {
+	if names, ok := knownFields.Load(t); ok {
+		return names.(map[string]bool)
+	}
+
+	names := make(map[string]bool)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" || !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		names[name] = true
+	}
+	knownFields.Store(t, names)
+	return names
+}
+
+// unmarshalWithExtra decodes data into v, a pointer to a struct without its
+// own UnmarshalJSON method, and returns the fields v does not declare
+func unmarshalWithExtra(data []byte, v interface{}) (map[string]json.RawMessage, error) {
+	if err := json.Unmarshal(data, v); err != nil {
+		return nil, err
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+
+	names := fieldNames(reflect.TypeOf(v).Elem())
+	var extra map[string]json.RawMessage
+	for key, value := range fields {
+		if names[key] {
+			continue
+		}
+		if extra == nil {
+			extra = make(map[string]json.RawMessage)
+		}
+		extra[key] = value
+	}
+	return extra, nil
+}
+
+// marshalWithExtra encodes v, a struct without its own MarshalJSON method, and
+// adds the extra fields that v does not set itself
+func marshalWithExtra(v interface{}, extra map[string]json.RawMessage) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil || len(extra) == 0 {
+		return data, err
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	names := fieldNames(reflect.TypeOf(v))
+	for key, value := range extra {
+		if _, ok := fields[key]; !ok && !names[key] {
+			fields[key] = value
+		}
+	}
+	return json.Marshal(fields)
+}
+
+func (c *Config) UnmarshalJSON(data []byte) error {
+	type plain Config
+	extra, err := unmarshalWithExtra(data, (*plain)(c))
+	c.Extra = extra
+	return err
+}
+
+func (c Config) MarshalJSON() ([]byte, error) {
+	type plain Config
+	return marshalWithExtra(plain(c), c.Extra)
+}
+
+func (d *Dashboard) UnmarshalJSON(data []byte) error {
+	type plain Dashboard
+	extra, err := unmarshalWithExtra(data, (*plain)(d))
+	d.Extra = extra
+	return err
+}
+
+func (d Dashboard) MarshalJSON() ([]byte, error) {
+	type plain Dashboard
+	return marshalWithExtra(plain(d), d.Extra)
+}
+
+func (h *HeaderConfig) UnmarshalJSON(data []byte) error {
+	type plain HeaderConfig
+	extra, err := unmarshalWithExtra(data, (*plain)(h))
+	h.Extra = extra
+	return err
+}
+
+func (h HeaderConfig) MarshalJSON() ([]byte, error) {
+	type plain HeaderConfig
+	return marshalWithExtra(plain(h), h.Extra)
+}
+
+func (t *DashboardTheme) UnmarshalJSON(data []byte) error {
+	type plain DashboardTheme
+	extra, err := unmarshalWithExtra(data, (*plain)(t))
+	t.Extra = extra
+	return err
+}
+
+func (t DashboardTheme) MarshalJSON() ([]byte, error) {
+	type plain DashboardTheme
+	return marshalWithExtra(plain(t), t.Extra)
+}
+
+func (t *Tab) UnmarshalJSON(data []byte) error {
+	type plain Tab
+	extra, err := unmarshalWithExtra(data, (*plain)(t))
+	t.Extra = extra
+	return err
+}
+
+func (t Tab) MarshalJSON() ([]byte, error) {
+	type plain Tab
+	return marshalWithExtra(plain(t), t.Extra)
+}
+
+func (g *Group) UnmarshalJSON(data []byte) error {
+	type plain Group
+	extra, err := unmarshalWithExtra(data, (*plain)(g))
+	g.Extra = extra
+	return err
+}
+
+func (g Group) MarshalJSON() ([]byte, error) {
+	type plain Group
+	return marshalWithExtra(plain(g), g.Extra)
+}
+
+func (e *Entry) UnmarshalJSON(data []byte) error {
+	type plain Entry
+	extra, err := unmarshalWithExtra(data, (*plain)(e))
+	e.Extra = extra
+	return err
+}
+
+func (e Entry) MarshalJSON() ([]byte, error) {
+	type plain Entry
+	return marshalWithExtra(plain(e), e.Extra)
+}
+
+func (b *Background) UnmarshalJSON(data []byte) error {
+	type plain Background
+	extra, err := unmarshalWithExtra(data, (*plain)(b))
+	b.Extra = extra
+	return err
+}
+
+func (b Background) MarshalJSON() ([]byte, error) {
+	type plain Background
+	return marshalWithExtra(plain(b), b.Extra)
+}
+
+func (s *StatusCheck) UnmarshalJSON(data []byte) error {
+	type plain StatusCheck
+	extra, err := unmarshalWithExtra(data, (*plain)(s))
+	s.Extra = extra
+	return err
+}
+
+func (s StatusCheck) MarshalJSON() ([]byte, error) {
+	type plain StatusCheck
+	return marshalWithExtra(plain(s), s.Extra)
+}
+
+func (t *Theme) UnmarshalJSON(data []byte) error {
+	type plain Theme
+	extra, err := unmarshalWithExtra(data, (*plain)(t))
+	t.Extra = extra
+	return err
+}
+
+func (t Theme) MarshalJSON() ([]byte, error) {
+	type plain Theme
+	return marshalWithExtra(plain(t), t.Extra)
+}
+
+func (s *Settings) UnmarshalJSON(data []byte) error {
+	type plain Settings
+	extra, err := unmarshalWithExtra(data, (*plain)(s))
+	s.Extra = extra
+	return err
+}
+
+func (s Settings) MarshalJSON() ([]byte, error) {
+	type plain Settings
+	return marshalWithExtra(plain(s), s.Extra)
+}
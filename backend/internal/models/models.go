@@ -1,15 +1,48 @@
 package models
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+)
+
+// CurrentSchemaVersion is the version of the config schema described by these
+// models. It is increased whenever stored configs need upgrading.
+const CurrentSchemaVersion = 1
 
 // Dashboard represents a complete dashboard configuration
 type Dashboard struct {
-	ID         string       `json:"id"`
-	Name       string       `json:"name"`
-	Path       string       `json:"path"`
-	Background *Background  `json:"background,omitempty"`
-	Tabs       []Tab        `json:"tabs"`
-	Order      int          `json:"order"`
+	ID                string          `json:"id"`
+	Name              string          `json:"name"`
+	Path              string          `json:"path"`
+	Background        *Background     `json:"background,omitempty"`
+	PerTabBackgrounds bool            `json:"perTabBackgrounds,omitempty"` // each tab may have its own background
+	Header            *HeaderConfig   `json:"header,omitempty"`
+	Theme             *DashboardTheme `json:"theme,omitempty"`
+	Tabs              []Tab           `json:"tabs"`
+	Order             int             `json:"order"`
+	Visibility        string          `json:"visibility,omitempty"`   // public (default), authenticated or restricted
+	AllowedUsers      []string        `json:"allowedUsers,omitempty"` // usernames that may see a restricted dashboard
+	AllowedRoles      []string        `json:"allowedRoles,omitempty"` // roles that may see a restricted dashboard
+
+	Extra map[string]json.RawMessage `json:"-"` // fields not known to this version
+}
+
+// HeaderConfig controls the dashboard's navbar text
+type HeaderConfig struct {
+	LeftText    string `json:"leftText,omitempty"`
+	CenterTitle string `json:"centerTitle,omitempty"`
+	ShowLeft    *bool  `json:"showLeft,omitempty"`   // default: true
+	ShowCenter  *bool  `json:"showCenter,omitempty"` // default: true
+
+	Extra map[string]json.RawMessage `json:"-"`
+}
+
+// DashboardTheme holds per-dashboard colour overrides
+type DashboardTheme struct {
+	Color   string  `json:"color,omitempty"`
+	Opacity float64 `json:"opacity,omitempty"`
+
+	Extra map[string]json.RawMessage `json:"-"`
 }
 
 // Tab represents a tab within a dashboard
@@ -24,6 +57,8 @@ type Tab struct {
 	TextColor  string      `json:"textColor,omitempty"`
 	Groups     []Group     `json:"groups"`
 	Order      int         `json:"order"`
+
+	Extra map[string]json.RawMessage `json:"-"`
 }
 
 // Group represents a collapsible group of entries
@@ -39,35 +74,52 @@ type Group struct {
 	DisplayStyle string  `json:"displayStyle,omitempty"`
 	Entries      []Entry `json:"entries"`
 	Order        int     `json:"order"`
+
+	Extra map[string]json.RawMessage `json:"-"`
 }
 
 // Entry represents a tile/link on the dashboard
 type Entry struct {
-	ID          string       `json:"id"`
-	Name        string       `json:"name"`
-	URL         string       `json:"url"`
-	Icon        string       `json:"icon"`
-	IconURL     string       `json:"iconUrl,omitempty"`
-	Description string       `json:"description,omitempty"`
-	OpenMode    string       `json:"openMode"` // iframe, newtab, sametab, modal
-	StatusCheck *StatusCheck `json:"statusCheck,omitempty"`
-	Size        string       `json:"size"` // small, medium, large
-	Order       int          `json:"order"`
+	ID           string       `json:"id"`
+	Name         string       `json:"name"`
+	URL          string       `json:"url"`
+	Icon         string       `json:"icon"`
+	IconURL      string       `json:"iconUrl,omitempty"`
+	Description  string       `json:"description,omitempty"`
+	OpenMode     string       `json:"openMode"` // iframe, newtab, sametab, modal, popup
+	StatusCheck  *StatusCheck `json:"statusCheck,omitempty"`
+	Size         string       `json:"size"` // small, medium, large, wide
+	Color        string       `json:"color,omitempty"`
+	Opacity      float64      `json:"opacity,omitempty"`
+	ShowStatus   *bool        `json:"showStatus,omitempty"`
+	FetchFavicon bool         `json:"fetchFavicon,omitempty"`
+	Order        int          `json:"order"`
+
+	Extra map[string]json.RawMessage `json:"-"`
 }
 
 // Background configuration
 type Background struct {
-	Type     string   `json:"type"` // image, slideshow, color
-	Value    string   `json:"value,omitempty"`
-	Images   []string `json:"images,omitempty"`
-	Interval int      `json:"interval,omitempty"` // seconds for slideshow
+	Type               string   `json:"type"` // image, slideshow, color
+	Value              string   `json:"value,omitempty"`
+	Images             []string `json:"images,omitempty"`
+	Interval           int      `json:"interval,omitempty"`           // seconds for slideshow
+	Fit                string   `json:"fit,omitempty"`                // cover, contain, fill
+	Transition         string   `json:"transition,omitempty"`         // slideshow effect, e.g. crossfade
+	TransitionDuration float64  `json:"transitionDuration,omitempty"` // seconds, 0.5 to 5
+
+	Extra map[string]json.RawMessage `json:"-"`
 }
 
 // StatusCheck configuration
 type StatusCheck struct {
-	Type     string `json:"type"` // http, icmp
+	Type     string `json:"type"` // http, icmp, tcp
 	Enabled  bool   `json:"enabled"`
-	Interval int    `json:"interval"` // seconds
+	Interval int    `json:"interval"`          // seconds
+	Target   string `json:"target,omitempty"`  // what to check instead of the entry URL: a URL, host or host:port
+	Timeout  int    `json:"timeout,omitempty"` // seconds
+
+	Extra map[string]json.RawMessage `json:"-"`
 }
 
 // StatusResult represents the result of a status check
@@ -80,36 +132,107 @@ type StatusResult struct {
 
 // Config represents the complete application configuration
 type Config struct {
-	Dashboards []Dashboard `json:"dashboards"`
-	Theme      Theme       `json:"theme"`
-	Settings   Settings    `json:"settings"`
+	SchemaVersion int         `json:"schemaVersion,omitempty"` // see CurrentSchemaVersion
+	Dashboards    []Dashboard `json:"dashboards"`
+	Theme         Theme       `json:"theme"`
+	Settings      Settings    `json:"settings"`
+
+	Extra map[string]json.RawMessage `json:"-"`
 }
 
 // Theme configuration
 type Theme struct {
 	Mode      string `json:"mode"` // light, dark, auto
 	CustomCSS string `json:"customCss,omitempty"`
+
+	Extra map[string]json.RawMessage `json:"-"`
 }
 
 // Settings for global application settings
 type Settings struct {
 	SearchHotkey string `json:"searchHotkey"` // default: "/"
 	DefaultView  string `json:"defaultView"`  // default dashboard path
+
+	Extra map[string]json.RawMessage `json:"-"`
 }
 
-// User represents an admin user
+// User represents a user account
 type User struct {
-	ID           int       `json:"id"`
-	Username     string    `json:"username"`
-	PasswordHash string    `json:"-"`
-	CreatedAt    time.Time `json:"createdAt"`
+	ID                 int        `json:"id"`
+	Username           string     `json:"username"`
+	Role               string     `json:"role"` // admin, editor, viewer
+	PasswordHash       string     `json:"-"`
+	TOTPEnabled        bool       `json:"totpEnabled"`           // two-factor authentication is on
+	MustChangePassword bool       `json:"mustChangePassword"`    // only changing the password is allowed
+	LockedUntil        *time.Time `json:"lockedUntil,omitempty"` // password logins are locked out after failures
+	CreatedAt          time.Time  `json:"createdAt"`
 }
 
-// Session represents an authenticated session
+// Session represents an authenticated session. ID identifies the session in
+// the API and is not the session token itself.
 type Session struct {
-	ID        string    `json:"id"`
-	UserID    int       `json:"userId"`
-	ExpiresAt time.Time `json:"expiresAt"`
+	ID         string    `json:"id"`
+	UserID     int       `json:"userId"`
+	IP         string    `json:"ip"`
+	UserAgent  string    `json:"userAgent"`
+	Current    bool      `json:"current"` // the session making the request
+	CreatedAt  time.Time `json:"createdAt"`
+	LastSeenAt time.Time `json:"lastSeenAt"`
+	ExpiresAt  time.Time `json:"expiresAt"`
+}
+
+// APIToken is a long-lived credential for scripts. The token itself is only
+// shown when it is created.
+type APIToken struct {
+	ID         int        `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"` // the token's first characters, to tell tokens apart
+	Scopes     []string   `json:"scopes"` // read, config-write, backup
+	UserID     int        `json:"userId"`
+	ExpiresAt  *time.Time `json:"expiresAt,omitempty"`
+	LastUsedAt *time.Time `json:"lastUsedAt,omitempty"`
+	CreatedAt  time.Time  `json:"createdAt"`
+}
+
+// ShareLink is a secret URL that shows a single dashboard without signing in.
+// The token itself is only shown when the link is created.
+type ShareLink struct {
+	ID          string     `json:"id"`              // the token's SHA-256 hash, identifying the link
+	Token       string     `json:"token,omitempty"` // only set when the link is created
+	Prefix      string     `json:"prefix"`          // the token's first characters, to tell links apart
+	DashboardID string     `json:"dashboardId"`
+	Label       string     `json:"label"`
+	ExpiresAt   *time.Time `json:"expiresAt,omitempty"`
+	RevokedAt   *time.Time `json:"revokedAt,omitempty"`
+	CreatedBy   int        `json:"createdBy,omitempty"`
+	CreatedAt   time.Time  `json:"createdAt"`
+}
+
+// NotificationChannel is somewhere status changes are sent. Settings depend
+// on the type. A channel with no entry or group IDs gets every entry.
+type NotificationChannel struct {
+	ID             int               `json:"id"`
+	Name           string            `json:"name"`
+	Type           string            `json:"type"` // webhook, ntfy, gotify, email, url
+	Settings       map[string]string `json:"settings"`
+	EntryIDs       []string          `json:"entryIds"`
+	GroupIDs       []string          `json:"groupIds"`
+	NotifyRecovery bool              `json:"notifyRecovery"` // also send when entries come back up
+	Enabled        bool              `json:"enabled"`
+	CreatedAt      time.Time         `json:"createdAt"`
+}
+
+// AuditEntry records an action taken through the API: who did it, from where,
+// to what, and whether it succeeded
+type AuditEntry struct {
+	ID        int64     `json:"id"`
+	UserID    *int      `json:"userId,omitempty"` // nil for anonymous requests such as failed logins
+	Username  string    `json:"username"`
+	IP        string    `json:"ip"`
+	Action    string    `json:"action"` // e.g. config.update, icon.delete, auth.login
+	Target    string    `json:"target,omitempty"`
+	Summary   string    `json:"summary,omitempty"`
+	Status    int       `json:"status"` // HTTP status of the response
 	CreatedAt time.Time `json:"createdAt"`
 }
 
@@ -0,0 +1,407 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/smtp"
+	"net/url"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/weaversgrainthorpe/HOPS/internal/models"
+)
+
+// Channel types
+const (
+	TypeWebhook = "webhook" // settings: url, method, contentType, headers, body
+	TypeNtfy    = "ntfy"    // settings: url, topic, token or username and password, priority
+	TypeGotify  = "gotify"  // settings: url, token, priority
+	TypeEmail   = "email"   // settings: host, port, security, username, password, from, to
+	TypeURL     = "url"     // settings: url, an Apprise-style URL (see parseURL)
+)
+
+// client sends HTTP notifications; each send's context carries its timeout
+var client = &http.Client{}
+
+// sender delivers an event through a configured channel
+type sender func(ctx context.Context, event Event) error
+
+// Validate checks that a channel's type and settings can be used
+func Validate(channel models.NotificationChannel) error {
+	_, err := newSender(channel.Type, channel.Settings)
+	return err
+}
+
+// Send delivers event through channel
+func Send(ctx context.Context, channel models.NotificationChannel, event Event) error {
+	send, err := newSender(channel.Type, channel.Settings)
+	if err != nil {
+		return err
+	}
+	return send(ctx, event)
+}
+
+// IsSecretSetting reports whether a setting of a channel type holds
+// credentials, and so is not shown once saved
+func IsSecretSetting(channelType, key string) bool {
+	switch key {
+	case "token", "password":
+		return true
+	case "headers":
+		return channelType == TypeWebhook
+	case "url":
+		// Webhook URLs often carry a key, as with Slack or Discord
+		return channelType == TypeWebhook || channelType == TypeURL
+	}
+	return false
+}
+
+// newSender returns a sender for a channel type and its settings
+func newSender(channelType string, settings map[string]string) (sender, error) {
+	switch channelType {
+	case TypeWebhook:
+		return newWebhookSender(settings)
+	case TypeNtfy:
+		return newNtfySender(settings)
+	case TypeGotify:
+		return newGotifySender(settings)
+	case TypeEmail:
+		return newEmailSender(settings)
+	case TypeURL:
+		channelType, settings, err := parseURL(settings["url"])
+		if err != nil {
+			return nil, err
+		}
+		return newSender(channelType, settings)
+	}
+	return nil, fmt.Errorf("unknown channel type %q: use webhook, ntfy, gotify, email or url", channelType)
+}
+
+// newWebhookSender sends an HTTP request to the url setting. The body setting
+// is a Go text/template executed with the Event, where json formats a value
+// as JSON; by default the body is the Event as JSON. The headers setting has
+// one "Name: value" header per line.
+func newWebhookSender(settings map[string]string) (sender, error) {
+	target, err := httpURL(settings["url"])
+	if err != nil {
+		return nil, err
+	}
+	method := strings.ToUpper(settings["method"])
+	if method == "" {
+		method = http.MethodPost
+	}
+	if method != http.MethodPost && method != http.MethodPut && method != http.MethodPatch {
+		return nil, fmt.Errorf("invalid method %q: use POST, PUT or PATCH", settings["method"])
+	}
+	header, err := parseHeaders(settings["headers"])
+	if err != nil {
+		return nil, err
+	}
+	contentType := settings["contentType"]
+	if contentType == "" {
+		contentType = "application/json"
+	}
+	header.Set("Content-Type", contentType)
+
+	var body *template.Template
+	if settings["body"] != "" {
+		body, err = template.New("body").Funcs(template.FuncMap{"json": toJSON}).Parse(settings["body"])
+		if err != nil {
+			return nil, fmt.Errorf("invalid body template: %w", err)
+		}
+	}
+
+	return func(ctx context.Context, event Event) error {
+		var data bytes.Buffer
+		if body == nil {
+			if err := json.NewEncoder(&data).Encode(event); err != nil {
+				return err
+			}
+		} else if err := body.Execute(&data, event); err != nil {
+			return fmt.Errorf("body template: %w", err)
+		}
+		return sendHTTP(ctx, method, target, header, data.Bytes())
+	}, nil
+}
+
+// newNtfySender publishes to the topic setting on the ntfy server at the url
+// setting (default https://ntfy.sh). Problems are sent at the priority
+// setting (1-5, default 4) and recoveries at the default priority.
+func newNtfySender(settings map[string]string) (sender, error) {
+	server := settings["url"]
+	if server == "" {
+		server = "https://ntfy.sh"
+	}
+	server, err := httpURL(server)
+	if err != nil {
+		return nil, err
+	}
+	topic := settings["topic"]
+	if topic == "" || strings.ContainsAny(topic, "/?#") {
+		return nil, errors.New("a topic is required, without slashes")
+	}
+	priority, err := prioritySetting(settings["priority"], 4, 1, 5)
+	if err != nil {
+		return nil, err
+	}
+	header := http.Header{"Content-Type": {"application/json"}}
+	if settings["token"] != "" {
+		header.Set("Authorization", "Bearer "+settings["token"])
+	} else if settings["username"] != "" {
+		header.Set("Authorization", basicAuth(settings["username"], settings["password"]))
+	}
+
+	return func(ctx context.Context, event Event) error {
+		message := map[string]interface{}{
+			"topic":    topic,
+			"title":    event.Title,
+			"message":  event.Message,
+			"priority": priority,
+			"tags":     []string{"rotating_light"},
+		}
+		if event.Recovered || event.Status == "test" {
+			message["priority"] = 3
+			message["tags"] = []string{"white_check_mark"}
+		}
+		if event.URL != "" {
+			message["click"] = event.URL
+		}
+		data, err := json.Marshal(message)
+		if err != nil {
+			return err
+		}
+		return sendHTTP(ctx, http.MethodPost, strings.TrimSuffix(server, "/"), header, data)
+	}, nil
+}
+
+// newGotifySender posts to the Gotify server at the url setting with the
+// application token setting. Problems are sent at the priority setting
+// (default 8) and recoveries at priority 4.
+func newGotifySender(settings map[string]string) (sender, error) {
+	server, err := httpURL(settings["url"])
+	if err != nil {
+		return nil, err
+	}
+	if settings["token"] == "" {
+		return nil, errors.New("an application token is required")
+	}
+	priority, err := prioritySetting(settings["priority"], 8, 0, 10)
+	if err != nil {
+		return nil, err
+	}
+	header := http.Header{
+		"Content-Type": {"application/json"},
+		"X-Gotify-Key": {settings["token"]},
+	}
+
+	return func(ctx context.Context, event Event) error {
+		message := map[string]interface{}{
+			"title":    event.Title,
+			"message":  event.Message,
+			"priority": priority,
+		}
+		if event.Recovered || event.Status == "test" {
+			message["priority"] = 4
+		}
+		data, err := json.Marshal(message)
+		if err != nil {
+			return err
+		}
+		return sendHTTP(ctx, http.MethodPost, strings.TrimSuffix(server, "/")+"/message", header, data)
+	}, nil
+}
+
+// newEmailSender emails the comma-separated to setting from the from setting
+// through the SMTP server at host and port. Security is starttls (the
+// default, port 587), tls (port 465) or none (port 25). Username and password
+// are optional.
+func newEmailSender(settings map[string]string) (sender, error) {
+	host := settings["host"]
+	if host == "" {
+		return nil, errors.New("an SMTP host is required")
+	}
+	security := settings["security"]
+	defaultPort := map[string]string{"": "587", "starttls": "587", "tls": "465", "none": "25"}[security]
+	if defaultPort == "" {
+		return nil, fmt.Errorf("invalid security %q: use starttls, tls or none", security)
+	}
+	port := settings["port"]
+	if port == "" {
+		port = defaultPort
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		return nil, fmt.Errorf("invalid port %q", port)
+	}
+	from := settings["from"]
+	if from == "" || strings.ContainsAny(from, "\r\n") {
+		return nil, errors.New("a from address is required")
+	}
+	var to []string
+	for _, address := range strings.Split(settings["to"], ",") {
+		if address = strings.TrimSpace(address); address != "" {
+			to = append(to, address)
+		}
+	}
+	if len(to) == 0 || strings.ContainsAny(settings["to"], "\r\n") {
+		return nil, errors.New("at least one to address is required")
+	}
+
+	return func(ctx context.Context, event Event) error {
+		var message bytes.Buffer
+		fmt.Fprintf(&message, "From: %s\r\n", from)
+		fmt.Fprintf(&message, "To: %s\r\n", strings.Join(to, ", "))
+		fmt.Fprintf(&message, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", event.Title))
+		fmt.Fprintf(&message, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+		message.WriteString("MIME-Version: 1.0\r\n")
+		message.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+		message.WriteString(event.Message + "\r\n")
+		return sendMail(ctx, host, port, security, settings["username"], settings["password"], from, to, message.Bytes())
+	}, nil
+}
+
+// sendMail delivers an email message over SMTP
+func sendMail(ctx context.Context, host, port, security, username, password, from string, to []string, message []byte) error {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(host, port))
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	if security == "tls" {
+		conn = tls.Client(conn, &tls.Config{ServerName: host})
+	}
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if security == "" || security == "starttls" {
+		if ok, _ := c.Extension("STARTTLS"); !ok {
+			return errors.New("the SMTP server does not support STARTTLS")
+		}
+		if err := c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if username != "" {
+		if err := c.Auth(smtp.PlainAuth("", username, password, host)); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(from); err != nil {
+		return err
+	}
+	for _, address := range to {
+		if err := c.Rcpt(address); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(message); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// sendHTTP makes a request and fails unless the response is a success.
+// Errors leave out the URL, as it can hold a secret such as a webhook token.
+func sendHTTP(ctx context.Context, method, target string, header http.Header, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, method, target, bytes.NewReader(body))
+	if err != nil {
+		return withoutURL(err)
+	}
+	req.Header = header.Clone()
+	req.Header.Set("User-Agent", "HOPS")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return withoutURL(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 200))
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(detail)))
+	}
+	return nil
+}
+
+// withoutURL returns err without the URL a *url.Error adds to its message
+func withoutURL(err error) error {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return fmt.Errorf("%s request failed: %w", urlErr.Op, urlErr.Err)
+	}
+	return err
+}
+
+// httpURL checks that s is an absolute http or https URL
+func httpURL(s string) (string, error) {
+	u, err := url.Parse(s)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", fmt.Errorf("invalid URL %q: an http or https URL is required", s)
+	}
+	return s, nil
+}
+
+// parseHeaders parses "Name: value" lines
+func parseHeaders(s string) (http.Header, error) {
+	header := http.Header{}
+	for _, line := range strings.Split(s, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(line, ":")
+		if !ok || strings.TrimSpace(name) == "" {
+			return nil, fmt.Errorf("invalid header %q: use Name: value", line)
+		}
+		header.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+	return header, nil
+}
+
+// prioritySetting parses a priority setting between low and high, returning
+// def when it is empty
+func prioritySetting(s string, def, low, high int) (int, error) {
+	if s == "" {
+		return def, nil
+	}
+	priority, err := strconv.Atoi(s)
+	if err != nil || priority < low || priority > high {
+		return 0, fmt.Errorf("invalid priority %q: use %d to %d", s, low, high)
+	}
+	return priority, nil
+}
+
+// basicAuth returns an Authorization header value for HTTP basic auth
+func basicAuth(username, password string) string {
+	req := http.Request{Header: http.Header{}}
+	req.SetBasicAuth(username, password)
+	return req.Header.Get("Authorization")
+}
+
+// toJSON formats v as JSON for body templates
+func toJSON(v interface{}) (string, error) {
+	data, err := json.Marshal(v)
+	return string(data), err
+}
@@ -0,0 +1,388 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/weaversgrainthorpe/HOPS/internal/models"
+	"github.com/weaversgrainthorpe/HOPS/internal/status"
+)
+
+// capturedRequest is a request received by a captureServer
+type capturedRequest struct {
+	method string
+	path   string
+	header http.Header
+	body   string
+}
+
+// captureServer records the requests it receives and answers them with
+// status
+func captureServer(t *testing.T, status int) (*httptest.Server, func() []capturedRequest) {
+	t.Helper()
+	var mu sync.Mutex
+	var requests []capturedRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		requests = append(requests, capturedRequest{r.Method, r.URL.Path, r.Header.Clone(), string(body)})
+		mu.Unlock()
+		w.WriteHeader(status)
+		io.WriteString(w, "go away")
+	}))
+	t.Cleanup(server.Close)
+	return server, func() []capturedRequest {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]capturedRequest(nil), requests...)
+	}
+}
+
+// sendEvent sends event through a channel of the given type and settings
+func sendEvent(t *testing.T, channelType string, settings map[string]string, event Event) error {
+	t.Helper()
+	channel := models.NotificationChannel{Name: "test", Type: channelType, Settings: settings}
+	if err := Validate(channel); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return Send(ctx, channel, event)
+}
+
+// testChange returns an event for an entry changing to state
+func testChange(state string) Event {
+	info := entryInfo{name: "Plex", url: "http://plex.lan:32400", groupID: "media", group: "Media", dashboard: "Home"}
+	previous := map[string]string{"up": "down", "down": "up"}[state]
+	return newEvent(status.StatusResult{EntryID: "plex", Status: state, ResponseTime: 42}, info, previous, time.Date(2026, 1, 7, 12, 0, 0, 0, time.UTC))
+}
+
+// onlyRequest returns the single request a server received
+func onlyRequest(t *testing.T, requests []capturedRequest) capturedRequest {
+	t.Helper()
+	if len(requests) != 1 {
+		t.Fatalf("server received %d requests, want 1", len(requests))
+	}
+	return requests[0]
+}
+
+func TestWebhookBodyTemplate(t *testing.T) {
+	server, requests := captureServer(t, http.StatusNoContent)
+	err := sendEvent(t, TypeWebhook, map[string]string{
+		"url":         server.URL + "/hook",
+		"method":      "put",
+		"contentType": "text/plain",
+		"headers":     "Authorization: Bearer abc\nX-Extra: 1",
+		"body":        `{{.Title}} ({{.Group}}): {{json .Message}} {{if .Recovered}}ok{{else}}alert{{end}}`,
+	}, testChange("down"))
+	if err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	req := onlyRequest(t, requests())
+	if req.method != http.MethodPut || req.path != "/hook" {
+		t.Errorf("request = %s %s, want PUT /hook", req.method, req.path)
+	}
+	if req.header.Get("Authorization") != "Bearer abc" || req.header.Get("X-Extra") != "1" {
+		t.Errorf("headers = %v", req.header)
+	}
+	if req.header.Get("Content-Type") != "text/plain" {
+		t.Errorf("Content-Type = %q", req.header.Get("Content-Type"))
+	}
+	want := `Plex is down (Media): "Plex (http://plex.lan:32400) is down as of 2026-01-07 12:00:00 UTC." alert`
+	if req.body != want {
+		t.Errorf("body = %q, want %q", req.body, want)
+	}
+}
+
+func TestWebhookDefaultBody(t *testing.T) {
+	server, requests := captureServer(t, http.StatusOK)
+	event := testChange("up")
+	if err := sendEvent(t, TypeWebhook, map[string]string{"url": server.URL}, event); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	req := onlyRequest(t, requests())
+	if req.method != http.MethodPost || req.header.Get("Content-Type") != "application/json" {
+		t.Errorf("request = %s with Content-Type %q, want a JSON POST", req.method, req.header.Get("Content-Type"))
+	}
+	var got Event
+	if err := json.Unmarshal([]byte(req.body), &got); err != nil {
+		t.Fatalf("body %q: %v", req.body, err)
+	}
+	if got != event {
+		t.Errorf("body = %+v, want %+v", got, event)
+	}
+	if !got.Recovered || got.PreviousStatus != "down" {
+		t.Errorf("recovery event = %+v", got)
+	}
+}
+
+func TestWebhookErrorStatus(t *testing.T) {
+	server, _ := captureServer(t, http.StatusForbidden)
+	err := sendEvent(t, TypeWebhook, map[string]string{"url": server.URL}, testChange("down"))
+	if err == nil || !strings.Contains(err.Error(), "403") || !strings.Contains(err.Error(), "go away") {
+		t.Errorf("Send returned %v, want the status and response", err)
+	}
+}
+
+func TestWebhookErrorHidesURL(t *testing.T) {
+	server, _ := captureServer(t, http.StatusOK)
+	server.Close()
+	target := server.URL + "/hooks/secret-token"
+	err := sendEvent(t, TypeWebhook, map[string]string{"url": target}, testChange("down"))
+	if err == nil || strings.Contains(err.Error(), "secret-token") {
+		t.Errorf("Send returned %v, want an error without the URL", err)
+	}
+}
+
+func TestNtfyPayload(t *testing.T) {
+	server, requests := captureServer(t, http.StatusOK)
+	settings := map[string]string{"url": server.URL, "topic": "homelab", "token": "tk_secret", "priority": "5"}
+	for _, state := range []string{"down", "up"} {
+		if err := sendEvent(t, TypeNtfy, settings, testChange(state)); err != nil {
+			t.Fatalf("Send %s: %v", state, err)
+		}
+	}
+
+	got := requests()
+	if len(got) != 2 {
+		t.Fatalf("server received %d requests, want 2", len(got))
+	}
+	wants := []map[string]interface{}{
+		{"topic": "homelab", "title": "Plex is down", "priority": 5.0, "tags": []interface{}{"rotating_light"}, "click": "http://plex.lan:32400"},
+		{"topic": "homelab", "title": "Plex is up", "priority": 3.0, "tags": []interface{}{"white_check_mark"}, "click": "http://plex.lan:32400"},
+	}
+	for i, req := range got {
+		if req.method != http.MethodPost || req.path != "/" {
+			t.Errorf("request = %s %s, want POST to the server root", req.method, req.path)
+		}
+		if req.header.Get("Authorization") != "Bearer tk_secret" {
+			t.Errorf("Authorization = %q", req.header.Get("Authorization"))
+		}
+		var message map[string]interface{}
+		if err := json.Unmarshal([]byte(req.body), &message); err != nil {
+			t.Fatalf("body %q: %v", req.body, err)
+		}
+		for key, want := range wants[i] {
+			if gotJSON, wantJSON := toJSONString(message[key]), toJSONString(want); gotJSON != wantJSON {
+				t.Errorf("message %d %s = %s, want %s", i, key, gotJSON, wantJSON)
+			}
+		}
+		if message["message"] == "" {
+			t.Errorf("message %d has no text", i)
+		}
+	}
+}
+
+func TestGotifyPayload(t *testing.T) {
+	server, requests := captureServer(t, http.StatusOK)
+	settings := map[string]string{"url": server.URL + "/gotify/", "token": "app-token"}
+	for _, state := range []string{"down", "up"} {
+		if err := sendEvent(t, TypeGotify, settings, testChange(state)); err != nil {
+			t.Fatalf("Send %s: %v", state, err)
+		}
+	}
+
+	got := requests()
+	if len(got) != 2 {
+		t.Fatalf("server received %d requests, want 2", len(got))
+	}
+	for i, wantPriority := range []float64{8, 4} {
+		req := got[i]
+		if req.method != http.MethodPost || req.path != "/gotify/message" {
+			t.Errorf("request = %s %s, want POST /gotify/message", req.method, req.path)
+		}
+		if req.header.Get("X-Gotify-Key") != "app-token" {
+			t.Errorf("X-Gotify-Key = %q", req.header.Get("X-Gotify-Key"))
+		}
+		var message struct {
+			Title    string  `json:"title"`
+			Message  string  `json:"message"`
+			Priority float64 `json:"priority"`
+		}
+		if err := json.Unmarshal([]byte(req.body), &message); err != nil {
+			t.Fatalf("body %q: %v", req.body, err)
+		}
+		if message.Priority != wantPriority || message.Title == "" || message.Message == "" {
+			t.Errorf("message %d = %+v, want priority %v", i, message, wantPriority)
+		}
+	}
+}
+
+func TestIsSecretSetting(t *testing.T) {
+	tests := []struct {
+		channelType, key string
+		want             bool
+	}{
+		{TypeWebhook, "url", true},
+		{TypeWebhook, "headers", true},
+		{TypeWebhook, "body", false},
+		{TypeURL, "url", true},
+		{TypeNtfy, "url", false},
+		{TypeNtfy, "token", true},
+		{TypeGotify, "url", false},
+		{TypeEmail, "password", true},
+		{TypeEmail, "username", false},
+	}
+	for _, tt := range tests {
+		if got := IsSecretSetting(tt.channelType, tt.key); got != tt.want {
+			t.Errorf("IsSecretSetting(%q, %q) = %v, want %v", tt.channelType, tt.key, got, tt.want)
+		}
+	}
+}
+
+// smtpServer is an in-process SMTP server that accepts one session without
+// TLS or authentication
+type smtpServer struct {
+	addr string
+	done chan struct{}
+
+	from string
+	to   []string
+	data string
+	err  error
+}
+
+func newSMTPServer(t *testing.T) *smtpServer {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	s := &smtpServer{addr: listener.Addr().String(), done: make(chan struct{})}
+
+	go func() {
+		defer close(s.done)
+		conn, err := listener.Accept()
+		if err != nil {
+			s.err = err
+			return
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		s.err = s.serve(textproto.NewConn(conn))
+	}()
+	return s
+}
+
+// serve answers the commands net/smtp sends
+func (s *smtpServer) serve(c *textproto.Conn) error {
+	if err := c.PrintfLine("220 localhost ESMTP test"); err != nil {
+		return err
+	}
+	for {
+		line, err := c.ReadLine()
+		if err != nil {
+			return err
+		}
+		command, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(command) {
+		case "EHLO", "HELO":
+			err = c.PrintfLine("250 localhost")
+		case "MAIL":
+			s.from = arg
+			err = c.PrintfLine("250 OK")
+		case "RCPT":
+			s.to = append(s.to, arg)
+			err = c.PrintfLine("250 OK")
+		case "DATA":
+			if err = c.PrintfLine("354 Go ahead"); err != nil {
+				return err
+			}
+			var data []byte
+			if data, err = c.ReadDotBytes(); err != nil {
+				return err
+			}
+			s.data = string(data)
+			err = c.PrintfLine("250 Queued")
+		case "QUIT":
+			return c.PrintfLine("221 Bye")
+		default:
+			err = c.PrintfLine("502 Unknown command")
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// wait waits for the session to end
+func (s *smtpServer) wait(t *testing.T) {
+	t.Helper()
+	select {
+	case <-s.done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("SMTP session did not end")
+	}
+	if s.err != nil {
+		t.Fatalf("SMTP session: %v", s.err)
+	}
+}
+
+func TestSendMail(t *testing.T) {
+	server := newSMTPServer(t)
+	host, port, _ := net.SplitHostPort(server.addr)
+	err := sendEvent(t, TypeEmail, map[string]string{
+		"host":     host,
+		"port":     port,
+		"security": "none",
+		"from":     "hops@example.com",
+		"to":       "alice@example.com, bob@example.com",
+	}, testChange("down"))
+	if err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	server.wait(t)
+
+	if server.from != "FROM:<hops@example.com>" {
+		t.Errorf("MAIL %s", server.from)
+	}
+	if strings.Join(server.to, " ") != "TO:<alice@example.com> TO:<bob@example.com>" {
+		t.Errorf("RCPT %v", server.to)
+	}
+	for _, want := range []string{
+		"From: hops@example.com\n",
+		"To: alice@example.com, bob@example.com\n",
+		"Subject: Plex is down\n",
+		"Content-Type: text/plain; charset=utf-8\n",
+		"\n\nPlex (http://plex.lan:32400) is down as of 2026-01-07 12:00:00 UTC.\n",
+	} {
+		if !strings.Contains(server.data, want) {
+			t.Errorf("message does not contain %q:\n%s", want, server.data)
+		}
+	}
+}
+
+func TestSendMailRequiresSTARTTLS(t *testing.T) {
+	server := newSMTPServer(t)
+	host, port, _ := net.SplitHostPort(server.addr)
+	err := sendEvent(t, TypeEmail, map[string]string{
+		"host": host,
+		"port": port,
+		"from": "hops@example.com",
+		"to":   "alice@example.com",
+	}, testChange("down"))
+	if err == nil || !strings.Contains(err.Error(), "STARTTLS") {
+		t.Errorf("Send returned %v, want a STARTTLS error", err)
+	}
+	if server.data != "" {
+		t.Errorf("message was sent without STARTTLS")
+	}
+}
+
+// toJSONString formats v as JSON for comparisons
+func toJSONString(v interface{}) string {
+	s, _ := toJSON(v)
+	return s
+}
@@ -0,0 +1,275 @@
+// Package notify sends notifications when entries' status checks change
+// between up and down, to webhooks, ntfy, Gotify and email.
+package notify
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/weaversgrainthorpe/HOPS/internal/database"
+	"github.com/weaversgrainthorpe/HOPS/internal/models"
+	"github.com/weaversgrainthorpe/HOPS/internal/status"
+)
+
+// sendTimeout is how long a channel has to accept a notification
+const sendTimeout = 30 * time.Second
+
+// Event describes a status change, or a test notification
+type Event struct {
+	EntryID        string    `json:"entryId"`
+	Name           string    `json:"name"`
+	URL            string    `json:"url"`
+	GroupID        string    `json:"groupId,omitempty"`
+	Group          string    `json:"group,omitempty"`
+	Dashboard      string    `json:"dashboard,omitempty"`
+	Status         string    `json:"status"`                   // up, down or error; test for test notifications
+	PreviousStatus string    `json:"previousStatus,omitempty"` // up or down; empty the first time an entry is seen
+	Recovered      bool      `json:"recovered"`                // the entry is up again
+	ResponseTime   int64     `json:"responseTime,omitempty"`
+	Time           time.Time `json:"time"`
+	Title          string    `json:"title"`
+	Message        string    `json:"message"`
+}
+
+// entryInfo is where an entry sits in the config
+type entryInfo struct {
+	name, url, groupID, group, dashboard string
+}
+
+// newEvent describes an entry's change to status (up, down or error) from
+// previous (up, down or empty)
+func newEvent(result status.StatusResult, info entryInfo, previous string, at time.Time) Event {
+	event := Event{
+		EntryID:        result.EntryID,
+		Name:           info.name,
+		URL:            info.url,
+		GroupID:        info.groupID,
+		Group:          info.group,
+		Dashboard:      info.dashboard,
+		Status:         result.Status,
+		PreviousStatus: previous,
+		Recovered:      result.Status == "up",
+		ResponseTime:   result.ResponseTime,
+		Time:           at.UTC(),
+	}
+	if event.Name == "" {
+		event.Name = result.EntryID
+	}
+
+	when := event.Time.Format("2006-01-02 15:04:05 UTC")
+	switch result.Status {
+	case "up":
+		event.Title = event.Name + " is up"
+		event.Message = fmt.Sprintf("%s (%s) is up again as of %s, responding in %d ms.", event.Name, event.URL, when, event.ResponseTime)
+	case "error":
+		event.Title = event.Name + " cannot be checked"
+		event.Message = fmt.Sprintf("The status check of %s (%s) has been failing since %s.", event.Name, event.URL, when)
+	default:
+		event.Title = event.Name + " is down"
+		event.Message = fmt.Sprintf("%s (%s) is down as of %s.", event.Name, event.URL, when)
+	}
+	return event
+}
+
+// testEvent is sent by SendTest
+func testEvent(channel models.NotificationChannel) Event {
+	return Event{
+		EntryID: "test",
+		Name:    "HOPS",
+		Status:  "test",
+		Time:    time.Now().UTC().Truncate(time.Second),
+		Title:   "HOPS test notification",
+		Message: fmt.Sprintf("This is a test notification for the %q channel. Status changes will look like this.", channel.Name),
+	}
+}
+
+// tracker debounces an entry's results
+type tracker struct {
+	notified string // up or down, as last notified; empty if never seen
+	pending  string // the state results have been changing to
+	count    int    // consecutive results in the pending state
+}
+
+// Notifier watches the status checker's results and notifies the matching
+// channels when an entry changes between up and down. A change only counts
+// once threshold consecutive results agree, so a single failed check does
+// not page anyone.
+type Notifier struct {
+	store       *database.NotificationStore
+	configStore *database.ConfigStore
+	threshold   int
+	trackers    map[string]*tracker
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewNotifier creates a notifier that needs threshold consecutive results to
+// confirm a change
+func NewNotifier(db *sql.DB, threshold int) *Notifier {
+	return &Notifier{
+		store:       database.NewNotificationStore(db),
+		configStore: database.NewConfigStore(db),
+		threshold:   max(threshold, 1),
+		trackers:    make(map[string]*tracker),
+		stop:        make(chan struct{}),
+	}
+}
+
+// Start begins watching checker's results. Start the notifier before the
+// checker, or results produced in between are missed.
+func (n *Notifier) Start(checker *status.Checker) {
+	states, err := n.store.States()
+	if err != nil {
+		log.Printf("[Notify] %v; notifying changes from the next results", err)
+	}
+	for entryID, state := range states {
+		n.trackers[entryID] = &tracker{notified: state}
+	}
+
+	results, unsubscribe := checker.Subscribe()
+	n.wg.Add(1)
+	go func() {
+		defer n.wg.Done()
+		defer unsubscribe()
+		for {
+			select {
+			case result := <-results:
+				n.observe(result)
+			case <-n.stop:
+				return
+			}
+		}
+	}()
+	log.Printf("Status notifications started (%d consecutive results confirm a change)", n.threshold)
+}
+
+// Stop stops watching results and waits for notifications being sent
+func (n *Notifier) Stop() {
+	close(n.stop)
+	n.wg.Wait()
+}
+
+// observe counts a check result toward its entry's state, and notifies once
+// a change is confirmed
+func (n *Notifier) observe(result status.StatusResult) {
+	var state string
+	switch result.Status {
+	case "up":
+		state = "up"
+	case "down", "error":
+		state = "down"
+	default:
+		return
+	}
+
+	t := n.trackers[result.EntryID]
+	if t == nil {
+		t = &tracker{}
+		n.trackers[result.EntryID] = t
+	}
+	if state == t.notified {
+		t.pending, t.count = "", 0
+		return
+	}
+	if state != t.pending {
+		t.pending, t.count = state, 0
+	}
+	t.count++
+	if t.count < n.threshold {
+		return
+	}
+
+	previous := t.notified
+	t.notified, t.pending, t.count = state, "", 0
+	now := time.Now().UTC().Truncate(time.Second)
+	if err := n.store.SetState(result.EntryID, state, now); err != nil {
+		log.Printf("[Notify] %v", err)
+	}
+	// An entry's first results are only worth a notification if it is down
+	if previous == "" && state == "up" {
+		return
+	}
+
+	info, err := n.findEntry(result.EntryID)
+	if err != nil {
+		log.Printf("[Notify] Failed to load config: %v", err)
+	}
+	n.dispatch(newEvent(result, info, previous, now))
+}
+
+// findEntry looks up an entry's name, URL, group and dashboard in the config
+func (n *Notifier) findEntry(entryID string) (entryInfo, error) {
+	data, _, err := n.configStore.LoadRaw()
+	if err != nil || data == "" {
+		return entryInfo{}, err
+	}
+	var config models.Config
+	if err := json.Unmarshal([]byte(data), &config); err != nil {
+		return entryInfo{}, err
+	}
+	for _, dashboard := range config.Dashboards {
+		for _, tab := range dashboard.Tabs {
+			for _, group := range tab.Groups {
+				for _, entry := range group.Entries {
+					if entry.ID == entryID {
+						return entryInfo{entry.Name, entry.URL, group.ID, group.Name, dashboard.Name}, nil
+					}
+				}
+			}
+		}
+	}
+	return entryInfo{}, nil
+}
+
+// dispatch sends event to every enabled channel that routes it, in the
+// background
+func (n *Notifier) dispatch(event Event) {
+	channels, err := n.store.List()
+	if err != nil {
+		log.Printf("[Notify] %v", err)
+		return
+	}
+
+	sent := 0
+	for _, channel := range channels {
+		if !channel.Enabled || !routes(channel, event) {
+			continue
+		}
+		sent++
+		n.wg.Add(1)
+		go func() {
+			defer n.wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), sendTimeout)
+			defer cancel()
+			if err := Send(ctx, channel, event); err != nil {
+				log.Printf("[Notify] Failed to notify channel %q: %v", channel.Name, err)
+			}
+		}()
+	}
+	log.Printf("[Notify] %s: notifying %d channels", event.Title, sent)
+}
+
+// routes reports whether channel wants event
+func routes(channel models.NotificationChannel, event Event) bool {
+	if event.Recovered && !channel.NotifyRecovery {
+		return false
+	}
+	if len(channel.EntryIDs) == 0 && len(channel.GroupIDs) == 0 {
+		return true
+	}
+	return slices.Contains(channel.EntryIDs, event.EntryID) ||
+		(event.GroupID != "" && slices.Contains(channel.GroupIDs, event.GroupID))
+}
+
+// SendTest sends a test notification to channel, whether or not it is
+// enabled
+func SendTest(ctx context.Context, channel models.NotificationChannel) error {
+	return Send(ctx, channel, testEvent(channel))
+}
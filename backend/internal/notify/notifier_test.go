@@ -0,0 +1,184 @@
+package notify
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/weaversgrainthorpe/HOPS/internal/database"
+	"github.com/weaversgrainthorpe/HOPS/internal/models"
+	"github.com/weaversgrainthorpe/HOPS/internal/status"
+)
+
+// testConfig has the entry the notifier tests check
+const testConfig = `{"dashboards": [{"id": "home", "name": "Home", "path": "/home", "tabs": [{"id": "main", "name": "Main", "groups": [
+	{"id": "media", "name": "Media", "entries": [{"id": "plex", "name": "Plex", "url": "http://plex.lan:32400"}]}
+]}]}]}`
+
+// newTestDB returns a fresh database
+func newTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := database.Initialize(filepath.Join(t.TempDir(), "hops.db"))
+	if err != nil {
+		t.Fatalf("database.Initialize: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// newTestNotifier returns a notifier using db with testConfig, and a webhook
+// channel to a server that records the events it receives
+func newTestNotifier(t *testing.T, db *sql.DB, threshold int, channel models.NotificationChannel) (*Notifier, func() []Event) {
+	t.Helper()
+	var doc map[string]interface{}
+	if err := json.Unmarshal([]byte(testConfig), &doc); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := database.NewConfigStore(db).Replace(0, database.Change{Reason: "test"}, doc); err != nil {
+		t.Fatalf("Replace: %v", err)
+	}
+
+	server, requests := captureServer(t, http.StatusOK)
+	channel.Name, channel.Type, channel.Enabled = "hook", TypeWebhook, true
+	channel.Settings = map[string]string{"url": server.URL}
+	if _, err := database.NewNotificationStore(db).Create(channel); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+
+	n := NewNotifier(db, threshold)
+	return n, func() []Event {
+		// Notifications are sent in the background
+		n.wg.Wait()
+		var events []Event
+		for _, req := range requests() {
+			var event Event
+			if err := json.Unmarshal([]byte(req.body), &event); err != nil {
+				t.Fatalf("body %q: %v", req.body, err)
+			}
+			events = append(events, event)
+		}
+		return events
+	}
+}
+
+// observeAll counts each status as a check result for entry
+func observeAll(n *Notifier, entryID string, statuses ...string) {
+	for _, s := range statuses {
+		n.observe(status.StatusResult{EntryID: entryID, Status: s, ResponseTime: 42})
+	}
+}
+
+// statuses returns each event's previous and new status
+func statuses(events []Event) []string {
+	var changes []string
+	for _, event := range events {
+		changes = append(changes, event.PreviousStatus+">"+event.Status)
+	}
+	return changes
+}
+
+// assertChanges fails the test unless events are the given changes, as
+// formatted by statuses
+func assertChanges(t *testing.T, events []Event, want ...string) {
+	t.Helper()
+	got := statuses(events)
+	if len(got) != len(want) {
+		t.Fatalf("notified %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("notified %v, want %v", got, want)
+		}
+	}
+}
+
+func TestObserveDebounces(t *testing.T) {
+	n, events := newTestNotifier(t, newTestDB(t), 3, models.NotificationChannel{NotifyRecovery: true})
+
+	// An entry first seen up is not worth a notification
+	observeAll(n, "plex", "up", "up", "up")
+	assertChanges(t, events())
+
+	// Blips shorter than the threshold are ignored
+	observeAll(n, "plex", "down", "down", "up", "error", "up", "unknown")
+	assertChanges(t, events())
+
+	// Errors count as down, and results other than up and down are ignored
+	observeAll(n, "plex", "down", "unknown", "error", "down", "down")
+	got := events()
+	assertChanges(t, got, "up>down")
+	if event := got[0]; event.Name != "Plex" || event.Group != "Media" || event.GroupID != "media" || event.Dashboard != "Home" {
+		t.Errorf("event = %+v, want the entry's details from the config", event)
+	}
+
+	// Staying down does not notify again
+	observeAll(n, "plex", "down", "down", "down", "down")
+	assertChanges(t, events(), "up>down")
+
+	observeAll(n, "plex", "up", "up", "up")
+	got = events()
+	assertChanges(t, got, "up>down", "down>up")
+	if !got[1].Recovered || got[1].Title != "Plex is up" {
+		t.Errorf("recovery event = %+v", got[1])
+	}
+
+	states, err := n.store.States()
+	if err != nil {
+		t.Fatalf("States: %v", err)
+	}
+	if states["plex"] != "up" {
+		t.Errorf("stored state = %q, want up", states["plex"])
+	}
+}
+
+func TestObserveFirstSeenDown(t *testing.T) {
+	n, events := newTestNotifier(t, newTestDB(t), 2, models.NotificationChannel{})
+	observeAll(n, "plex", "down", "down")
+	got := events()
+	assertChanges(t, got, ">down")
+	if got[0].Recovered {
+		t.Errorf("event = %+v, want a problem", got[0])
+	}
+
+	// Unknown entries are named by their ID
+	observeAll(n, "gone", "error", "error")
+	got = events()
+	if len(got) != 2 || got[1].Name != "gone" || got[1].Status != "error" {
+		t.Errorf("events = %+v, want one for the unknown entry", got)
+	}
+}
+
+func TestObserveRecoveryNotWanted(t *testing.T) {
+	n, events := newTestNotifier(t, newTestDB(t), 1, models.NotificationChannel{})
+	observeAll(n, "plex", "up", "down", "up", "down")
+	assertChanges(t, events(), "up>down", "up>down")
+}
+
+func TestObserveRoutes(t *testing.T) {
+	n, events := newTestNotifier(t, newTestDB(t), 1, models.NotificationChannel{GroupIDs: []string{"media"}})
+	observeAll(n, "plex", "down")
+	observeAll(n, "other", "down")
+	got := events()
+	if len(got) != 1 || got[0].EntryID != "plex" {
+		t.Errorf("events = %+v, want only the media group's entry", got)
+	}
+}
+
+func TestStartRestoresStates(t *testing.T) {
+	db := newTestDB(t)
+	n, events := newTestNotifier(t, db, 2, models.NotificationChannel{NotifyRecovery: true})
+	if err := n.store.SetState("plex", "down", time.Now().UTC()); err != nil {
+		t.Fatalf("SetState: %v", err)
+	}
+
+	checker := status.NewChecker(db, time.Minute)
+	n.Start(checker)
+	observeAll(n, "plex", "down", "down", "up", "up")
+	n.Stop()
+
+	// The entry was already known to be down when HOPS restarted
+	assertChanges(t, events(), "down>up")
+}
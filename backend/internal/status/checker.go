@@ -1,18 +1,53 @@
 package status
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"log"
 	"net/http"
+	"strings"
 	"sync"
 	"time"
+
+	"github.com/weaversgrainthorpe/HOPS/internal/database"
+	"github.com/weaversgrainthorpe/HOPS/internal/models"
 )
 
-// Entry represents a minimal entry for status checking
+const (
+	// tickInterval is how often the scheduler looks for checks that are due
+	tickInterval = time.Second
+
+	// configPollInterval is how often the config revision is compared with
+	// the one the schedule was built from
+	configPollInterval = 5 * time.Second
+
+	// minCheckInterval is the shortest interval an entry is checked at
+	minCheckInterval = 10 * time.Second
+
+	// defaultCheckTimeout is how long a check waits for an answer unless the
+	// entry sets its own timeout
+	defaultCheckTimeout = 10 * time.Second
+
+	// maxConcurrentChecks limits how many checks run at once
+	maxConcurrentChecks = 5
+)
+
+// Entry is an entry with status checks enabled
 type Entry struct {
-	ID  string `json:"id"`
-	URL string `json:"url"`
+	ID       string
+	URL      string
+	Type     string // http, icmp or tcp
+	Target   string // checked instead of URL if set
+	Interval time.Duration
+	Timeout  time.Duration
+}
+
+// sameCheck reports whether a and b check the same thing the same way,
+// whatever their intervals
+func sameCheck(a, b Entry) bool {
+	a.Interval, b.Interval = 0, 0
+	return a == b
 }
 
 // StatusResult holds the result of a status check
@@ -20,25 +55,55 @@ type StatusResult struct {
 	EntryID      string `json:"entryId"`
 	Status       string `json:"status"`
 	ResponseTime int64  `json:"responseTime,omitempty"`
-	LastChecked  string `json:"lastChecked"`
+	LastChecked  string `json:"lastChecked,omitempty"`
 }
 
-// Checker handles HTTP status checks for entries
+// scheduledCheck is an entry's place in the schedule
+type scheduledCheck struct {
+	entry   Entry
+	lastRun time.Time // zero until first checked
+	running bool
+}
+
+// due reports whether the check should run now
+func (s *scheduledCheck) due(now time.Time) bool {
+	return !s.running && !now.Before(s.lastRun.Add(s.entry.Interval))
+}
+
+// Checker runs the status checks of entries that have them enabled, each on
+// its own interval. The schedule is rebuilt from the config whenever its
+// revision changes, so edits take effect without a restart.
 type Checker struct {
-	db             *sql.DB
-	client         *http.Client
-	checkInterval  time.Duration
-	stopChan       chan struct{}
-	running        bool
-	mu             sync.Mutex
+	db              *sql.DB
+	configStore     *database.ConfigStore
+	history         *database.StatusHistoryStore
+	client          *http.Client
+	defaultInterval time.Duration
+	stopChan        chan struct{}
+	running         bool
+	mu              sync.Mutex
+	wg              sync.WaitGroup
+
+	scheduleMu sync.Mutex
+	schedule   map[string]*scheduledCheck
+	revision   int64
+	sem        chan struct{}
+
+	icmpWarning sync.Once // logs once that ICMP sockets cannot be opened
+
+	subscribersMu sync.Mutex
+	subscribers   map[chan StatusResult]struct{}
 }
 
-// NewChecker creates a new status checker
-func NewChecker(db *sql.DB, checkInterval time.Duration) *Checker {
+// NewChecker creates a new status checker. Entries whose status check has
+// no interval are checked every defaultInterval.
+func NewChecker(db *sql.DB, defaultInterval time.Duration) *Checker {
 	return &Checker{
-		db:            db,
+		db:          db,
+		configStore: database.NewConfigStore(db),
+		history:     database.NewStatusHistoryStore(db),
 		client: &http.Client{
-			Timeout: 10 * time.Second,
+			// Each check's context carries its timeout
 			CheckRedirect: func(req *http.Request, via []*http.Request) error {
 				// Allow redirects but cap at 10
 				if len(via) >= 10 {
@@ -47,8 +112,43 @@ func NewChecker(db *sql.DB, checkInterval time.Duration) *Checker {
 				return nil
 			},
 		},
-		checkInterval: checkInterval,
-		stopChan:      make(chan struct{}),
+		defaultInterval: defaultInterval,
+		stopChan:        make(chan struct{}),
+		schedule:        make(map[string]*scheduledCheck),
+		revision:        -1,
+		sem:             make(chan struct{}, maxConcurrentChecks),
+		subscribers:     make(map[chan StatusResult]struct{}),
+	}
+}
+
+// Subscribe returns a channel that receives every check result as it is
+// produced, until unsubscribe is called. Results are dropped rather than
+// delayed for subscribers that fall behind.
+func (c *Checker) Subscribe() (results <-chan StatusResult, unsubscribe func()) {
+	ch := make(chan StatusResult, 64)
+	c.subscribersMu.Lock()
+	c.subscribers[ch] = struct{}{}
+	c.subscribersMu.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			c.subscribersMu.Lock()
+			delete(c.subscribers, ch)
+			c.subscribersMu.Unlock()
+		})
+	}
+}
+
+// publish sends result to every subscriber with room for it
+func (c *Checker) publish(result StatusResult) {
+	c.subscribersMu.Lock()
+	defer c.subscribersMu.Unlock()
+	for ch := range c.subscribers {
+		select {
+		case ch <- result:
+		default:
+		}
 	}
 }
 
@@ -63,11 +163,12 @@ func (c *Checker) Start() {
 	c.stopChan = make(chan struct{})
 	c.mu.Unlock()
 
-	go c.runLoop()
-	log.Printf("Status checker started with interval: %v", c.checkInterval)
+	c.wg.Add(1)
+	go c.runLoop(c.stopChan)
+	log.Printf("Status checker started (default interval: %v)", c.defaultInterval)
 }
 
-// Stop halts the status checking loop
+// Stop halts the status checking loop and waits for running checks to finish
 func (c *Checker) Stop() {
 	c.mu.Lock()
 	if !c.running {
@@ -77,46 +178,69 @@ func (c *Checker) Stop() {
 	c.running = false
 	close(c.stopChan)
 	c.mu.Unlock()
+	c.wg.Wait()
 	log.Println("Status checker stopped")
 }
 
-func (c *Checker) runLoop() {
-	// Run initial check
-	c.checkAllEntries()
+func (c *Checker) runLoop(stop chan struct{}) {
+	defer c.wg.Done()
 
-	ticker := time.NewTicker(c.checkInterval)
+	c.reloadIfChanged()
+	c.runDueChecks(stop)
+
+	ticker := time.NewTicker(tickInterval)
 	defer ticker.Stop()
+	lastPoll := time.Now()
 
 	for {
 		select {
-		case <-ticker.C:
-			c.checkAllEntries()
-		case <-c.stopChan:
+		case now := <-ticker.C:
+			if now.Sub(lastPoll) >= configPollInterval {
+				lastPoll = now
+				c.reloadIfChanged()
+			}
+			c.runDueChecks(stop)
+		case <-stop:
 			return
 		}
 	}
 }
 
-// getEntriesFromConfig extracts all entries from the config
-func (c *Checker) getEntriesFromConfig() ([]Entry, error) {
-	var configData string
-	err := c.db.QueryRow("SELECT data FROM config WHERE id = 1").Scan(&configData)
+// reloadIfChanged rebuilds the schedule if the config has changed since it
+// was last built
+func (c *Checker) reloadIfChanged() {
+	revision, err := c.configStore.Revision()
 	if err != nil {
-		return nil, err
+		log.Printf("Failed to check config revision for status checks: %v", err)
+		return
+	}
+	if revision == c.revision {
+		return
 	}
 
-	var config struct {
-		Dashboards []struct {
-			Tabs []struct {
-				Groups []struct {
-					Entries []Entry `json:"entries"`
-				} `json:"groups"`
-			} `json:"tabs"`
-		} `json:"dashboards"`
+	data, revision, err := c.configStore.LoadRaw()
+	if err != nil {
+		log.Printf("Failed to load config for status checks: %v", err)
+		return
 	}
+	entries, err := c.getEntriesFromConfig(data)
+	if err != nil {
+		log.Printf("Failed to get entries for status check: %v", err)
+		return
+	}
+	c.revision = revision
+	c.updateSchedule(entries)
+	log.Printf("Status checks scheduled for %d entries (config revision %d)", len(entries), revision)
+}
 
-	if err := json.Unmarshal([]byte(configData), &config); err != nil {
-		return nil, err
+// getEntriesFromConfig extracts the entries with status checks enabled from
+// the config document
+func (c *Checker) getEntriesFromConfig(data string) ([]Entry, error) {
+	var config models.Config
+	if data != "" {
+		if err := json.Unmarshal([]byte(data), &config); err != nil {
+			return nil, err
+		}
 	}
 
 	var entries []Entry
@@ -124,9 +248,34 @@ func (c *Checker) getEntriesFromConfig() ([]Entry, error) {
 		for _, tab := range dashboard.Tabs {
 			for _, group := range tab.Groups {
 				for _, entry := range group.Entries {
-					if entry.URL != "" {
-						entries = append(entries, entry)
+					check := entry.StatusCheck
+					if check == nil || !check.Enabled || entry.URL == "" {
+						continue
+					}
+					checkType := strings.ToLower(check.Type)
+					if checkType == "" {
+						checkType = "http"
+					}
+					if checkType != "http" && checkType != "icmp" && checkType != "tcp" {
+						log.Printf("Status check type %q of entry %s is not supported", check.Type, entry.ID)
+						continue
+					}
+					interval := c.defaultInterval
+					if check.Interval > 0 {
+						interval = max(time.Duration(check.Interval)*time.Second, minCheckInterval)
 					}
+					timeout := defaultCheckTimeout
+					if check.Timeout > 0 {
+						timeout = time.Duration(check.Timeout) * time.Second
+					}
+					entries = append(entries, Entry{
+						ID:       entry.ID,
+						URL:      entry.URL,
+						Type:     checkType,
+						Target:   strings.TrimSpace(check.Target),
+						Interval: interval,
+						Timeout:  timeout,
+					})
 				}
 			}
 		}
@@ -135,69 +284,107 @@ func (c *Checker) getEntriesFromConfig() ([]Entry, error) {
 	return entries, nil
 }
 
-func (c *Checker) checkAllEntries() {
-	entries, err := c.getEntriesFromConfig()
-	if err != nil {
-		log.Printf("Failed to get entries for status check: %v", err)
-		return
+// updateSchedule replaces the scheduled checks with entries. Entries whose
+// check is unchanged apart from its interval keep their last run, so a new interval counts
+// from it; new and changed entries are checked straight away. Cached
+// statuses of entries no longer checked are deleted.
+func (c *Checker) updateSchedule(entries []Entry) {
+	c.scheduleMu.Lock()
+	schedule := make(map[string]*scheduledCheck, len(entries))
+	for _, entry := range entries {
+		scheduled, ok := c.schedule[entry.ID]
+		if !ok || !sameCheck(scheduled.entry, entry) {
+			scheduled = &scheduledCheck{}
+		}
+		scheduled.entry = entry
+		schedule[entry.ID] = scheduled
+	}
+	c.schedule = schedule
+	c.scheduleMu.Unlock()
+
+	query := "DELETE FROM status_cache"
+	args := make([]interface{}, 0, len(entries))
+	if len(entries) > 0 {
+		query += " WHERE entry_id NOT IN (?" + strings.Repeat(", ?", len(entries)-1) + ")"
+		for _, entry := range entries {
+			args = append(args, entry.ID)
+		}
+	}
+	if _, err := c.db.Exec(query, args...); err != nil {
+		log.Printf("Failed to clear stale status cache: %v", err)
 	}
+}
+
+// runDueChecks starts the checks that are due, at most maxConcurrentChecks
+// at a time. Checks still waiting for a slot when stop is closed are skipped.
+func (c *Checker) runDueChecks(stop chan struct{}) {
+	now := time.Now()
+	c.scheduleMu.Lock()
+	defer c.scheduleMu.Unlock()
 
-	log.Printf("Checking status for %d entries...", len(entries))
+	for _, scheduled := range c.schedule {
+		if !scheduled.due(now) {
+			continue
+		}
+		scheduled.running = true
+		scheduled.lastRun = now
 
-	// Use a semaphore to limit concurrent requests
-	sem := make(chan struct{}, 5)
-	var wg sync.WaitGroup
+		c.wg.Add(1)
+		go func(s *scheduledCheck, entry Entry) {
+			defer c.wg.Done()
+			defer func() {
+				c.scheduleMu.Lock()
+				s.running = false
+				c.scheduleMu.Unlock()
+			}()
 
-	for _, entry := range entries {
-		wg.Add(1)
-		go func(e Entry) {
-			defer wg.Done()
-			sem <- struct{}{}        // acquire
-			defer func() { <-sem }() // release
+			select {
+			case c.sem <- struct{}{}: // acquire
+			case <-stop:
+				return
+			}
+			defer func() { <-c.sem }() // release
 
-			c.checkEntry(e)
-		}(entry)
+			c.checkEntry(entry)
+		}(scheduled, scheduled.entry)
 	}
-
-	wg.Wait()
 }
 
+// checkEntry runs an entry's check, caches the result and adds it to the
+// status history
 func (c *Checker) checkEntry(entry Entry) {
-	start := time.Now()
-	status := "up"
-	var responseTime int64
+	ctx, cancel := context.WithTimeout(context.Background(), entry.Timeout)
+	defer cancel()
 
-	req, err := http.NewRequest("HEAD", entry.URL, nil)
-	if err != nil {
-		status = "error"
-	} else {
-		req.Header.Set("User-Agent", "HOPS-StatusChecker/1.0")
-
-		resp, err := c.client.Do(req)
-		if err != nil {
-			status = "down"
-		} else {
-			defer resp.Body.Close()
-			responseTime = time.Since(start).Milliseconds()
-
-			if resp.StatusCode >= 200 && resp.StatusCode < 400 {
-				status = "up"
-			} else if resp.StatusCode >= 400 && resp.StatusCode < 500 {
-				status = "error"
-			} else {
-				status = "down"
-			}
-		}
+	var status string
+	var responseTime int64
+	switch entry.Type {
+	case "icmp":
+		status, responseTime = c.checkICMP(ctx, entry)
+	case "tcp":
+		status, responseTime = checkTCP(ctx, entry)
+	default:
+		status, responseTime = c.checkHTTP(ctx, entry)
 	}
 
 	// Update the cache
-	_, err = c.db.Exec(`
+	checkedAt := time.Now().UTC().Truncate(time.Second)
+	_, err := c.db.Exec(`
 		INSERT OR REPLACE INTO status_cache (entry_id, status, response_time, last_checked)
-		VALUES (?, ?, ?, datetime('now'))
-	`, entry.ID, status, responseTime)
+		VALUES (?, ?, ?, ?)
+	`, entry.ID, status, responseTime, checkedAt.Format(time.DateTime))
 
 	if err != nil {
 		log.Printf("Failed to update status cache for %s: %v", entry.ID, err)
 	}
-}
+	if err := c.history.Record(entry.ID, status, responseTime, checkedAt); err != nil {
+		log.Printf("Failed to record status history for %s: %v", entry.ID, err)
+	}
 
+	c.publish(StatusResult{
+		EntryID:      entry.ID,
+		Status:       status,
+		ResponseTime: responseTime,
+		LastChecked:  checkedAt.Format(time.RFC3339),
+	})
+}
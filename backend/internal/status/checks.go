@@ -0,0 +1,139 @@
+package status
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// checkHTTP sends a HEAD request to the entry's target or URL. 2xx and 3xx
+// responses are up, 4xx responses are an error and anything else is down.
+func (c *Checker) checkHTTP(ctx context.Context, entry Entry) (string, int64) {
+	target := entry.URL
+	if entry.Target != "" {
+		target = entry.Target
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, target, nil)
+	if err != nil {
+		return "error", 0
+	}
+	req.Header.Set("User-Agent", "HOPS-StatusChecker/1.0")
+
+	start := time.Now()
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return "down", 0
+	}
+	defer resp.Body.Close()
+	responseTime := time.Since(start).Milliseconds()
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 400:
+		return "up", responseTime
+	case resp.StatusCode >= 400 && resp.StatusCode < 500:
+		return "error", responseTime
+	default:
+		return "down", responseTime
+	}
+}
+
+// checkTCP connects to the entry's host:port. Accepted connections are up,
+// and the response time is how long the connection took.
+func checkTCP(ctx context.Context, entry Entry) (string, int64) {
+	address, err := tcpAddress(entry)
+	if err != nil {
+		return "error", 0
+	}
+
+	var dialer net.Dialer
+	start := time.Now()
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return "down", 0
+	}
+	conn.Close()
+	return "up", time.Since(start).Milliseconds()
+}
+
+// checkICMP pings the entry's host. Answered pings are up, and the response
+// time is the round trip time.
+func (c *Checker) checkICMP(ctx context.Context, entry Entry) (string, int64) {
+	host, err := icmpHost(entry)
+	if err != nil {
+		return "error", 0
+	}
+
+	rtt, err := ping(ctx, host)
+	if errors.Is(err, errICMPUnavailable) {
+		c.icmpWarning.Do(func() { log.Printf("ICMP status checks cannot run: %v", err) })
+		return "error", 0
+	}
+	if err != nil {
+		return "down", 0
+	}
+	return "up", rtt.Milliseconds()
+}
+
+// tcpAddress returns the host:port a tcp check connects to: the target, or
+// the host of the target or entry URL with its port, defaulting to the
+// standard port of its scheme
+func tcpAddress(entry Entry) (string, error) {
+	target := entry.URL
+	if entry.Target != "" {
+		if !strings.Contains(entry.Target, "://") {
+			if _, _, err := net.SplitHostPort(entry.Target); err != nil {
+				return "", err
+			}
+			return entry.Target, nil
+		}
+		target = entry.Target
+	}
+
+	u, err := url.Parse(target)
+	if err != nil {
+		return "", err
+	}
+	if u.Hostname() == "" {
+		return "", fmt.Errorf("%q has no host", target)
+	}
+	port := u.Port()
+	if port == "" {
+		number, err := net.LookupPort("tcp", u.Scheme)
+		if err != nil {
+			return "", fmt.Errorf("%q has no port", target)
+		}
+		port = fmt.Sprint(number)
+	}
+	return net.JoinHostPort(u.Hostname(), port), nil
+}
+
+// icmpHost returns the host an icmp check pings: the target, or the host of
+// the target or entry URL
+func icmpHost(entry Entry) (string, error) {
+	target := entry.URL
+	if entry.Target != "" {
+		if !strings.Contains(entry.Target, "://") {
+			if host, _, err := net.SplitHostPort(entry.Target); err == nil {
+				return host, nil
+			}
+			return strings.Trim(entry.Target, "[]"), nil
+		}
+		target = entry.Target
+	}
+
+	u, err := url.Parse(target)
+	if err != nil {
+		return "", err
+	}
+	if u.Hostname() == "" {
+		return "", fmt.Errorf("%q has no host", target)
+	}
+	return u.Hostname(), nil
+}
@@ -0,0 +1,155 @@
+package status
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// ICMP message types used by ping
+const (
+	icmpEchoRequest   = 8
+	icmpEchoReply     = 0
+	icmpv6EchoRequest = 128
+	icmpv6EchoReply   = 129
+)
+
+// errICMPUnavailable is returned by ping when it cannot open an ICMP socket
+var errICMPUnavailable = errors.New("no ICMP socket available; allow unprivileged ping with the net.ipv4.ping_group_range sysctl or give HOPS the CAP_NET_RAW capability")
+
+// icmpPayload is the data sent in echo requests
+var icmpPayload = []byte("HOPS-StatusChecker")
+
+// icmpSeq numbers echo requests so replies to earlier ones are ignored
+var icmpSeq atomic.Uint32
+
+// ping sends an ICMP echo request to host, preferring its IPv4 address, and
+// returns the round trip time of the reply. It gives up when ctx is done.
+func ping(ctx context.Context, host string) (time.Duration, error) {
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return 0, err
+	}
+	if len(addrs) == 0 {
+		return 0, fmt.Errorf("no addresses for %s", host)
+	}
+	ip := addrs[0].IP
+	for _, addr := range addrs {
+		if addr.IP.To4() != nil {
+			ip = addr.IP
+			break
+		}
+	}
+	ipv4 := ip.To4() != nil
+
+	conn, dst, raw, err := listenICMP(ip)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	// Datagram sockets replace the identifier with their own and only
+	// receive replies to their own requests, so it is only checked on raw
+	// sockets, which receive every ICMP message
+	id := uint16(os.Getpid())
+	seq := uint16(icmpSeq.Add(1))
+
+	start := time.Now()
+	if _, err := conn.WriteTo(echoRequest(ipv4, id, seq), dst); err != nil {
+		return 0, err
+	}
+
+	reply := make([]byte, 1500)
+	for {
+		n, from, err := conn.ReadFrom(reply)
+		if err != nil {
+			return 0, err
+		}
+		if isEchoReply(reply[:n], ipv4, id, seq, raw) && addrIP(from).Equal(ip) {
+			return time.Since(start), nil
+		}
+	}
+}
+
+// listenICMP opens a socket for pinging ip, preferring an unprivileged
+// datagram socket and falling back to a raw one. It returns the socket, the
+// address to send to and whether the socket is raw.
+func listenICMP(ip net.IP) (net.PacketConn, net.Addr, bool, error) {
+	ipv4 := ip.To4() != nil
+	if conn, err := listenUnprivilegedICMP(ipv4); err == nil {
+		return conn, &net.UDPAddr{IP: ip}, false, nil
+	}
+
+	network := "ip6:ipv6-icmp"
+	if ipv4 {
+		network = "ip4:icmp"
+	}
+	conn, err := net.ListenPacket(network, "")
+	if err != nil {
+		return nil, nil, false, fmt.Errorf("%w: %v", errICMPUnavailable, err)
+	}
+	return conn, &net.IPAddr{IP: ip}, true, nil
+}
+
+// echoRequest builds an ICMP or ICMPv6 echo request
+func echoRequest(ipv4 bool, id, seq uint16) []byte {
+	msg := make([]byte, 8+len(icmpPayload))
+	msg[0] = icmpv6EchoRequest
+	if ipv4 {
+		msg[0] = icmpEchoRequest
+	}
+	binary.BigEndian.PutUint16(msg[4:], id)
+	binary.BigEndian.PutUint16(msg[6:], seq)
+	copy(msg[8:], icmpPayload)
+	// The kernel fills in ICMPv6 checksums
+	if ipv4 {
+		binary.BigEndian.PutUint16(msg[2:], icmpChecksum(msg))
+	}
+	return msg
+}
+
+// isEchoReply reports whether msg is the reply to the echo request with id
+// and seq, ignoring id unless checkID is set
+func isEchoReply(msg []byte, ipv4 bool, id, seq uint16, checkID bool) bool {
+	replyType := byte(icmpv6EchoReply)
+	if ipv4 {
+		replyType = icmpEchoReply
+	}
+	return len(msg) >= 8 && msg[0] == replyType &&
+		binary.BigEndian.Uint16(msg[6:]) == seq &&
+		(!checkID || binary.BigEndian.Uint16(msg[4:]) == id)
+}
+
+// icmpChecksum computes the Internet checksum of msg
+func icmpChecksum(msg []byte) uint16 {
+	var sum uint32
+	for i := 0; i+1 < len(msg); i += 2 {
+		sum += uint32(msg[i])<<8 | uint32(msg[i+1])
+	}
+	if len(msg)%2 == 1 {
+		sum += uint32(msg[len(msg)-1]) << 8
+	}
+	for sum>>16 != 0 {
+		sum = sum&0xffff + sum>>16
+	}
+	return ^uint16(sum)
+}
+
+// addrIP returns the IP address of a socket address
+func addrIP(addr net.Addr) net.IP {
+	switch addr := addr.(type) {
+	case *net.UDPAddr:
+		return addr.IP
+	case *net.IPAddr:
+		return addr.IP
+	}
+	return nil
+}
@@ -0,0 +1,28 @@
+//go:build linux
+
+package status
+
+import (
+	"net"
+	"os"
+	"syscall"
+)
+
+// listenUnprivilegedICMP opens an ICMP datagram ("ping") socket. These need
+// no privileges when the process's group is within the
+// net.ipv4.ping_group_range sysctl.
+func listenUnprivilegedICMP(ipv4 bool) (net.PacketConn, error) {
+	family, proto := syscall.AF_INET, syscall.IPPROTO_ICMP
+	if !ipv4 {
+		family, proto = syscall.AF_INET6, syscall.IPPROTO_ICMPV6
+	}
+	fd, err := syscall.Socket(family, syscall.SOCK_DGRAM|syscall.SOCK_CLOEXEC, proto)
+	if err != nil {
+		return nil, os.NewSyscallError("socket", err)
+	}
+
+	// FilePacketConn duplicates the descriptor, so the file is closed either way
+	f := os.NewFile(uintptr(fd), "icmp")
+	defer f.Close()
+	return net.FilePacketConn(f)
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+
+package status
+
+import (
+	"errors"
+	"net"
+)
+
+// listenUnprivilegedICMP is only supported on Linux; elsewhere ping uses raw
+// sockets
+func listenUnprivilegedICMP(ipv4 bool) (net.PacketConn, error) {
+	return nil, errors.New("unprivileged ICMP sockets are not supported on this platform")
+}
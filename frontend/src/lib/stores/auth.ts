@@ -1,5 +1,5 @@
 import { writable } from 'svelte/store';
-import { login as apiLogin, logout as apiLogout, getSessionToken as apiGetSessionToken } from '$lib/utils/api';
+import { login as apiLogin, logout as apiLogout, getSessionToken as apiGetSessionToken, setSessionToken, getCurrentUser } from '$lib/utils/api';
 import { toast } from './toast';
 
 // Re-export getSessionToken for components that need it
@@ -8,25 +8,75 @@ export const getSessionToken = apiGetSessionToken;
 // Auth state
 export const isAuthenticated = writable(false);
 export const isLoggingIn = writable(false);
+// Set while the signed-in user must choose a new password (e.g. the default admin account)
+export const mustChangePassword = writable(false);
+// Whether the signed-in user has the admin role, which admin-only tools such as the audit log need
+export const isAdmin = writable(false);
 
 // Check if user has a valid session on app load
 export function initAuth() {
+  handleSSORedirect();
   const token = apiGetSessionToken();
   isAuthenticated.set(!!token);
+  checkSession();
 }
 
-// Login
-export async function login(username: string, password: string) {
+// The session cookie is HttpOnly, so ask the server whether it is signed in. This also covers
+// a reverse proxy that authenticates users, where requests are signed in without a session.
+async function checkSession() {
+  try {
+    const user = await getCurrentUser();
+    isAuthenticated.set(true);
+    mustChangePassword.set(user.mustChangePassword);
+    isAdmin.set(user.role === 'admin');
+  } catch {
+    // Not signed in
+  }
+}
+
+// Single sign-on returns to the app with the outcome (or an error) in the URL fragment
+function handleSSORedirect() {
+  if (typeof window === 'undefined' || !window.location.hash.startsWith('#sso-')) return;
+
+  const params = new URLSearchParams(window.location.hash.slice(1));
+  const error = params.get('sso-error');
+
+  // Remove the outcome from the address bar and history
+  history.replaceState(history.state, '', window.location.pathname + window.location.search);
+
+  // The session itself was set as a cookie
+  if (params.has('sso-login')) {
+    setSessionToken(null);
+    toast.success('Logged in successfully');
+  } else if (error) {
+    toast.error(error);
+  }
+}
+
+// Login. Returns 'totp' when the account needs a two-factor code, which is sent with the next attempt.
+export async function login(username: string, password: string, code?: string): Promise<boolean | 'totp'> {
   isLoggingIn.set(true);
 
   try {
-    await apiLogin(username, password);
+    await apiLogin(username, password, code);
     isAuthenticated.set(true);
     toast.success('Logged in successfully');
+    await checkSession();
     return true;
   } catch (error) {
+    if (error instanceof Error && error.message === 'TOTP_REQUIRED') {
+      if (code) {
+        toast.error('Invalid two-factor code');
+      }
+      return 'totp';
+    }
     console.error('Login failed:', error);
-    toast.error('Invalid username or password');
+    // Rate limits and lockouts explain when to try again
+    if (error instanceof Error && error.message.startsWith('Too many')) {
+      toast.error(error.message);
+    } else {
+      toast.error('Invalid username or password');
+    }
     return false;
   } finally {
     isLoggingIn.set(false);
@@ -42,6 +92,8 @@ export async function logout() {
     console.error('Logout error:', error);
   } finally {
     isAuthenticated.set(false);
+    mustChangePassword.set(false);
+    isAdmin.set(false);
     // Edit mode will automatically disable via its subscription to isAuthenticated
   }
 }
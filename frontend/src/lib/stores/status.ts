@@ -6,17 +6,27 @@ export interface StatusInfo {
   lastChecked?: string;
 }
 
+interface StatusEvent extends StatusInfo {
+  entryId: string;
+}
+
 // Store for all entry statuses
 const statusStore = writable<Map<string, StatusInfo>>(new Map());
 
-// Polling interval in ms (30 seconds)
+// Statuses arrive from the server's event stream as checks finish. Without
+// EventSource, or while the stream is down, all statuses are polled at once
+// every 30 seconds instead.
 const POLL_INTERVAL = 30000;
 let pollInterval: ReturnType<typeof setInterval> | null = null;
+let eventSource: EventSource | null = null;
 const activeEntries = new Set<string>();
 
+// Query string limiting statuses to a share link's dashboard, set by share pages
+let scope = '';
+
 export async function fetchStatus(entryId: string): Promise<StatusInfo> {
   try {
-    const response = await fetch(`/api/status/${entryId}`);
+    const response = await fetch(`/api/status/${entryId}${scope}`);
     if (!response.ok) {
       return { status: 'unknown' };
     }
@@ -31,6 +41,76 @@ export async function fetchStatus(entryId: string): Promise<StatusInfo> {
   }
 }
 
+// setStatusShare limits statuses to the dashboard of a share link, so that
+// share pages get statuses for dashboards their visitors could not otherwise see
+export function setStatusShare(token: string | null) {
+  const newScope = token ? `?share=${encodeURIComponent(token)}` : '';
+  if (newScope !== scope) {
+    scope = newScope;
+    if (activeEntries.size > 0) {
+      stopUpdates();
+      startUpdates();
+    }
+  }
+}
+
+function setStatuses(events: StatusEvent[]) {
+  statusStore.update(store => {
+    const newStore = new Map(store);
+    for (const { entryId, ...status } of events) {
+      newStore.set(entryId, status);
+    }
+    return newStore;
+  });
+}
+
+async function pollAllStatuses() {
+  try {
+    const response = await fetch(`/api/status${scope}`);
+    if (response.ok) {
+      setStatuses(await response.json());
+    }
+  } catch (error) {
+    // Keep the last known statuses until the next poll
+  }
+}
+
+function startPolling() {
+  if (!pollInterval) {
+    pollAllStatuses();
+    pollInterval = setInterval(pollAllStatuses, POLL_INTERVAL);
+  }
+}
+
+function stopPolling() {
+  if (pollInterval) {
+    clearInterval(pollInterval);
+    pollInterval = null;
+  }
+}
+
+function startUpdates() {
+  if (typeof EventSource === 'undefined') {
+    startPolling();
+    return;
+  }
+
+  eventSource = new EventSource(`/api/status/stream${scope}`);
+  eventSource.addEventListener('status', (event) => {
+    setStatuses([JSON.parse((event as MessageEvent).data)]);
+  });
+  // The stream starts with every current status, so polling can stop
+  eventSource.onopen = stopPolling;
+  // EventSource reconnects by itself; poll meanwhile
+  eventSource.onerror = startPolling;
+}
+
+function stopUpdates() {
+  eventSource?.close();
+  eventSource = null;
+  stopPolling();
+}
+
 export function subscribeToStatus(entryId: string) {
   activeEntries.add(entryId);
 
@@ -43,42 +123,21 @@ export function subscribeToStatus(entryId: string) {
     return newStore;
   });
 
-  // Fetch immediately
-  fetchStatus(entryId).then(status => {
-    statusStore.update(store => {
-      const newStore = new Map(store);
-      newStore.set(entryId, status);
-      return newStore;
-    });
-  });
-
-  // Start polling if not already running
-  if (!pollInterval) {
-    pollInterval = setInterval(pollAllStatuses, POLL_INTERVAL);
+  // Start updates if not already running
+  if (!eventSource && !pollInterval) {
+    startUpdates();
   }
 
   return {
     unsubscribe: () => {
       activeEntries.delete(entryId);
-      if (activeEntries.size === 0 && pollInterval) {
-        clearInterval(pollInterval);
-        pollInterval = null;
+      if (activeEntries.size === 0) {
+        stopUpdates();
       }
     }
   };
 }
 
-async function pollAllStatuses() {
-  for (const entryId of activeEntries) {
-    const status = await fetchStatus(entryId);
-    statusStore.update(store => {
-      const newStore = new Map(store);
-      newStore.set(entryId, status);
-      return newStore;
-    });
-  }
-}
-
 export function getStatus(entryId: string): StatusInfo | undefined {
   return get(statusStore).get(entryId);
 }
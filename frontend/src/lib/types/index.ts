@@ -13,8 +13,15 @@ export interface Dashboard {
   };
   tabs: Tab[];
   order: number;
+  visibility?: DashboardVisibility; // Defaults to 'public'
+  allowedUsers?: string[]; // Usernames that can see a 'restricted' dashboard
+  allowedRoles?: UserRole[]; // Roles that can see a 'restricted' dashboard
 }
 
+export type DashboardVisibility = 'public' | 'authenticated' | 'restricted';
+
+export type UserRole = 'admin' | 'editor' | 'viewer';
+
 export interface HeaderConfig {
   leftText?: string;
   centerTitle?: string;
@@ -77,9 +84,11 @@ export interface Background {
 }
 
 export interface StatusCheck {
-  type: 'http' | 'icmp';
+  type: 'http' | 'icmp' | 'tcp';
   enabled: boolean;
-  interval: number;
+  interval: number; // seconds
+  target?: string; // checked instead of the entry URL: a URL, host or host:port
+  timeout?: number; // seconds
 }
 
 export interface StatusResult {
@@ -89,7 +98,30 @@ export interface StatusResult {
   lastChecked: Date;
 }
 
+export interface StatusHistory {
+  entryId: string;
+  uptime: { '24h': number | null; '7d': number | null; '30d': number | null }; // percentages
+  range: '24h' | '7d' | '30d';
+  latency: {
+    samples: number;
+    min: number;
+    avg: number;
+    p50: number;
+    p90: number;
+    p95: number;
+    p99: number;
+    max: number;
+  } | null; // milliseconds, of successful checks
+  series: {
+    time: string;
+    checks: number;
+    uptime: number | null;
+    responseTime: number | null;
+  }[];
+}
+
 export interface Config {
+  schemaVersion?: number; // Set by the server; see CurrentSchemaVersion in the Go models
   dashboards: Dashboard[];
   theme: Theme;
   settings: Settings;
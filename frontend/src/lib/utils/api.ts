@@ -1,8 +1,9 @@
-import type { Config } from '$lib/types';
+import type { Config, StatusHistory, UserRole } from '$lib/types';
 
 const API_BASE = import.meta.env.VITE_API_BASE || '/api';
 
-// Store session token
+// Store session token. Logins now keep the session in an HttpOnly cookie instead;
+// a stored token is only left over from before that and is used until it expires.
 let sessionToken: string | null = null;
 
 export function setSessionToken(token: string | null) {
@@ -21,18 +22,36 @@ export function getSessionToken(): string | null {
   return sessionToken;
 }
 
+// CSRF token the server sets alongside the session cookie, or for requests a reverse proxy signs in
+function getCSRFToken(): string | null {
+  if (typeof document === 'undefined') return null;
+  const match = document.cookie.match(/(?:^|;\s*)hops_csrf=([^;]*)/);
+  return match ? decodeURIComponent(match[1]) : null;
+}
+
+// Headers that authenticate a request: the stored session token if any, and the CSRF
+// token that requests which change something must echo when signed in with the cookie or by a proxy
+export function authHeaders(method: string = 'GET'): Record<string, string> {
+  const headers: Record<string, string> = {};
+  const token = getSessionToken();
+  if (token) {
+    headers['Authorization'] = `Bearer ${token}`;
+  }
+  const csrf = getCSRFToken();
+  if (csrf && !['GET', 'HEAD', 'OPTIONS'].includes(method.toUpperCase())) {
+    headers['X-CSRF-Token'] = csrf;
+  }
+  return headers;
+}
+
 // Helper to make authenticated requests
 async function fetchAPI(endpoint: string, options: RequestInit = {}) {
-  const token = getSessionToken();
   const headers: Record<string, string> = {
     'Content-Type': 'application/json',
+    ...authHeaders(options.method),
     ...(options.headers as Record<string, string> || {}),
   };
 
-  if (token) {
-    headers['Authorization'] = `Bearer ${token}`;
-  }
-
   const response = await fetch(`${API_BASE}${endpoint}`, {
     ...options,
     headers,
@@ -61,20 +80,66 @@ export async function getConfig(): Promise<Config> {
   return fetchAPI('/config');
 }
 
+// Load the single dashboard behind a share link, as a config containing only that dashboard
+export async function getSharedConfig(token: string): Promise<Config> {
+  return fetchAPI(`/share/${encodeURIComponent(token)}`);
+}
+
 export async function getStatus(entryId: string) {
   return fetchAPI(`/status/${entryId}`);
 }
 
+// Status history of an entry the user may see, or of one on a share link's dashboard
+export async function getStatusHistory(
+  entryId: string,
+  range: '24h' | '7d' | '30d' = '24h',
+  points = 48,
+  share?: string
+): Promise<StatusHistory> {
+  const shareQuery = share ? `&share=${encodeURIComponent(share)}` : '';
+  return fetchAPI(`/status/${entryId}/history?range=${range}&points=${points}${shareQuery}`);
+}
+
 // Auth API calls
 
-export async function login(username: string, password: string): Promise<{ sessionId: string }> {
-  const response = await fetchAPI('/auth/login', {
+// Log in, keeping the session in an HttpOnly cookie.
+// Throws TOTP_REQUIRED if the account needs a two-factor code (or the code was wrong),
+// and the server's message if there were too many attempts.
+export async function login(username: string, password: string, code?: string): Promise<void> {
+  const response = await fetch(`${API_BASE}/auth/login`, {
     method: 'POST',
-    body: JSON.stringify({ username, password }),
+    headers: { 'Content-Type': 'application/json' },
+    body: JSON.stringify({ username, password, code, useCookie: true }),
   });
 
-  setSessionToken(response.sessionId);
-  return response;
+  if (!response.ok) {
+    if (response.status === 429) {
+      throw new Error((await response.text()).trim() || 'Too many login attempts. Please try again later.');
+    }
+    const body = await response.json().catch(() => null);
+    if (body?.totpRequired) {
+      throw new Error('TOTP_REQUIRED');
+    }
+    throw new Error(`API error: ${response.status} ${response.statusText}`);
+  }
+
+  // Forget any token stored by an older version
+  setSessionToken(null);
+}
+
+// The signed-in user. Also works without a session when a trusted reverse proxy authenticates the request.
+export async function getCurrentUser(): Promise<{ id: number; username: string; role: UserRole; mustChangePassword: boolean }> {
+  return fetchAPI('/auth/me');
+}
+
+// Which sign-in methods the server offers
+export async function getAuthProviders(): Promise<{ password: boolean; oidc: boolean; proxy: boolean }> {
+  return fetchAPI('/auth/providers');
+}
+
+// URL that starts single sign-on and returns to the given path afterwards
+export function getOIDCLoginURL(redirect: string = '/'): string {
+  return `${API_BASE}/auth/oidc/login?redirect=${encodeURIComponent(redirect)}`;
 }
 
 export async function logout(): Promise<void> {
@@ -82,11 +147,168 @@ export async function logout(): Promise<void> {
   setSessionToken(null);
 }
 
+// Changing the password signs out every session, so the response carries a new one for this browser.
+// Throws the server's message if the new password breaks the password policy.
 export async function changePassword(oldPassword: string, newPassword: string): Promise<void> {
-  await fetchAPI('/auth/change-password', {
+  const response = await fetch(`${API_BASE}/auth/change-password`, {
     method: 'POST',
+    headers: { 'Content-Type': 'application/json', ...authHeaders('POST') },
     body: JSON.stringify({ oldPassword, newPassword }),
   });
+
+  if (!response.ok) {
+    const errorText = await response.text();
+    throw new Error(errorText.trim() || `Failed to change password: ${response.statusText}`);
+  }
+
+  const data = await response.json();
+  if (data.sessionId) {
+    setSessionToken(data.sessionId);
+  }
+}
+
+// Sessions
+
+export interface Session {
+  id: string;
+  ip: string;
+  userAgent: string;
+  current: boolean;
+  createdAt: string;
+  lastSeenAt: string;
+  expiresAt: string;
+}
+
+export async function getSessions(): Promise<Session[]> {
+  return fetchAPI('/auth/sessions');
+}
+
+export async function revokeSession(id: string): Promise<void> {
+  await fetchAPI(`/auth/sessions/${encodeURIComponent(id)}`, { method: 'DELETE' });
+}
+
+// Log out everywhere except this browser
+export async function revokeOtherSessions(): Promise<{ revoked: number }> {
+  return fetchAPI('/auth/sessions', { method: 'DELETE' });
+}
+
+// Two-factor authentication
+
+export async function getTOTPStatus(): Promise<{ enabled: boolean; recoveryCodesRemaining: number }> {
+  return fetchAPI('/auth/totp');
+}
+
+// Start enrolment: returns the secret and the otpauth:// URI for authenticator apps
+export async function enrollTOTP(): Promise<{ secret: string; uri: string }> {
+  return fetchAPI('/auth/totp/enroll', { method: 'POST' });
+}
+
+// Finish enrolment with a code from the authenticator app; returns the one-time recovery codes
+export async function enableTOTP(code: string): Promise<{ recoveryCodes: string[] }> {
+  return fetchAPI('/auth/totp/enable', {
+    method: 'POST',
+    body: JSON.stringify({ code }),
+  });
+}
+
+export async function disableTOTP(code: string): Promise<void> {
+  await fetchAPI('/auth/totp', {
+    method: 'DELETE',
+    body: JSON.stringify({ code }),
+  });
+}
+
+export async function regenerateRecoveryCodes(code: string): Promise<{ recoveryCodes: string[] }> {
+  return fetchAPI('/auth/totp/recovery-codes', {
+    method: 'POST',
+    body: JSON.stringify({ code }),
+  });
+}
+
+// Audit log (admin)
+
+export interface AuditEntry {
+  id: number;
+  userId?: number;
+  username: string;
+  ip: string;
+  action: string;
+  target?: string;
+  summary?: string;
+  status: number;
+  createdAt: string;
+}
+
+export interface AuditFilter {
+  user?: string;
+  action?: string; // an exact action, or a prefix such as "config" for config.*
+  target?: string;
+  since?: string; // RFC 3339
+  until?: string;
+  limit?: number;
+  offset?: number;
+}
+
+export async function getAuditLog(filter: AuditFilter = {}): Promise<{ entries: AuditEntry[]; total: number }> {
+  const params = new URLSearchParams();
+  for (const [key, value] of Object.entries(filter)) {
+    if (value !== undefined && value !== '') {
+      params.set(key, String(value));
+    }
+  }
+  return fetchAPI(`/audit?${params}`);
+}
+
+// Status notification channels (admin)
+
+export type NotificationChannelType = 'webhook' | 'ntfy' | 'gotify' | 'email' | 'url';
+
+export interface NotificationChannel {
+  id: number;
+  name: string;
+  type: NotificationChannelType;
+  settings: Record<string, string>; // secrets come back as ******** and are kept if sent back unchanged
+  entryIds: string[];
+  groupIds: string[]; // with entryIds empty too, every entry is notified
+  notifyRecovery: boolean;
+  enabled: boolean;
+  createdAt: string;
+}
+
+export type NotificationChannelInput = Omit<NotificationChannel, 'id' | 'createdAt'>;
+
+// Like fetchAPI, but throws the server's message, which explains invalid settings and failed tests
+async function notificationRequest(endpoint: string, method: string, body?: unknown) {
+  const response = await fetch(`${API_BASE}/notifications${endpoint}`, {
+    method,
+    headers: { 'Content-Type': 'application/json', ...authHeaders(method) },
+    body: body === undefined ? undefined : JSON.stringify(body),
+  });
+  if (!response.ok) {
+    throw new Error((await response.text()).trim() || `API error: ${response.status}`);
+  }
+  return response.json();
+}
+
+export async function getNotificationChannels(): Promise<NotificationChannel[]> {
+  return notificationRequest('', 'GET');
+}
+
+export async function createNotificationChannel(channel: NotificationChannelInput): Promise<NotificationChannel> {
+  return notificationRequest('', 'POST', channel);
+}
+
+export async function updateNotificationChannel(id: number, channel: NotificationChannelInput): Promise<NotificationChannel> {
+  return notificationRequest(`/${id}`, 'PUT', channel);
+}
+
+export async function deleteNotificationChannel(id: number): Promise<void> {
+  await notificationRequest(`/${id}`, 'DELETE');
+}
+
+// Sends a test notification through a saved channel
+export async function testNotificationChannel(id: number): Promise<void> {
+  await notificationRequest(`/${id}/test`, 'POST');
 }
 
 // Admin API calls
@@ -99,14 +321,13 @@ export async function updateConfig(config: Config): Promise<void> {
 }
 
 export async function exportConfig(format: 'json' | 'yaml' = 'json', dashboardId?: string): Promise<Blob> {
-  const token = getSessionToken();
   let url = `${API_BASE}/config/export?format=${format}`;
   if (dashboardId) {
     url += `&dashboardId=${encodeURIComponent(dashboardId)}`;
   }
 
   const response = await fetch(url, {
-    headers: token ? { 'Authorization': `Bearer ${token}` } : {},
+    headers: authHeaders(),
   });
 
   if (!response.ok) {
@@ -117,7 +338,6 @@ export async function exportConfig(format: 'json' | 'yaml' = 'json', dashboardId
 }
 
 export async function importConfig(file: File, options?: { autoMatchIcons?: boolean }): Promise<{ success: boolean; message: string }> {
-  const token = getSessionToken();
   const formData = new FormData();
   formData.append('file', file);
   if (options?.autoMatchIcons) {
@@ -126,7 +346,7 @@ export async function importConfig(file: File, options?: { autoMatchIcons?: bool
 
   const response = await fetch(`${API_BASE}/config/import`, {
     method: 'POST',
-    headers: token ? { 'Authorization': `Bearer ${token}` } : {},
+    headers: authHeaders('POST'),
     body: formData,
   });
 
@@ -193,12 +413,9 @@ export async function uploadIconImage(file: File): Promise<{ url: string; id: st
   const formData = new FormData();
   formData.append('file', file);
 
-  const token = getSessionToken();
   const response = await fetch('/api/icons/upload', {
     method: 'POST',
-    headers: {
-      'Authorization': `Bearer ${token}`
-    },
+    headers: authHeaders('POST'),
     body: formData
   });
 
@@ -256,14 +473,13 @@ export async function getBackgrounds(): Promise<BackgroundsResponse> {
 }
 
 export async function uploadBackground(file: File, category: string): Promise<BackgroundImage> {
-  const token = getSessionToken();
   const formData = new FormData();
   formData.append('file', file);
   formData.append('category', category);
 
   const response = await fetch(`${API_BASE}/backgrounds`, {
     method: 'POST',
-    headers: token ? { 'Authorization': `Bearer ${token}` } : {},
+    headers: authHeaders('POST'),
     body: formData,
   });
 